@@ -16,6 +16,61 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 2048
+
+	// Max time after sending while the sender can still edit a message
+	editMessageWindow = 15 * time.Minute
+
+	// Max bytes of a reaction, enough for emoji made of several code points
+	maxReactionSize = 32
+
+	// Typing and recording signals allowed per client, per second and in a burst
+	signalRate  = 2
+	signalBurst = 4
+
+	// How often expired disappearing messages are deleted, and how many at most each time
+	expirySweepInterval = 30 * time.Second
+	expirySweepBatch    = 500
+
+	// Max messages and rooms of one forward
+	maxForwardMessages = 20
+	maxForwardRooms    = 10
+
+	// Max members notified of a message by their @username
+	maxMentions = 20
+
+	// Max times a client tries to join a room which stops meanwhile
+	maxJoinAttempts = 3
+
+	// Max bytes of the id a client gives a message it sends
+	maxClientIdSize = 64
+
+	// How often the offline events older than their window are deleted
+	eventCompactInterval = time.Hour
+
+	// Every node sends the users connected to it every presenceHeartbeat, the users of a node
+	// not heard of for presenceTTL are taken offline
+	presenceHeartbeat = 10 * time.Second
+	presenceTTL       = 3 * presenceHeartbeat
+
+	// Sent messages are saved in batches of at most persistBatchSize, or every persistFlushInterval,
+	// a full queue refuses new messages
+	persistQueueSize     = 4096
+	persistBatchSize     = 100
+	persistFlushInterval = time.Second
+
+	// A batch failing persistRetries times goes to the dead letter file, which is saved again
+	// every deadLetterReplayInterval
+	persistRetries           = 5
+	persistRetryBackoff      = 200 * time.Millisecond
+	persistMaxBackoff        = 5 * time.Second
+	deadLetterReplayInterval = 5 * time.Minute
+
+	// Max frames of a session the client has not acked, and how long a dropped session can be resumed
+	maxSessionFrames    = 1024
+	sessionResumeWindow = 30 * time.Second
+
+	// A room run for a lookup which no client joins stops after roomIdleTimeout
+	roomIdleTimeout = time.Minute
 )
 
 var (
@@ -37,6 +92,38 @@ const (
 	GetMessages           Action = "get-msg"
 	Delivered             Action = "delv"
 	HasBeenRead           Action = "read"
+	ReceivedAction        Action = "recv"
+	EditMessageAction     Action = "edit-msg"
+	DeleteMessageAction   Action = "delete-msg"
+	GetThread             Action = "get-thread"
+	ReactAction           Action = "react"
+	UnreactAction         Action = "unreact"
+	TypingStartAction     Action = "typing-start"
+	TypingStopAction      Action = "typing-stop"
+	RecordingAudioAction  Action = "recording-audio"
+	PresenceAction        Action = "presence"
+	SearchMessagesAction  Action = "search-msg"
+	ExpiredAction         Action = "msg-expired"
+	PinAction             Action = "pin"
+	UnpinAction           Action = "unpin"
+	ForwardMessageAction  Action = "forward-msg"
+	MentionAction         Action = "mention"
+	ContactAction         Action = "contact"
+	ReplayAction          Action = "replay"
+	SessionAction         Action = "session"
+	AckAction             Action = "ack"
+	PresenceSyncAction    Action = "presence-sync"
+)
+
+// type of a send-message, a text message has none
+const (
+	VoiceMessage = "voice"
+)
+
+// scope of delete-msg, sent as the message content
+const (
+	DeleteForEveryone = "everyone"
+	DeleteForMe       = "me"
 )
 
 type I_User interface {
@@ -0,0 +1,321 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/contacts"
+	"pesatu/components/presence"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+)
+
+// onlineUser is a user connected to any node, with the count of its connections by node
+type onlineUser struct {
+	user  I_User
+	conns map[string]int
+	mode  string
+}
+
+// presenceEntry is a user connected to a node, as sent in the presence-sync of the node
+type presenceEntry struct {
+	UID    string  `json:"uid"`
+	Sender *Sender `json:"sender"`
+	Mode   string  `json:"mode"`
+	Conns  int     `json:"conns"`
+}
+
+// IsOnline tells whether the user has a connection on any node, it is safe to call from any goroutine
+func (server *WsServer) IsOnline(uid string) bool {
+	server.onlineMu.RLock()
+	defer server.onlineMu.RUnlock()
+
+	_, ok := server.online[uid]
+	return ok
+}
+
+// isShownOnline tells whether the user is online and not invisible to the others
+func (server *WsServer) isShownOnline(uid string) bool {
+	server.onlineMu.RLock()
+	defer server.onlineMu.RUnlock()
+
+	o, ok := server.online[uid]
+	return ok && o.mode != presence.Invisible
+}
+
+// NotifyModeChanged publishes the new mode of a user to every node, it is safe to call from any goroutine
+func (server *WsServer) NotifyModeChanged(uid, username, mode string) {
+	server.publish(NewPubSubMessage(PresenceAction, mode, NewSender(uid, "", username, "")))
+}
+
+func (server *WsServer) findPresenceMode(uid string) string {
+	p, err := server.presenceRepository.FindPresence(uid)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while finding presence of %s", uid))
+		return presence.Available
+	}
+
+	return p.Mode
+}
+
+func (server *WsServer) handleUserJoined(message *PubSubMessage) {
+	user := message.GetSender()
+
+	server.onlineMu.Lock()
+	server.nodes[message.Node] = time.Now()
+	o, ok := server.online[user.GetUID()]
+	if !ok {
+		o = &onlineUser{user: user, conns: make(map[string]int)}
+		server.online[user.GetUID()] = o
+	}
+	o.conns[message.Node]++
+	o.mode = message.Message
+	server.onlineMu.Unlock()
+
+	// the other devices of the user are already shown
+	if ok || o.mode == presence.Invisible {
+		return
+	}
+
+	server.notifyContacts(user, UserJoinedAction, o.mode)
+}
+
+func (server *WsServer) handleUserLeft(message *PubSubMessage) {
+	server.onlineMu.Lock()
+	o, ok := server.online[message.SenderID]
+	if !ok {
+		server.onlineMu.Unlock()
+		return
+	}
+	if o.conns[message.Node]--; o.conns[message.Node] <= 0 {
+		delete(o.conns, message.Node)
+	}
+	if len(o.conns) > 0 {
+		server.onlineMu.Unlock()
+		return
+	}
+	delete(server.online, message.SenderID)
+	server.onlineMu.Unlock()
+
+	if o.mode == presence.Invisible {
+		return
+	}
+
+	server.notifyContacts(o.user, UserLeftAction, "")
+}
+
+// handlePresenceChanged shows or hides a user going in or out of invisible, other modes are just updated
+func (server *WsServer) handlePresenceChanged(message *PubSubMessage) {
+	server.onlineMu.Lock()
+	o, ok := server.online[message.SenderID]
+	if !ok {
+		server.onlineMu.Unlock()
+		return
+	}
+	old := o.mode
+	o.mode = message.Message
+	server.onlineMu.Unlock()
+
+	switch {
+	case old == o.mode:
+		return
+	case o.mode == presence.Invisible:
+		server.notifyContacts(o.user, UserLeftAction, "")
+	case old == presence.Invisible:
+		server.notifyContacts(o.user, UserJoinedAction, o.mode)
+	default:
+		server.notifyContacts(o.user, PresenceAction, o.mode)
+	}
+}
+
+// publishPresence sends the users connected to this node to every node
+func (server *WsServer) publishPresence() {
+	server.clientsMu.RLock()
+	entries := make([]*presenceEntry, 0, len(server.clients))
+	for uid, clients := range server.clients {
+		for client := range clients {
+			entries = append(entries, &presenceEntry{UID: uid, Sender: NewSender(uid, client.Name, client.Username, client.Avatar), Conns: len(clients)})
+			break
+		}
+	}
+	server.clientsMu.RUnlock()
+
+	// the user-join of a client registered just now may not be back from the broker yet
+	server.onlineMu.RLock()
+	var unknown []*presenceEntry
+	for _, e := range entries {
+		if o, ok := server.online[e.UID]; ok {
+			e.Mode = o.mode
+		} else {
+			unknown = append(unknown, e)
+		}
+	}
+	server.onlineMu.RUnlock()
+
+	for _, e := range unknown {
+		e.Mode = server.findPresenceMode(e.UID)
+	}
+
+	server.publish(&PubSubMessage{Action: PresenceSyncAction, Presence: entries})
+}
+
+// handlePresenceSync replaces the connections of a node by the ones of its snapshot, the users now online
+// are shown and the ones left on no node are hidden. A node heard of for the first time gets the snapshot
+// of this node, so a node which starts learns who is online.
+func (server *WsServer) handlePresenceSync(message *PubSubMessage) {
+	snapshot := make(map[string]*presenceEntry, len(message.Presence))
+	for _, e := range message.Presence {
+		snapshot[e.UID] = e
+	}
+
+	server.onlineMu.Lock()
+	_, known := server.nodes[message.Node]
+	server.nodes[message.Node] = time.Now()
+
+	left := server.dropNode(message.Node, snapshot)
+
+	var joined []*onlineUser
+	for uid, e := range snapshot {
+		o, ok := server.online[uid]
+		if !ok {
+			user := NewSender(uid, "", "", "")
+			if e.Sender != nil {
+				user = NewSender(uid, e.Sender.Name, e.Sender.Username, e.Sender.Avatar)
+			}
+			o = &onlineUser{user: user, conns: make(map[string]int), mode: e.Mode}
+			server.online[uid] = o
+			joined = append(joined, o)
+		}
+		o.conns[message.Node] = e.Conns
+	}
+	server.onlineMu.Unlock()
+
+	server.notifyOnlineChanged(joined, left)
+
+	if !known && message.Node != server.node {
+		server.publishPresence()
+	}
+}
+
+// expireNodes takes offline the users of the nodes which stopped without telling the others
+func (server *WsServer) expireNodes() {
+	var left []*onlineUser
+
+	server.onlineMu.Lock()
+	for node, seen := range server.nodes {
+		if node == server.node || time.Since(seen) < presenceTTL {
+			continue
+		}
+		utils.Log().Info(fmt.Sprintf("node %s is gone, its users are offline", node))
+		delete(server.nodes, node)
+		left = append(left, server.dropNode(node, nil)...)
+	}
+	server.onlineMu.Unlock()
+
+	server.notifyOnlineChanged(nil, left)
+}
+
+// dropNode removes the connections of node, except the ones of the users in keep,
+// and returns the users left on no node. onlineMu must be held.
+func (server *WsServer) dropNode(node string, keep map[string]*presenceEntry) []*onlineUser {
+	var left []*onlineUser
+	for uid, o := range server.online {
+		if _, ok := keep[uid]; ok {
+			continue
+		}
+		if _, ok := o.conns[node]; !ok {
+			continue
+		}
+		delete(o.conns, node)
+		if len(o.conns) == 0 {
+			delete(server.online, uid)
+			left = append(left, o)
+		}
+	}
+	return left
+}
+
+func (server *WsServer) notifyOnlineChanged(joined, left []*onlineUser) {
+	for _, o := range joined {
+		if o.mode != presence.Invisible {
+			server.notifyContacts(o.user, UserJoinedAction, o.mode)
+		}
+	}
+	for _, o := range left {
+		if o.mode != presence.Invisible {
+			server.notifyContacts(o.user, UserLeftAction, "")
+		}
+	}
+}
+
+// notifyContacts sends a presence change of user to the local clients which have it as accepted contact
+func (server *WsServer) notifyContacts(user I_User, action, mode string) {
+	if !server.hasLocalClients() || server.contactRepository == nil {
+		return
+	}
+
+	uids, err := server.contactRepository.FindContactUIDs(user.GetUID(), contacts.Accepted)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while finding contacts of %s", user.GetUsername()))
+		return
+	}
+
+	if len(uids) == 0 {
+		return
+	}
+
+	m, err := jsonrpc2.Notify(action, &Message{
+		Action:  action,
+		Message: mode,
+		Sender:  user,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create presence notify")
+		return
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("notify %s %s to its contacts", action, user.GetUsername()))
+	for _, uid := range uids {
+		for _, client := range server.findClientByID(uid) {
+			client.SendMsg(m.Encode())
+		}
+	}
+}
+
+// listOnlineClients tells a new connection which of its accepted contacts are online
+func (server *WsServer) listOnlineClients(client *Client) {
+	if server.contactRepository == nil {
+		return
+	}
+
+	uids, err := server.contactRepository.FindContactUIDs(client.GetUID(), contacts.Accepted)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while finding contacts of %s", client.GetUsername()))
+		return
+	}
+
+	var online []*onlineUser
+	server.onlineMu.RLock()
+	for _, uid := range uids {
+		if o, ok := server.online[uid]; ok && o.mode != presence.Invisible {
+			online = append(online, &onlineUser{user: o.user, mode: o.mode})
+		}
+	}
+	server.onlineMu.RUnlock()
+
+	for _, o := range online {
+		m, err := jsonrpc2.Notify(UserJoinedAction, &Message{
+			Action:  UserJoinedAction,
+			Message: o.mode,
+			Sender:  o.user,
+			Time:    time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			utils.Log().Error(err, "error while create presence notify")
+			return
+		}
+
+		utils.Log().V(2).Info(fmt.Sprintf("Tell %s existing User Joined %s", client.Name, o.user.GetUsername()))
+		client.SendMsg(m.Encode())
+	}
+}
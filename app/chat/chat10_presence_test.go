@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"encoding/json"
+	"pesatu/components/presence"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_InvisibleShownOffline(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+
+	user := NewSender("uid", "name", "user", "")
+	server.handleUserJoined(NewPubSubMessage(UserJoinedAction, presence.Invisible, user))
+	asserts.NotNil(server.findUserByID("uid"))
+	asserts.False(server.isShownOnline("uid"))
+
+	server.handlePresenceChanged(NewPubSubMessage(PresenceAction, presence.Available, user))
+	asserts.True(server.isShownOnline("uid"))
+}
+
+func Test_PresenceNodes(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+
+	fromNode := func(node string, message *PubSubMessage) *PubSubMessage {
+		message.Node = node
+		return message
+	}
+
+	a := NewSender("a", "", "a", "")
+	b := NewSender("b", "", "b", "")
+	server.handleUserJoined(fromNode("n1", NewPubSubMessage(UserJoinedAction, presence.Available, a)))
+	server.handleUserJoined(fromNode("n2", NewPubSubMessage(UserJoinedAction, presence.Available, a)))
+	server.handleUserJoined(fromNode("n2", NewPubSubMessage(UserJoinedAction, presence.Available, b)))
+
+	// the snapshot of n2 replaces its connections, a user-left of b was lost
+	server.handlePresenceSync(fromNode("n2", &PubSubMessage{Action: PresenceSyncAction}))
+	asserts.True(server.IsOnline("a"))
+	asserts.False(server.IsOnline("b"))
+
+	// n1 stops sending its heartbeat
+	server.nodes["n1"] = time.Now().Add(-presenceTTL)
+	server.expireNodes()
+	asserts.False(server.IsOnline("a"))
+
+	// a node which starts gets the users of n3 and is sent the snapshot of this node
+	received := make(chan []byte, 1)
+	_, err := server.broker.Subscribe(PubSubGeneralChannel, func(payload []byte) { received <- payload })
+	asserts.Nil(err)
+	server.handlePresenceSync(fromNode("n3", &PubSubMessage{Action: PresenceSyncAction, Presence: []*presenceEntry{
+		{UID: "c", Sender: NewSender("c", "", "c", ""), Mode: presence.Available, Conns: 2},
+	}}))
+	asserts.True(server.IsOnline("c"))
+
+	var reply PubSubMessage
+	asserts.Nil(json.Unmarshal(<-received, &reply))
+	asserts.Equal(PresenceSyncAction, reply.Action)
+	asserts.Equal("local", reply.Node)
+}
@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"pesatu/components/eventlog"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// isAfterMark tells whether id is newer than the mark of a member
+func isAfterMark(id primitive.ObjectID, mark *primitive.ObjectID) bool {
+	return mark == nil || bytes.Compare(id[:], mark[:]) > 0
+}
+
+// handleReceipt marks the messages of a room up to message.Id as received (recv) or read,
+// the room is taken from the message in the database and the sender must be a member of it
+func (me *Client) handleReceipt(message Message) {
+	// older clients send the message id as the content of read
+	msgId := message.Id
+	if len(msgId) == 0 {
+		msgId = message.Message
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("%s up to %s by %s", message.Action, msgId, me.GetUsername()))
+
+	dbMsg, err := me.wsServer.msgRepository.FindMessageById(msgId)
+	if err != nil {
+		me.notifyInfo(nil, me, message.Action+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	read := message.Action == HasBeenRead
+	member, err := me.wsServer.roomRepository.MoveReceiptMark(dbMsg.RoomId, me.GetUID(), dbMsg.Id, read)
+	if err != nil {
+		me.notifyInfo(nil, me, message.Action+", you are not a member of this room", "error", message.Time)
+		return
+	}
+
+	mark := member.DeliveredUpTo
+	if read {
+		mark = member.ReadUpTo
+	}
+
+	// already marked, by another device of the user
+	if !isAfterMark(dbMsg.Id, mark) {
+		return
+	}
+
+	marked, err := me.wsServer.msgRepository.AddReceipts(dbMsg.RoomId, me.GetUID(), mark, dbMsg.Id, read)
+	if err != nil {
+		me.notifyInfo(nil, me, message.Action+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	if len(marked) == 0 {
+		return
+	}
+
+	// status of the message stays for the clients that do not read receipts, in a private room only,
+	// in a group the first reader would mark it read for every member
+	if read {
+		if dbRoom, err := me.wsServer.roomRepository.FindRoomByUID(dbMsg.RoomId); err == nil && dbRoom.GetPrivate() {
+			if err := me.wsServer.msgRepository.UpdateStatus(marked, HasBeenRead); err != nil {
+				utils.Log().Error(err, "error while save status msessages into database")
+			}
+		}
+	}
+
+	me.wsServer.recordOffline(dbMsg.RoomId, &eventlog.CreateEvent{Action: message.Action, MsgId: dbMsg.Id.Hex(), Actor: me.GetUsername(), Time: time.Now()})
+
+	room := me.wsServer.findRoomByID(dbMsg.RoomId)
+	if room == nil {
+		id, _ := uuid.Parse(dbMsg.RoomId)
+		room = &Room{ID: id}
+	}
+
+	m, err := jsonrpc2.Notify(message.Action, &Message{
+		Id:      dbMsg.Id.Hex(),
+		Action:  message.Action,
+		Message: dbMsg.Id.Hex(),
+		Target:  room,
+		Sender:  me,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create receipt notify")
+		return
+	}
+
+	// the room may not run on this node, the members are reached through the broker
+	err = me.wsServer.broker.Publish(roomChannel(dbMsg.RoomId), m.Encode())
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing %s to room %s", message.Action, dbMsg.RoomId))
+	}
+}
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"pesatu/components/eventlog"
+	"pesatu/components/messageDB"
+	"pesatu/components/room"
+	"pesatu/components/roommember"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// receiptRepo is a room whose member moves their marks freely
+type receiptRepo struct {
+	roommember.I_RoomMember
+	private bool
+}
+
+func (me *receiptRepo) MoveReceiptMark(roomId, userId string, msgId primitive.ObjectID, read bool) (*roommember.DBMember, error) {
+	return &roommember.DBMember{RoomID: roomId, UserID: userId}, nil
+}
+
+func (me *receiptRepo) FindRoomByUID(uid string) (*room.Room, error) {
+	return &room.Room{UID: uid, Private: me.private, Group: !me.private}, nil
+}
+
+func (me *receiptRepo) FindMembers(roomId string, page int, limit int) ([]*roommember.DBMember, error) {
+	return nil, nil
+}
+
+// statusRepo keeps the receipts of one message and whether its status was set
+type statusRepo struct {
+	messageDB.I_MessageRepo
+	msg    *messageDB.DBMessage
+	status string
+}
+
+func (me *statusRepo) FindMessageById(msgId string) (*messageDB.DBMessage, error) {
+	return me.msg, nil
+}
+
+func (me *statusRepo) AddReceipts(roomId, userId string, after *primitive.ObjectID, upTo primitive.ObjectID, read bool) ([]*primitive.ObjectID, error) {
+	return []*primitive.ObjectID{&upTo}, nil
+}
+
+func (me *statusRepo) UpdateStatus(msgId []*primitive.ObjectID, status string) error {
+	me.status = status
+	return nil
+}
+
+// eventRepo drops the offline events
+type eventRepo struct {
+	eventlog.I_EventRepo
+}
+
+func (me *eventRepo) AddEvents(events []*eventlog.CreateEvent) error {
+	return nil
+}
+
+func Test_ReceiptStatus(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	server.eventRepository = &eventRepo{}
+	client := newTestClient(server)
+
+	for _, private := range []bool{false, true} {
+		repo := &statusRepo{msg: &messageDB.DBMessage{Id: primitive.NewObjectID(), RoomId: uuid.New().String()}}
+		server.msgRepository = repo
+		server.roomRepository = &receiptRepo{private: private}
+
+		client.handleReceipt(Message{Id: repo.msg.Id.Hex(), Action: HasBeenRead})
+
+		// one reader of a group does not read the message for the others
+		if private {
+			asserts.Equal(HasBeenRead, repo.status)
+		} else {
+			asserts.Empty(repo.status)
+		}
+	}
+}
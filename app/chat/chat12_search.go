@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+)
+
+// handleSearchMessages sends the messages found by message.Search in the rooms of the client
+func (me *Client) handleSearchMessages(message Message) {
+	if message.Search == nil {
+		me.notifyInfo(nil, me, SearchMessagesAction+", search is required", "error", message.Time)
+		return
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("search msg %q by %s", message.Search.Query, me.GetUsername()))
+
+	page, rpcErr, _ := me.wsServer.msgController.Search(me.GetUID(), message.Search)
+	if rpcErr != nil {
+		me.notifyInfo(nil, me, SearchMessagesAction+", "+rpcErr.Message, "error", message.Time)
+		return
+	}
+
+	m, err := jsonrpc2.Notify(SearchMessagesAction, Messages{
+		Action:   SearchMessagesAction,
+		Sender:   me,
+		Messages: page,
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create jsonrpc2 notify")
+		return
+	}
+	me.SendMsg(m.Encode())
+}
@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"pesatu/components/attachment"
+	"pesatu/components/messageDB"
+)
+
+// checkAttachments replaces the attachment ids of a message with what was uploaded by the sender to the room
+func (me *Client) checkAttachments(room *Room, message *Message) bool {
+	var ids []string
+	for _, a := range message.Attachments {
+		if a == nil {
+			continue
+		}
+		ids = append(ids, a.Id)
+	}
+
+	attachments, err := me.wsServer.attachments.FindRoomAttachments(me.GetUID(), room.GetId(), ids)
+	if err != nil {
+		me.notifyInfo(room, me, SendMessageAction+", "+err.Error(), "error", message.Time)
+		return false
+	}
+
+	message.Attachments = make([]*messageDB.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		message.Attachments = append(message.Attachments, a.ToAttachment())
+	}
+
+	return true
+}
+
+// checkMessageType makes sure a voice message is one voice note, its text is an optional caption
+func (me *Client) checkMessageType(room *Room, message Message) bool {
+	switch message.Type {
+	case VoiceMessage:
+		if len(message.Attachments) != 1 || message.Attachments[0].Kind != attachment.Voice {
+			me.notifyInfo(room, me, SendMessageAction+", voice message needs one voice note", "error", message.Time)
+			return false
+		}
+		return true
+	}
+
+	me.notifyInfo(room, me, SendMessageAction+", unknown message type "+message.Type, "error", message.Time)
+	return false
+}
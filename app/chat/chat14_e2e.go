@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"pesatu/components/e2ekey"
+	"pesatu/components/messageDB"
+	"strings"
+)
+
+const (
+	// envelopes of a message, enough for every device of both members of a private room
+	maxEnvelopes = 2 * e2ekey.MaxDevices
+
+	// max bytes of the decoded ciphertext of an envelope
+	maxCiphertextSize = 16 * 1024
+)
+
+// checkEnvelopes makes sure an encrypted message only carries ciphertext, in a private room,
+// addressed to devices of the room members. The ciphertext itself is opaque to the server.
+func (me *Client) checkEnvelopes(room *Room, message Message) bool {
+	fail := func(msg string) bool {
+		me.notifyInfo(room, me, SendMessageAction+", "+msg, "error", message.Time)
+		return false
+	}
+
+	if !room.Private || room.Group {
+		return fail("encryption is only available in private rooms")
+	}
+
+	if len(strings.TrimSpace(message.Message)) > 0 {
+		return fail("encrypted message can not have plain text")
+	}
+
+	if err := e2ekey.CheckDevice(message.SenderDevice); err != nil {
+		return fail("sender device: " + err.Error())
+	}
+
+	if len(message.Envelopes) == 0 || len(message.Envelopes) > maxEnvelopes {
+		return fail(fmt.Sprintf("send 1 to %d envelopes", maxEnvelopes))
+	}
+
+	members, err := me.wsServer.roomRepository.FindGroupMembers(room.GetId(), 1, 2)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	usernames := make(map[string]bool)
+	for _, member := range members {
+		usernames[member.Username] = true
+	}
+
+	devices := make(map[string]bool)
+	for _, envelope := range message.Envelopes {
+		if envelope == nil {
+			return fail("empty envelope")
+		}
+
+		if !usernames[envelope.Username] {
+			return fail(envelope.Username + " is not a member of this room")
+		}
+
+		if err := e2ekey.CheckDevice(envelope.Device); err != nil {
+			return fail(envelope.Username + ": " + err.Error())
+		}
+
+		key := envelope.Username + "/" + envelope.Device
+		if devices[key] {
+			return fail("duplicate envelope for " + key)
+		}
+		devices[key] = true
+
+		if envelope.Type != messageDB.PrekeyEnvelope && envelope.Type != messageDB.SessionEnvelope {
+			return fail("invalid envelope type")
+		}
+
+		b, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+		if err != nil || len(b) == 0 || len(b) > maxCiphertextSize {
+			return fail("invalid ciphertext for " + key)
+		}
+	}
+
+	return true
+}
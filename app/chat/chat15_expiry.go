@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// expireAt is when messages of the room saved now disappear, nil unless the room has a timer.
+// It is read for every save since the timer may be changed through any node.
+func (server *WsServer) expireAt(roomId string) *time.Time {
+	dbRoom, err := server.roomRepository.FindRoomByUID(roomId)
+	if err != nil || dbRoom.ExpireAfter <= 0 {
+		return nil
+	}
+
+	t := time.Now().Add(time.Duration(dbRoom.ExpireAfter) * time.Second)
+	return &t
+}
+
+// sweepExpiredLoop deletes the disappearing messages once they expire, on every node,
+// each message is taken by a single node which tells the room
+func (server *WsServer) sweepExpiredLoop() {
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			msgs, err := server.msgRepository.TakeExpiredMessages(time.Now(), expirySweepBatch)
+			if err != nil {
+				utils.Log().Error(err, "error while deleting expired messages")
+			}
+
+			server.clearExpiredMessages(msgs)
+
+			if err != nil || len(msgs) < expirySweepBatch {
+				break
+			}
+		}
+	}
+}
+
+func (server *WsServer) clearExpiredMessages(msgs []*messageDB.DBMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	byRoom := make(map[string][]string)
+	replies := make(map[primitive.ObjectID]int)
+	var attachmentIds []string
+	for _, msg := range msgs {
+		byRoom[msg.RoomId] = append(byRoom[msg.RoomId], msg.Id.Hex())
+		if msg.ReplyTo != nil {
+			replies[*msg.ReplyTo]--
+		}
+		for _, att := range msg.Attachments {
+			attachmentIds = append(attachmentIds, att.Id)
+		}
+	}
+
+	for parentID, n := range replies {
+		if err := server.msgRepository.IncReplyCount(parentID, n); err != nil {
+			utils.Log().Error(err, "error while update reply count")
+		}
+	}
+
+	server.deleteUnusedAttachments(attachmentIds)
+
+	for roomId, ids := range byRoom {
+		server.notifyExpired(roomId, ids)
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("expired messages deleted: %d", len(msgs)))
+}
+
+// deleteUnusedAttachments deletes the files no other message is sent with, a file may be forwarded
+func (server *WsServer) deleteUnusedAttachments(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	used, err := server.msgRepository.FindUsedAttachments(ids)
+	if err != nil {
+		utils.Log().Error(err, "error while finding used attachments")
+		return
+	}
+
+	inUse := make(map[string]bool)
+	for _, id := range used {
+		inUse[id] = true
+	}
+
+	var unused []string
+	for _, id := range ids {
+		if !inUse[id] {
+			unused = append(unused, id)
+		}
+	}
+
+	if err := server.attachments.DeleteAttachments(unused); err != nil {
+		utils.Log().Error(err, "error while deleting expired attachments")
+	}
+}
+
+func (server *WsServer) notifyExpired(roomId string, ids []string) {
+	room := server.findRoomByID(roomId)
+	if room == nil {
+		id, _ := uuid.Parse(roomId)
+		room = &Room{ID: id}
+	}
+
+	m, err := jsonrpc2.Notify(ExpiredAction, &Messages{
+		Action:   ExpiredAction,
+		Target:   room,
+		Messages: ids,
+		Time:     time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create expired notify")
+		return
+	}
+
+	// the room may not run on this node, the members are reached through the broker
+	if err := server.broker.Publish(roomChannel(roomId), m.Encode()); err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing %s to room %s", ExpiredAction, roomId))
+	}
+}
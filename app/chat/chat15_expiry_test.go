@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"pesatu/components/messageDB"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// replyRepo keeps the reply counts changed
+type replyRepo struct {
+	messageDB.I_MessageRepo
+	replies map[primitive.ObjectID]int
+}
+
+func (me *replyRepo) IncReplyCount(parentId primitive.ObjectID, n int) error {
+	me.replies[parentId] += n
+	return nil
+}
+
+func Test_ClearExpiredMessages(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	room := server.addRoom(NewRoom(server, "group", false))
+	repo := &replyRepo{replies: map[primitive.ObjectID]int{}}
+	server.msgRepository = repo
+
+	expired := make(chan []byte, 4)
+	_, err := server.broker.Subscribe(roomChannel(room.GetId()), func(payload []byte) { expired <- payload })
+	asserts.Nil(err)
+
+	parent := primitive.NewObjectID()
+	msgs := []*messageDB.DBMessage{
+		{Id: primitive.NewObjectID(), RoomId: room.GetId(), ReplyTo: &parent},
+		{Id: primitive.NewObjectID(), RoomId: room.GetId(), ReplyTo: &parent},
+	}
+	server.clearExpiredMessages(msgs)
+
+	// the room is told once about all of them, the thread loses its replies
+	payload := string(<-expired)
+	asserts.Contains(payload, ExpiredAction)
+	asserts.Contains(payload, msgs[0].Id.Hex())
+	asserts.Contains(payload, msgs[1].Id.Hex())
+	asserts.Equal(0, len(expired))
+	asserts.Equal(-2, repo.replies[parent])
+}
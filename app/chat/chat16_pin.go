@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/utils"
+	"time"
+)
+
+// handlePin pins or unpins the message message.Id, the room gets the change
+func (me *Client) handlePin(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("%s msg %s by %s", message.Action, message.Id, me.GetUsername()))
+
+	room, dbMsg := me.findRoomMessage(message.Action, message)
+	if dbMsg == nil {
+		return
+	}
+
+	if rpcErr := me.wsServer.msgController.Pin(me.GetUID(), dbMsg, message.Action == PinAction); rpcErr != nil {
+		me.notifyInfo(room, me, message.Action+", "+rpcErr.Message, "error", message.Time)
+		return
+	}
+
+	room.broadcastMessage(&Message{
+		Id:      message.Id,
+		Action:  message.Action,
+		Message: message.Id,
+		Target:  room,
+		Sender:  me,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+}
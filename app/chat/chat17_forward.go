@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/components/roommember"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// handleForwardMessages copies the messages of message.Forward into its rooms as sent by the client,
+// who must be a member of the rooms of the messages and of every room they go to
+func (me *Client) handleForwardMessages(message Message) {
+	forward := message.Forward
+	if forward == nil || len(forward.Messages) == 0 || len(forward.Rooms) == 0 {
+		me.notifyInfo(nil, me, ForwardMessageAction+", messages and rooms are required", "error", message.Time)
+		return
+	}
+
+	if len(forward.Messages) > maxForwardMessages || len(forward.Rooms) > maxForwardRooms {
+		me.notifyInfo(nil, me, fmt.Sprintf("%s, at most %d messages to %d rooms", ForwardMessageAction, maxForwardMessages, maxForwardRooms), "error", message.Time)
+		return
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("forward %d msg to %d rooms by %s", len(forward.Messages), len(forward.Rooms), me.GetUsername()))
+
+	members := make(map[string]bool)
+	isMember := func(roomId string) bool {
+		ok, found := members[roomId]
+		if !found {
+			ok, _ = me.wsServer.roomRepository.CheckMemberExist(&roommember.Member{RoomID: roomId, UserID: me.GetUID()})
+			members[roomId] = ok
+		}
+		return ok
+	}
+
+	var rooms []string
+	for _, roomId := range forward.Rooms {
+		if _, found := members[roomId]; found {
+			continue
+		}
+
+		if !utils.IsValidUid(roomId) || !isMember(roomId) {
+			me.notifyInfo(nil, me, ForwardMessageAction+", you are not a member of room "+roomId, "error", message.Time)
+			return
+		}
+
+		dbRoom, err := me.wsServer.roomRepository.FindRoomByUID(roomId)
+		if err != nil || me.wsServer.isBlockedInRoom(roomId, dbRoom.GetPrivate(), me.GetUID()) {
+			me.notifyInfo(nil, me, ForwardMessageAction+", user unavailable in room "+roomId, "error", message.Time)
+			return
+		}
+		rooms = append(rooms, roomId)
+	}
+
+	var originals []*messageDB.DBMessage
+	for _, id := range forward.Messages {
+		dbMsg, err := me.wsServer.msgRepository.FindMessageById(id)
+
+		// messages of rooms the client is not in are as unavailable as missing ones
+		if err != nil || !isMember(dbMsg.RoomId) || dbMsg.ExpireAt != nil && !dbMsg.ExpireAt.After(time.Now()) {
+			me.notifyInfo(nil, me, ForwardMessageAction+", message unavailable", "error", message.Time)
+			return
+		}
+
+		if dbMsg.Action != SendMessageAction || dbMsg.Deleted {
+			me.notifyInfo(nil, me, ForwardMessageAction+", message can not be forwarded", "error", message.Time)
+			return
+		}
+
+		// the envelopes are readable by the devices of the source room only
+		if len(dbMsg.Envelopes) > 0 {
+			me.notifyInfo(nil, me, ForwardMessageAction+", encrypted message can not be forwarded", "error", message.Time)
+			return
+		}
+
+		originals = append(originals, dbMsg)
+	}
+
+	for _, roomId := range rooms {
+		if err := me.forwardTo(roomId, originals); err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while forwarding messages to room %s", roomId))
+			me.notifyInfo(nil, me, ForwardMessageAction+", "+err.Error(), "error", message.Time)
+			return
+		}
+	}
+}
+
+// forwardTo saves the copies of the originals in the room, then sends them to the room on every node
+func (me *Client) forwardTo(roomId string, originals []*messageDB.DBMessage) error {
+	expireAt := me.wsServer.expireAt(roomId)
+	now := time.Now()
+
+	var messages []*messageDB.CreateMessage
+	var attachmentIds []string
+	for _, original := range originals {
+		// a forward of a forward is still from the sender of the first original
+		from := original.Sender
+		if original.Forwarded {
+			from = original.ForwardedFrom
+		}
+
+		messages = append(messages, &messageDB.CreateMessage{
+			Action:        SendMessageAction,
+			Message:       original.Message,
+			RoomId:        roomId,
+			Sender:        me.GetUID(),
+			Status:        Delivered,
+			Type:          original.Type,
+			Attachments:   original.Attachments,
+			Forwarded:     true,
+			ForwardedFrom: from,
+			Time:          now,
+			ExpireAt:      expireAt,
+		})
+
+		for _, att := range original.Attachments {
+			attachmentIds = append(attachmentIds, att.Id)
+		}
+	}
+
+	// the files are referenced, the members of the room may download them before the copies arrive
+	if err := me.wsServer.attachments.ShareAttachments(attachmentIds, roomId); err != nil {
+		return err
+	}
+
+	res, err := me.wsServer.msgRepository.AddMessages(messages)
+	if err != nil {
+		return err
+	}
+	me.wsServer.recordMessages(roomId, messages, res)
+
+	room := me.wsServer.findRoomByID(roomId)
+	if room == nil {
+		id, _ := uuid.Parse(roomId)
+		room = &Room{ID: id}
+	}
+
+	usernames := make(map[string]string)
+	for i, delv := range res {
+		from := messages[i].ForwardedFrom
+		if _, found := usernames[from]; !found {
+			if user, err := me.wsServer.msgRepository.FindUserById(from); err == nil {
+				usernames[from] = user.Username
+			}
+		}
+
+		m, err := jsonrpc2.Notify(SendMessageAction, &Message{
+			Id:            delv.Id.Hex(),
+			Action:        SendMessageAction,
+			Message:       messages[i].Message,
+			Target:        room,
+			Sender:        me,
+			Status:        Delivered,
+			Type:          messages[i].Type,
+			Attachments:   messages[i].Attachments,
+			Forwarded:     true,
+			ForwardedFrom: usernames[from],
+			Time:          delv.Time,
+		})
+		if err != nil {
+			utils.Log().Error(err, "error while create forward notify")
+			continue
+		}
+
+		// the room may not run on this node, the members are reached through the broker
+		if err := me.wsServer.broker.Publish(roomChannel(roomId), m.Encode()); err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while publishing %s to room %s", ForwardMessageAction, roomId))
+		}
+	}
+
+	return nil
+}
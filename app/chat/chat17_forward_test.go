@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/components/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// forwardRepo knows the messages by id and keeps the copies saved
+type forwardRepo struct {
+	messageDB.I_MessageRepo
+	msgs   map[string]*messageDB.DBMessage
+	copies []*messageDB.CreateMessage
+}
+
+func (me *forwardRepo) FindMessageById(msgId string) (*messageDB.DBMessage, error) {
+	msg, ok := me.msgs[msgId]
+	if !ok {
+		return nil, fmt.Errorf("message not found")
+	}
+	return msg, nil
+}
+
+func (me *forwardRepo) AddMessages(messages []*messageDB.CreateMessage) ([]*messageDB.DelvMessage, error) {
+	var res []*messageDB.DelvMessage
+	for _, msg := range messages {
+		me.copies = append(me.copies, msg)
+		res = append(res, &messageDB.DelvMessage{Id: primitive.NewObjectID()})
+	}
+	return res, nil
+}
+
+func (me *forwardRepo) FindUserById(uid string) (*user.DBUser, error) {
+	return &user.DBUser{UID: uid, Username: uid}, nil
+}
+
+func Test_ForwardMessages(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	client := newTestClient(server)
+
+	from, to, other := NewRoom(server, "from", false), NewRoom(server, "to", false), NewRoom(server, "other", false)
+	server.roomRepository = &memberRepo{members: map[string][]string{
+		from.GetId():  {client.GetUID(), "a"},
+		to.GetId():    {client.GetUID()},
+		other.GetId(): {"a"},
+	}}
+
+	msg := func(roomId string) *messageDB.DBMessage {
+		return &messageDB.DBMessage{Id: primitive.NewObjectID(), Action: SendMessageAction, RoomId: roomId, Sender: "a", Message: "hi"}
+	}
+	sent, elsewhere, deleted := msg(from.GetId()), msg(other.GetId()), msg(from.GetId())
+	deleted.Deleted = true
+
+	repo := &forwardRepo{msgs: map[string]*messageDB.DBMessage{}}
+	for _, m := range []*messageDB.DBMessage{sent, elsewhere, deleted} {
+		repo.msgs[m.Id.Hex()] = m
+	}
+	server.msgRepository = repo
+
+	forward := func(msgId, roomId string) {
+		client.handleForwardMessages(Message{Action: ForwardMessageAction, Forward: &messageDB.ForwardRequest{Messages: []string{msgId}, Rooms: []string{roomId}}})
+	}
+
+	// only to a room the client is a member of
+	forward(sent.Id.Hex(), other.GetId())
+	asserts.Contains(string(<-client.send), "you are not a member of room "+other.GetId())
+
+	// only messages of a room the client is a member of
+	forward(elsewhere.Id.Hex(), to.GetId())
+	asserts.Contains(string(<-client.send), "message unavailable")
+
+	forward(deleted.Id.Hex(), to.GetId())
+	asserts.Contains(string(<-client.send), "message can not be forwarded")
+	asserts.Empty(repo.copies)
+
+	// the copy is sent by the client and keeps the sender of the original
+	forward(sent.Id.Hex(), to.GetId())
+	asserts.Len(repo.copies, 1)
+	asserts.Equal(to.GetId(), repo.copies[0].RoomId)
+	asserts.Equal(client.GetUID(), repo.copies[0].Sender)
+	asserts.True(repo.copies[0].Forwarded)
+	asserts.Equal("a", repo.copies[0].ForwardedFrom)
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/components/notification"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"regexp"
+	"strings"
+)
+
+// an @ not preceded by a word, like in an email address, followed by a username
+var mentionPattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9_@-])@([a-z0-9][a-z0-9_-]*)`)
+
+// parseMentions returns the usernames after an @ in text, lower cased and once each
+func parseMentions(text string) []string {
+	var usernames []string
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(m[1])
+		if len(username) <= utils.UsernameLength && !utils.StringInSlice(username, usernames) {
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
+// resolveMentions keeps the usernames mentioned in message which are members of the room, the sender aside,
+// mentions sent by the client are not trusted
+func (me *Client) resolveMentions(room *Room, message *Message) {
+	message.Mentions = nil
+	message.mentionIds = nil
+
+	for _, username := range parseMentions(message.Message) {
+		if len(message.mentionIds) == maxMentions {
+			break
+		}
+
+		if username == me.GetUsername() {
+			continue
+		}
+
+		user, err := me.wsServer.msgRepository.FindUserByUsername(username)
+		if err != nil || !room.CheckMemberID(user.UID) {
+			continue
+		}
+
+		message.Mentions = append(message.Mentions, user.Username)
+		message.mentionIds = append(message.mentionIds, user.UID)
+	}
+}
+
+// notifyMentions saves a notification for every member mentioned in the saved messages and sends it
+// to their clients on every node, a mention gets through a muted room but flagged as muted
+func (server *WsServer) notifyMentions(messages []*messageDB.CreateMessage, saved []*messageDB.DelvMessage) {
+	for i, msg := range messages {
+		if len(msg.Mentions) == 0 || i >= len(saved) || saved[i].Duplicate {
+			continue
+		}
+
+		sender, err := server.msgRepository.FindUserById(msg.Sender)
+		if err != nil {
+			utils.Log().Error(err, "error while finding sender of mentions")
+			continue
+		}
+
+		// the content is not copied, the message may be encrypted at rest
+		var notifs []*notification.CreateNotification
+		for _, uid := range msg.Mentions {
+			notifs = append(notifs, &notification.CreateNotification{
+				Title:     "mention",
+				Content:   fmt.Sprintf("%s mentioned you", sender.Username),
+				Recipient: uid,
+				Type:      notification.MentionType,
+				Action:    MentionAction,
+				Priority:  1,
+				RoomId:    msg.RoomId,
+				MsgId:     saved[i].Id.Hex(),
+				Muted:     server.isMuted(msg.RoomId, uid),
+				Image:     sender.Avatar,
+				CreatedAt: msg.Time,
+			})
+		}
+
+		added, err := server.notifRepository.AddNotifs(notifs)
+		if err != nil {
+			utils.Log().Error(err, "error while saving mention notifications")
+			continue
+		}
+
+		for _, notif := range added {
+			message := NewPubSubMessage(MentionAction, notif.Recipient, NewSender(sender.UID, sender.Name, sender.Username, sender.Avatar))
+			message.Notification = notif
+			server.publish(message)
+		}
+	}
+}
+
+// handleMention sends the notification of a mention to the local clients of the mentioned user
+func (server *WsServer) handleMention(message *PubSubMessage) {
+	clients := server.findClientByID(message.Message)
+	if len(clients) == 0 || message.Notification == nil {
+		return
+	}
+
+	m, err := jsonrpc2.Notify(MentionAction, message.Notification)
+	if err != nil {
+		utils.Log().Error(err, "error while create mention notify")
+		return
+	}
+
+	for _, client := range clients {
+		client.SendMsg(m.Encode())
+	}
+}
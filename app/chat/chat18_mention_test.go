@@ -0,0 +1,15 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseMentions(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal([]string{"budi", "sari_2"}, parseMentions("@Budi see this @sari_2, @budi"))
+	asserts.Equal([]string{"ana"}, parseMentions("mail me at ana@example.com (@ana)"))
+	asserts.Empty(parseMentions("@ @-x @@nope"))
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/utils"
+)
+
+// isBlockedInRoom tells whether uid and the other member of a private room have blocked one another,
+// blocking does not apply to group and public rooms
+func (server *WsServer) isBlockedInRoom(roomId string, private bool, uid string) bool {
+	if !private || server.contactRepository == nil {
+		return false
+	}
+
+	members, err := server.roomRepository.FindMembers(roomId, 1, 2)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while finding members of room %s", roomId))
+		return true
+	}
+
+	for _, member := range members {
+		if member.UserID == uid {
+			continue
+		}
+
+		blocked, err := server.contactRepository.IsBlocked(uid, member.UserID)
+		if err != nil {
+			utils.Log().Error(err, "error while checking blocked contact")
+			return true
+		}
+
+		if blocked {
+			return true
+		}
+	}
+
+	return false
+}
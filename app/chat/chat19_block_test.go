@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"pesatu/components/contacts"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockRepo knows who has blocked whom, by owner then blocked uid
+type blockRepo struct {
+	contacts.I_ContactRepo
+	blocks map[string]string
+}
+
+func (me *blockRepo) IsBlocked(uid1, uid2 string) (bool, error) {
+	return me.blocks[uid1] == uid2 || me.blocks[uid2] == uid1, nil
+}
+
+func Test_SendMessageBlocked(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	server.persister = newPersister(server)
+	room := server.addRoom(NewRoom(server, "a-b", true))
+
+	client := newTestClient(server)
+	server.roomRepository = &memberRepo{members: map[string][]string{room.GetId(): {client.GetUID(), "b"}}}
+	repo := &blockRepo{blocks: map[string]string{}}
+	server.contactRepository = repo
+
+	send := func() {
+		client.handleSendMessageAction(Message{Action: SendMessageAction, Message: "hi", Target: room, Sender: client})
+	}
+
+	// blocked by the other member or blocking them, the message goes nowhere
+	repo.blocks["b"] = client.GetUID()
+	send()
+	asserts.Equal(0, len(server.persister.queue))
+	asserts.Contains(string(<-client.send), "user unavailable")
+
+	delete(repo.blocks, "b")
+	repo.blocks[client.GetUID()] = "b"
+	send()
+	asserts.Equal(0, len(server.persister.queue))
+	asserts.Contains(string(<-client.send), "user unavailable")
+
+	// once unblocked it is sent again
+	delete(repo.blocks, client.GetUID())
+	send()
+	asserts.Equal(1, len(server.persister.queue))
+
+	// blocking does not apply to groups
+	group := server.addRoom(NewRoom(server, "group", false))
+	group.Group = true
+	server.roomRepository.(*memberRepo).members[group.GetId()] = []string{client.GetUID(), "b"}
+	repo.blocks["b"] = client.GetUID()
+	client.handleSendMessageAction(Message{Action: SendMessageAction, Message: "hi", Target: group, Sender: client})
+	asserts.Equal(2, len(server.persister.queue))
+}
@@ -2,143 +2,63 @@ package chat
 
 import (
 	"fmt"
-	"pesatu/components/messageDB"
 	"pesatu/components/roommember"
 	"pesatu/jsonrpc2"
 	"pesatu/utils"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Room struct {
 	// ctx        context.Context
-	wsServer     *WsServer
-	ID           uuid.UUID `json:"id"`
-	Name         string    `json:"name"`
-	clients      map[*Client]bool
-	register     chan *Client
-	unregister   chan *Client
-	broadcast    chan *Message
-	writeMsgToDB chan *Message
-	Private      bool `json:"private"`
-	wg           *sync.WaitGroup
-	disposed     bool
+	wsServer    *WsServer
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	clients     map[*Client]bool
+	register    chan *Client
+	unregister  chan *Client
+	incoming    chan []byte
+	Private     bool   `json:"private"`
+	Group       bool   `json:"group,omitempty"`
+	Title       string `json:"title,omitempty"`
+	unsubscribe func()
+
+	// the room stops once its last client is gone, or after idleTimeout without any, done is closed then
+	stopping    bool
+	done        chan struct{}
+	idleTimeout time.Duration
+
+	// ephemeral signals, never saved
+	signal             chan *Message
+	incomingSignal     chan *roomSignal
+	signals            map[*Client]string
+	unsubscribeSignals func()
 }
 
 // NewRoom creates a new Room
 func NewRoom(wsServer *WsServer, name string, private bool) *Room {
-	inputs := make(chan *Message)
-	var wg sync.WaitGroup
-	wg.Add(1)
-
 	r := &Room{
 		// ctx:        ctx,
-		wsServer:     wsServer,
-		ID:           uuid.New(),
-		Name:         name,
-		clients:      make(map[*Client]bool),
-		register:     make(chan *Client),
-		unregister:   make(chan *Client),
-		broadcast:    make(chan *Message),
-		writeMsgToDB: inputs,
-		Private:      private,
-		wg:           &wg,
-		disposed:     false,
+		wsServer:    wsServer,
+		ID:          uuid.New(),
+		Name:        name,
+		clients:     make(map[*Client]bool),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		incoming:    make(chan []byte, 256),
+		Private:     private,
+		done:        make(chan struct{}),
+		idleTimeout: roomIdleTimeout,
+
+		signal:         make(chan *Message, 16),
+		incomingSignal: make(chan *roomSignal, 256),
+		signals:        make(map[*Client]string),
 	}
 
-	go r.writeToDBLoop()
-
 	return r
 }
 
-func (r *Room) writeToDBLoop() {
-	defer r.wg.Done()
-	var inputMessages []*Message
-	for {
-		select {
-		case msg := <-r.writeMsgToDB:
-			inputMessages = append(inputMessages, msg)
-		case <-time.After(1 * time.Second):
-			if len(inputMessages) > 0 {
-				var messages []*messageDB.CreateMessage
-				var update []string
-				for _, msg := range inputMessages {
-					if msg.Action == HasBeenRead {
-						update = append(update, msg.Message)
-						continue
-					}
-
-					CreatedAt, err := time.Parse(time.RFC3339, msg.Time)
-					if err != nil {
-						CreatedAt = time.Now()
-					}
-
-					status := msg.Status
-					if msg.Status == "acc" {
-						status = "delv"
-					}
-
-					messages = append(messages, &messageDB.CreateMessage{
-						Action:  msg.Action,
-						Message: msg.Message,
-						RoomId:  r.GetId(),
-						Sender:  msg.Sender.(I_User).GetUID(),
-						Status:  status,
-						Time:    CreatedAt,
-					})
-				} //end loop
-
-				inputMessages = nil
-
-				if len(messages) > 0 {
-					res, err := r.wsServer.msgRepository.AddMessages(messages)
-					if err != nil {
-						utils.Log().Error(err, "error while save messages into database")
-					}
-
-					message, err := jsonrpc2.Notify(Delivered, &Messages{
-						Action:   Delivered,
-						Target:   r,
-						Messages: res,
-					})
-
-					if err != nil {
-						utils.Log().Error(err, "error while create delv notify msg")
-					}
-
-					utils.Log().V(2).Info("message delivered count: %d", len(res))
-					r.broadcastToClientsInRoom(message.Encode())
-				}
-
-				if len(update) > 0 {
-					var msgIds []*primitive.ObjectID
-					for i := range update {
-						objectID, err := primitive.ObjectIDFromHex(update[i])
-						if err != nil {
-							continue
-						}
-						msgIds = append(msgIds, &objectID)
-					}
-
-					if len(msgIds) > 0 {
-						err := r.wsServer.msgRepository.UpdateStatus(msgIds, HasBeenRead)
-						if err != nil {
-							utils.Log().Error(err, "error while save status msessages into database")
-						}
-					}
-				}
-			}
-		}
-
-		if r.disposed {
-			break
-		}
-	}
-}
-
 func (r *Room) GetClients() map[*Client]bool {
 	return r.clients
 }
@@ -186,12 +106,17 @@ func (r *Room) CheckMemberID(id string) bool {
 	return ok
 }
 
-// RunRoom runs our room, accepting various requests
+// RunRoom runs our room, accepting various requests, until its last client is gone
 func (room *Room) RunRoom() {
-	//todo subscribe to pub/sub messages inside a new goroutine
-	//go room.subscribeToRoomMessages()
+	// subscribe to pub/sub messages, delivered to local clients through room.incoming
+	room.subscribeToRoomMessages()
+	room.subscribeToRoomSignals()
+
+	// a room run to send a message or an event may never be joined
+	idle := time.NewTimer(room.idleTimeout)
+	defer idle.Stop()
 
-	for {
+	for !room.stopping {
 		select {
 
 		case client := <-room.register:
@@ -200,21 +125,56 @@ func (room *Room) RunRoom() {
 		case client := <-room.unregister:
 			room.unregisterClientInRoom(client)
 
-		case message := <-room.broadcast:
-			m, err := jsonrpc2.Notify(message.Action, message)
-			if err != nil {
-				utils.Log().Error(err, "error while broadcasitng message")
+		case payload := <-room.incoming:
+			room.broadcastToClientsInRoom(payload)
+			if room.Group {
+				room.handleGroupEvent(payload)
 			}
-			room.broadcastToClientsInRoom(m.Encode())
 
-			//todo next version using redis:
-			//room.publishRoomMessage(message)
+		case message := <-room.signal:
+			room.publishSignal(message)
+
+		case signal := <-room.incomingSignal:
+			room.deliverSignal(signal)
+
+		case <-idle.C:
+			room.disposeIfEmpty()
 		}
 	}
+
+	room.stop()
+}
+
+// join registers the client in the room, it returns false when the room stopped,
+// the caller then runs it again
+func (room *Room) join(client *Client) bool {
+	select {
+	case room.register <- client:
+		return true
+	case <-room.done:
+		return false
+	}
+}
+
+func (room *Room) leave(client *Client) {
+	select {
+	case room.unregister <- client:
+	case <-room.done:
+	}
+}
+
+// broadcastMessage sends the message to the clients of this room on every node
+func (room *Room) broadcastMessage(message *Message) {
+	m, err := jsonrpc2.Notify(message.Action, message)
+	if err != nil {
+		utils.Log().Error(err, "error while broadcasting message")
+		return
+	}
+	room.publishRoomMessage(m.Encode())
 }
 
 func (room *Room) registerClientInRoom(client *Client) {
-	if !room.Private {
+	if !room.Private && !room.Group {
 		room.notifyClientJoined(client)
 	}
 	room.clients[client] = true
@@ -225,20 +185,31 @@ func (room *Room) registerClientInRoom(client *Client) {
 func (room *Room) unregisterClientInRoom(client *Client) {
 	if _, ok := room.clients[client]; ok {
 		utils.Log().V(2).Info(fmt.Sprintf("del client %s from room %s", client.Name, room.Name))
-		client.disposed = true
-		client.wg.Wait()
+		room.stopSignal(client)
 		delete(room.clients, client)
+		room.disposeIfEmpty()
+	}
+}
 
-		if len(room.clients) == 0 {
-			room.disposed = true
-			room.wg.Wait()
-			delete(room.wsServer.rooms, room)
-			utils.Log().V(2).Info(fmt.Sprintf("del room %s from room server", room.Name))
+// disposeIfEmpty stops the room when its last client is gone
+func (room *Room) disposeIfEmpty() {
+	if len(room.clients) == 0 {
+		room.stopping = true
+	}
+}
 
-			// }else {
-			// 	//optional broadcast leave room
-		}
+// stop removes the room from the server before closing done, a client which found it
+// meanwhile fails to join and runs it again
+func (room *Room) stop() {
+	room.wsServer.removeRoom(room)
+	close(room.done)
+	if room.unsubscribe != nil {
+		room.unsubscribe()
 	}
+	if room.unsubscribeSignals != nil {
+		room.unsubscribeSignals()
+	}
+	utils.Log().V(2).Info(fmt.Sprintf("del room %s from room server", room.Name))
 }
 
 func (room *Room) broadcastToClientsInRoom(message []byte) {
@@ -253,33 +224,30 @@ func (room *Room) broadcastToClientsInRoom(message []byte) {
 	}
 }
 
-//todo next version using redis:
-// func (room *Room) publishRoomMessage(message *Message) {
-// 	utils.Log().Info("publishRoomMessage ", room.GetName())
-
-// 	json, err := json.Marshal(message)
-// 	if err != nil {
-// 		utils.Log().Error(err, "error while marshal json")
-// 		return
-// 	}
-
-// 	err := config.Redis.Publish(room.ctx, room.GetName(), json).Err()
-// 	if err != nil {
-// 		utils.Log().Error(err, "error while publishing to redis")
-// 	}
-// }
+// publishRoomMessage sends an encoded message to the clients of this room on every node
+func (room *Room) publishRoomMessage(message []byte) {
+	err := room.wsServer.broker.Publish(roomChannel(room.GetId()), message)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing to room %s", room.GetName()))
+	}
+}
 
-//todo next version using redis:
-// func (room *Room) subscribeToRoomMessages() {
-// 	log.Println("subscribeToRoomMessages ", room.GetName())
-// 	pubsub := config.Redis.Subscribe(ctx, room.GetName())
+func (room *Room) subscribeToRoomMessages() {
+	utils.Log().V(2).Info(fmt.Sprintf("subscribe to room messages %s", room.GetName()))
+	unsubscribe, err := room.wsServer.broker.Subscribe(roomChannel(room.GetId()), func(payload []byte) {
+		select {
+		case room.incoming <- payload:
+		case <-room.done:
+		}
+	})
 
-// 	ch := pubsub.Channel()
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while subscribing to room %s", room.GetName()))
+		return
+	}
 
-// 	for msg := range ch {
-// 		room.broadcastToClientsInRoom([]byte(msg.Payload))
-// 	}
-// }
+	room.unsubscribe = unsubscribe
+}
 
 func (room *Room) notifyClientJoined(client *Client) {
 	message, err := jsonrpc2.Notify(SendMessageAction, &Message{
@@ -292,10 +260,8 @@ func (room *Room) notifyClientJoined(client *Client) {
 		utils.Log().Error(err, "error while notify client joined")
 	}
 
-	utils.Log().V(2).Info("notify", client.Name, "Joined room", room.Name)
-	room.broadcastToClientsInRoom(message.Encode())
-	//todo
-	//room.publishRoomMessage(message.encode())
+	utils.Log().V(2).Info(fmt.Sprintf("notify %s joined room %s", client.Name, room.Name))
+	room.publishRoomMessage(message.Encode())
 }
 
 func (room *Room) GetId() string {
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() *WsServer {
+	return &WsServer{
+		broker:    NewMemoryBroker(),
+		clients:   make(map[string]map[*Client]bool),
+		rooms:     make(map[string]*Room),
+		roomNames: make(map[string]*Room),
+		node:      "local",
+		online:    make(map[string]*onlineUser),
+		nodes:     make(map[string]time.Time),
+	}
+}
+
+func newTestClient(server *WsServer) *Client {
+	return &Client{id: uuid.New(), wsServer: server, send: make(chan []byte, 256), rooms: make(map[*Room]bool)}
+}
+
+func Test_RoomRegistry(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+
+	room := server.addRoom(NewRoom(server, "a-b", true))
+	asserts.Equal(room, server.addRoom(NewRoom(server, "a-b", true)))
+	asserts.Equal(room, server.findRoomByID(room.GetId()))
+
+	var wg sync.WaitGroup
+	clients := make([]*Client, 20)
+	for i := range clients {
+		clients[i] = newTestClient(server)
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			asserts.True(client.addRoom(room))
+			asserts.True(room.join(client))
+			asserts.Equal(room, server.findRoomByID(room.GetId()))
+			asserts.True(client.isInRoom(room))
+		}(clients[i])
+	}
+	wg.Wait()
+
+	// the room stops with its last client and is removed, a late join fails
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			for _, r := range client.takeRooms() {
+				r.leave(client)
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	select {
+	case <-room.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("room did not stop")
+	}
+	asserts.Nil(server.findRoomByID(room.GetId()))
+	asserts.False(room.join(newTestClient(server)))
+}
+
+func Test_RoomIdle(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+
+	joined := NewRoom(server, "joined", false)
+	joined.idleTimeout = 10 * time.Millisecond
+	joined = server.addRoom(joined)
+	asserts.True(joined.join(newTestClient(server)))
+
+	// a room nobody joins stops on its own, one with a client keeps running
+	idle := NewRoom(server, "idle", false)
+	idle.idleTimeout = 10 * time.Millisecond
+	idle = server.addRoom(idle)
+
+	select {
+	case <-idle.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("room did not stop")
+	}
+	asserts.Nil(server.findRoomByID(idle.GetId()))
+	asserts.Equal(joined, server.findRoomByID(joined.GetId()))
+}
+
+func Test_ClientRegistry(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	client := newTestClient(server)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			server.addClient(client)
+		}()
+		go func() {
+			defer wg.Done()
+			server.findClientByID(client.GetUID())
+			server.localClients()
+		}()
+	}
+	wg.Wait()
+
+	asserts.Equal([]*Client{client}, server.findClientByID(client.GetUID()))
+	asserts.True(server.removeClient(client))
+	asserts.False(server.removeClient(client))
+	asserts.False(server.hasLocalClients())
+}
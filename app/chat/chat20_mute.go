@@ -0,0 +1,13 @@
+package chat
+
+import "time"
+
+// isMuted tells whether the user muted the room, notifications about the room are sent to them silently
+func (server *WsServer) isMuted(roomId, uid string) bool {
+	member, err := server.roomRepository.FindMember(roomId, uid)
+	if err != nil {
+		return false
+	}
+
+	return member.IsMuted(time.Now())
+}
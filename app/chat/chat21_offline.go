@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"pesatu/components/contacts"
+	"pesatu/components/eventlog"
+	"pesatu/components/messageDB"
+	"pesatu/components/roommember"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how long the events missed by an offline user are kept for replay
+var offlineEventWindow = 7 * 24 * time.Hour
+
+// SetOfflineEventWindow sets how long the events missed by an offline user are kept for replay
+func SetOfflineEventWindow(d time.Duration) {
+	if d > 0 {
+		offlineEventWindow = d
+	}
+}
+
+// recordOffline keeps the events of a private or group room for its members who have no connection
+// on any node, they get them back with a replay. A user leaving is told to the nodes through the broker,
+// an event right then may be neither received nor kept.
+func (server *WsServer) recordOffline(roomId string, events ...*eventlog.CreateEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	dbRoom, err := server.roomRepository.FindRoomByUID(roomId)
+	if err != nil || !dbRoom.GetPrivate() && !dbRoom.GetGroup() {
+		return
+	}
+
+	members, err := server.roomRepository.FindMembers(roomId, 1, roommember.MaxGroupMembers)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while finding members of room %s", roomId))
+		return
+	}
+
+	var missed []*eventlog.CreateEvent
+	for _, member := range members {
+		if server.IsOnline(member.UserID) {
+			continue
+		}
+
+		for _, event := range events {
+			e := *event
+			e.UID = member.UserID
+			e.RoomId = roomId
+			missed = append(missed, &e)
+		}
+	}
+
+	if err := server.eventRepository.AddEvents(missed); err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while saving offline events of room %s", roomId))
+	}
+}
+
+// recordMessages keeps the saved messages of a room for its offline members
+func (server *WsServer) recordMessages(roomId string, messages []*messageDB.CreateMessage, saved []*messageDB.DelvMessage) {
+	var events []*eventlog.CreateEvent
+	for i, delv := range saved {
+		if i >= len(messages) {
+			break
+		}
+
+		if delv.Duplicate {
+			continue
+		}
+
+		events = append(events, &eventlog.CreateEvent{
+			Action: messages[i].Action,
+			MsgId:  delv.Id.Hex(),
+			Time:   messages[i].Time,
+		})
+	}
+
+	server.recordOffline(roomId, events...)
+}
+
+// NotifyContactEvent sends a contact event to the user on every node, or keeps it for replay
+// when the user is offline, it is called by the contacts rpc handlers
+func (server *WsServer) NotifyContactEvent(event *contacts.ContactEvent) {
+	utils.Log().V(2).Info(fmt.Sprintf("notify %s by %s", event.Action, event.Username))
+
+	if !server.IsOnline(event.ToUID) {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			utils.Log().Error(err, "error while marshaling contact event")
+			return
+		}
+
+		err = server.eventRepository.AddEvents([]*eventlog.CreateEvent{{
+			UID:     event.ToUID,
+			Action:  ContactAction,
+			Actor:   event.Username,
+			Payload: payload,
+			Time:    event.Time,
+		}})
+		if err != nil {
+			utils.Log().Error(err, "error while saving offline contact event")
+		}
+		return
+	}
+
+	message := NewPubSubMessage(ContactAction, event.ToUID, NewSender("", event.Name, event.Username, event.Avatar))
+	message.Contact = event
+	server.publish(message)
+}
+
+// handleContactEvent sends a contact event to the local clients of the user
+func (server *WsServer) handleContactEvent(message *PubSubMessage) {
+	clients := server.findClientByID(message.Message)
+	if len(clients) == 0 || message.Contact == nil {
+		return
+	}
+
+	m, err := jsonrpc2.Notify(ContactAction, message.Contact)
+	if err != nil {
+		utils.Log().Error(err, "error while create contact notify")
+		return
+	}
+
+	for _, client := range clients {
+		client.SendMsg(m.Encode())
+	}
+}
+
+// handleReplay sends the events the user missed after message.Seq, the last sequence the client got.
+// The messages are read again, an edited message comes with its latest content and the deleted,
+// expired or cleared ones are left out.
+func (me *Client) handleReplay(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("replay after %d by %s", message.Seq, me.GetUsername()))
+
+	page, err := me.wsServer.eventRepository.FindEvents(me.GetUID(), message.Seq, eventlog.MaxEventPage)
+	if err != nil {
+		me.notifyInfo(nil, me, ReplayAction+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	var ids []primitive.ObjectID
+	for _, event := range page.Events {
+		if id, err := primitive.ObjectIDFromHex(event.MsgId); err == nil && !isReceipt(event.Action) {
+			ids = append(ids, id)
+		}
+	}
+
+	msgs, err := me.wsServer.msgRepository.FindMessagesByIds(ids, me.GetUID())
+	if err != nil {
+		me.notifyInfo(nil, me, ReplayAction+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	byId := make(map[string]*messageDB.DBMessage)
+	for _, msg := range msgs {
+		byId[msg.Id.Hex()] = msg
+	}
+
+	// cutoffs of the rooms, a room the user is no longer in has none to show
+	cleared := make(map[string]*primitive.ObjectID)
+	isMember := make(map[string]bool)
+	visible := func(msg *messageDB.DBMessage) bool {
+		if _, found := isMember[msg.RoomId]; !found {
+			member, err := me.wsServer.roomRepository.FindMember(msg.RoomId, me.GetUID())
+			isMember[msg.RoomId] = err == nil
+			if err == nil {
+				cleared[msg.RoomId] = member.ClearedUpTo
+			}
+		}
+		return isMember[msg.RoomId] && isAfterMark(msg.Id, cleared[msg.RoomId])
+	}
+
+	events := []*eventlog.DBEvent{}
+	for _, event := range page.Events {
+		if len(event.MsgId) > 0 && !isReceipt(event.Action) {
+			msg, ok := byId[event.MsgId]
+			if !ok || !visible(msg) {
+				continue
+			}
+
+			if event.Payload, err = json.Marshal(msg); err != nil {
+				utils.Log().Error(err, "error while marshaling replayed message")
+				continue
+			}
+		}
+		events = append(events, event)
+	}
+	page.Events = events
+
+	m, err := jsonrpc2.Notify(ReplayAction, page)
+	if err != nil {
+		utils.Log().Error(err, "error while create replay notify")
+		return
+	}
+	me.SendMsg(m.Encode())
+}
+
+func isReceipt(action string) bool {
+	return action == HasBeenRead || action == ReceivedAction
+}
+
+// compactEventsLoop deletes the offline events older than the window, on every node
+func (server *WsServer) compactEventsLoop() {
+	ticker := time.NewTicker(eventCompactInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := server.eventRepository.Compact(time.Now().Add(-offlineEventWindow))
+		if err != nil {
+			utils.Log().Error(err, "error while compacting offline events")
+			continue
+		}
+
+		if n > 0 {
+			utils.Log().V(2).Info(fmt.Sprintf("offline events compacted: %d", n))
+		}
+	}
+}
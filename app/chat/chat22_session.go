@@ -0,0 +1,321 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// SessionInfo is the first frame of a connection of a session, Seq is the last frame the client has,
+// the frames after it follow. A session which is not Resumed starts over, the client then syncs
+// its rooms, with a replay from its last event for instance.
+type SessionInfo struct {
+	ID      string `json:"id"`
+	Resumed bool   `json:"resumed"`
+	Seq     int64  `json:"seq"`
+}
+
+// session keeps the frames sent to a client until the client acks them, so it can resume
+// on a new connection within sessionResumeWindow without losing any.
+// Every frame is sent alone as {"seq":n,"frame":...}, the session info has seq 0.
+type session struct {
+	id     string
+	mu     sync.Mutex
+	seq    int64
+	acked  int64
+	frames [][]byte
+	// the connection frames are written to, nil while detached, and the last frame written to it
+	conn *websocket.Conn
+	sent int64
+	wake chan struct{}
+	// the client is dropped once the window is over, or at once when too many frames are not acked
+	expiry   *time.Timer
+	overflow bool
+	torn     bool
+}
+
+func newSession() *session {
+	return &session{id: uuid.New().String()}
+}
+
+func sessionFrame(seq int64, frame []byte) []byte {
+	envelope := make([]byte, 0, len(frame)+32)
+	envelope = append(envelope, `{"seq":`...)
+	envelope = strconv.AppendInt(envelope, seq, 10)
+	envelope = append(envelope, `,"frame":`...)
+	envelope = append(envelope, frame...)
+	return append(envelope, '}')
+}
+
+// push gives the frame the next sequence. A client too slow to ack is dropped, explicitly by the
+// write thread of its connection, or at once when detached.
+func (s *session) push(frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.overflow || s.torn {
+		return
+	}
+
+	if len(s.frames) >= maxSessionFrames {
+		s.overflow = true
+		if s.conn == nil && s.expiry != nil {
+			s.expiry.Reset(0)
+		}
+	} else {
+		s.seq++
+		s.frames = append(s.frames, sessionFrame(s.seq, frame))
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ack drops the frames up to seq, the client has them
+func (s *session) ack(seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seq > s.seq {
+		seq = s.seq
+	}
+
+	if seq <= s.acked {
+		return
+	}
+
+	s.frames = s.frames[seq-s.acked:]
+	s.acked = seq
+}
+
+// attach makes conn the connection of the session, the frames after seq are written to it.
+// It fails when the session is over or the frames after seq are acked already,
+// a connection still attached is replaced.
+func (s *session) attach(conn *websocket.Conn, seq int64) (<-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.overflow || s.torn || seq < s.acked || seq > s.seq {
+		return nil, false
+	}
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	// the old connection is dropped by its threads, detach ignores it
+	if s.conn != nil {
+		s.conn.Close()
+	}
+
+	s.frames = s.frames[seq-s.acked:]
+	s.acked = seq
+	s.conn = conn
+	s.sent = seq
+	s.wake = make(chan struct{}, 1)
+
+	return s.wake, true
+}
+
+// pending returns the frames not written to conn yet, and false once conn is no longer attached
+// or the client is too slow
+func (s *session) pending(conn *websocket.Conn) ([][]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != conn || s.overflow {
+		return nil, false
+	}
+
+	// a client may ack frames it has not been sent
+	from := s.sent
+	if from < s.acked {
+		from = s.acked
+	}
+
+	frames := s.frames[from-s.acked:]
+	s.sent = s.seq
+
+	return frames, true
+}
+
+// current returns the connection attached to the session, nil while detached
+func (s *session) current() *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conn
+}
+
+func (s *session) isOverflow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.overflow
+}
+
+// detach keeps the session of a dropped connection for the resume window, then calls expired.
+// It returns false when the session is over.
+func (s *session) detach(conn *websocket.Conn, expired func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.overflow || s.torn {
+		return false
+	}
+
+	// replaced by a resumed connection
+	if s.conn != conn {
+		return true
+	}
+
+	s.conn = nil
+	s.expiry = time.AfterFunc(sessionResumeWindow, func() {
+		if s.expire() {
+			expired()
+		}
+	})
+
+	return true
+}
+
+func (s *session) expire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.torn || s.conn != nil {
+		return false
+	}
+
+	s.torn = true
+	return true
+}
+
+// claim tells whether the caller is the one to drop the client of the session
+func (s *session) claim() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.torn {
+		return false
+	}
+
+	s.torn = true
+	return true
+}
+
+func (s *session) info(resumed bool) []byte {
+	s.mu.Lock()
+	seq := s.sent
+	s.mu.Unlock()
+
+	m, err := jsonrpc2.Notify(SessionAction, &SessionInfo{ID: s.id, Resumed: resumed, Seq: seq})
+	if err != nil {
+		utils.Log().Error(err, "error while create session notify")
+		return nil
+	}
+
+	return sessionFrame(0, m.Encode())
+}
+
+// startSession attaches the first connection of a new session to the client
+func (server *WsServer) startSession(client *Client, conn *websocket.Conn) {
+	client.session = newSession()
+	wake, _ := client.session.attach(conn, 0)
+
+	server.sessionsMu.Lock()
+	server.sessions[client.session.id] = client
+	server.sessionsMu.Unlock()
+
+	go client.pumpSession()
+	go client.sessionWriteThread(conn, wake, client.session.info(false))
+	go client.readThread(conn)
+}
+
+// resumeSession attaches conn to the client of the session id, it returns false when the session
+// can not be resumed from seq
+func (server *WsServer) resumeSession(id, uid string, conn *websocket.Conn, seq int64) bool {
+	server.sessionsMu.Lock()
+	client, ok := server.sessions[id]
+	server.sessionsMu.Unlock()
+
+	if !ok || client.GetUID() != uid {
+		return false
+	}
+
+	wake, ok := client.session.attach(conn, seq)
+	if !ok {
+		return false
+	}
+
+	utils.Log().V(2).Info(fmt.Sprintf("session %s of %s resumed after %d", id, client.GetUsername(), seq))
+
+	client.wg.Add(1)
+	go client.sessionWriteThread(conn, wake, client.session.info(true))
+	go client.readThread(conn)
+
+	return true
+}
+
+func (server *WsServer) removeSession(id string) {
+	server.sessionsMu.Lock()
+	delete(server.sessions, id)
+	server.sessionsMu.Unlock()
+}
+
+// pumpSession sequences everything sent to the client for as long as it is registered
+func (me *Client) pumpSession() {
+	for frame := range me.send {
+		me.session.push(frame)
+	}
+}
+
+// sessionWriteThread writes the frames of the session to conn, one per websocket message, until
+// another connection is attached
+func (me *Client) sessionWriteThread(conn *websocket.Conn, wake <-chan struct{}, info []byte) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, info); err != nil {
+		return
+	}
+
+	for {
+		frames, ok := me.session.pending(conn)
+		if !ok {
+			if me.session.isOverflow() {
+				closeSlowConsumer(conn)
+			}
+			return
+		}
+
+		for _, frame := range frames {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-wake:
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SessionFrames(t *testing.T) {
+	asserts := assert.New(t)
+
+	s := newSession()
+	conn := &websocket.Conn{}
+	_, ok := s.attach(conn, 0)
+	asserts.True(ok)
+
+	s.push([]byte(`"a"`))
+	s.push([]byte(`"b"`))
+	frames, ok := s.pending(conn)
+	asserts.True(ok)
+	asserts.Equal([][]byte{[]byte(`{"seq":1,"frame":"a"}`), []byte(`{"seq":2,"frame":"b"}`)}, frames)
+
+	s.push([]byte(`"c"`))
+	frames, _ = s.pending(conn)
+	asserts.Equal([][]byte{[]byte(`{"seq":3,"frame":"c"}`)}, frames)
+
+	// acked frames can not be resumed from, the others are sent again
+	s.ack(2)
+	asserts.Len(s.frames, 1)
+	asserts.True(s.detach(conn, func() {}))
+	_, ok = s.attach(conn, 1)
+	asserts.False(ok)
+	_, ok = s.attach(conn, 2)
+	asserts.True(ok)
+	frames, _ = s.pending(conn)
+	asserts.Equal([][]byte{[]byte(`{"seq":3,"frame":"c"}`)}, frames)
+
+	for i := 0; i <= maxSessionFrames; i++ {
+		s.push([]byte(`"x"`))
+	}
+	asserts.True(s.isOverflow())
+	_, ok = s.pending(conn)
+	asserts.False(ok)
+	asserts.False(s.detach(conn, func() {}))
+	asserts.True(s.claim())
+	asserts.False(s.claim())
+}
+
+func Test_SessionCurrentConn(t *testing.T) {
+	asserts := assert.New(t)
+
+	server := newTestServer()
+	client := newTestClient(server)
+	client.session = newSession()
+
+	first, second := &websocket.Conn{}, &websocket.Conn{}
+	client.session.attach(first, 0)
+	asserts.Same(first, client.currentConn())
+
+	// the client is served on the resumed connection, not the one it was created with
+	client.session.detach(first, func() {})
+	asserts.Nil(client.currentConn())
+	client.session.attach(second, 0)
+	asserts.Same(second, client.currentConn())
+}
@@ -0,0 +1,461 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"pesatu/components/messageDB"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// file of the messages which could not be saved, one json per line, they are saved again
+// when the database is back
+var deadLetterFile = "deadletter.jsonl"
+
+// SetDeadLetterFile sets the file of the messages which could not be saved
+func SetDeadLetterFile(path string) {
+	if len(path) > 0 {
+		deadLetterFile = path
+	}
+}
+
+var (
+	errPersistBusy    = errors.New("server is busy, try again")
+	errPersistPending = errors.New("message is queued already")
+)
+
+type persistItem struct {
+	room *Room
+	msg  *Message
+}
+
+// PersistStats are the counters of the persistence of sent messages, since the start of the node
+type PersistStats struct {
+	Queued       int   `json:"queued"`
+	Capacity     int   `json:"capacity"`
+	Saved        int64 `json:"saved"`
+	Retries      int64 `json:"retries"`
+	DeadLettered int64 `json:"dead_lettered"`
+	Replayed     int64 `json:"replayed"`
+	Rejected     int64 `json:"rejected"`
+}
+
+// persister saves the messages sent to the rooms of the node in batches, by size and time, in the
+// order they are queued. A batch is retried with backoff, then written to deadLetterFile.
+// A sender is answered acc only once its message is queued, a full queue is refused instead.
+// The queue is flushed on Shutdown, a crash loses it and the senders, without a delv, send again
+// with the same client id.
+type persister struct {
+	server *WsServer
+	queue  chan *persistItem
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	// queued messages by room, sender and client id, a message sent again is not queued twice
+	pending map[string]bool
+
+	saved        int64
+	retries      int64
+	deadLettered int64
+	replayed     int64
+	rejected     int64
+}
+
+func newPersister(server *WsServer) *persister {
+	return &persister{
+		server:  server,
+		queue:   make(chan *persistItem, persistQueueSize),
+		done:    make(chan struct{}),
+		pending: make(map[string]bool),
+	}
+}
+
+func pendingKey(room *Room, msg *Message) string {
+	if len(msg.ClientId) == 0 {
+		return ""
+	}
+	return room.GetId() + "/" + msg.Sender.(I_User).GetUID() + "/" + msg.ClientId
+}
+
+// enqueue queues msg to be saved, it returns errPersistBusy when the queue is full or closed
+// and errPersistPending when a message with the same client id is queued already
+func (p *persister) enqueue(room *Room, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := pendingKey(room, msg)
+	if len(key) > 0 && p.pending[key] {
+		return errPersistPending
+	}
+
+	if !p.closed {
+		select {
+		case p.queue <- &persistItem{room: room, msg: msg}:
+			if len(key) > 0 {
+				p.pending[key] = true
+			}
+			return nil
+		default:
+		}
+	}
+
+	atomic.AddInt64(&p.rejected, 1)
+	return errPersistBusy
+}
+
+// forget drops the client ids of a batch once it is saved or dead lettered
+func (p *persister) forget(batch []*persistItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, item := range batch {
+		delete(p.pending, pendingKey(item.room, item.msg))
+	}
+}
+
+// close refuses new messages and waits until the queued ones are saved or dead lettered
+func (p *persister) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	<-p.done
+}
+
+func (p *persister) run() {
+	defer close(p.done)
+
+	p.replayDeadLetters()
+
+	ticker := time.NewTicker(persistFlushInterval)
+	defer ticker.Stop()
+	replay := time.NewTicker(deadLetterReplayInterval)
+	defer replay.Stop()
+
+	var batch []*persistItem
+	for {
+		select {
+		case item, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+
+			batch = append(batch, item)
+			if len(batch) >= persistBatchSize {
+				p.flush(batch)
+				batch = nil
+			}
+
+		case <-ticker.C:
+			p.flush(batch)
+			batch = nil
+
+		case <-replay.C:
+			p.replayDeadLetters()
+		}
+	}
+}
+
+func (p *persister) flush(batch []*persistItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var messages []*messageDB.CreateMessage
+	rooms := make(map[string]*Room)
+	expireAt := make(map[string]*time.Time)
+	for _, item := range batch {
+		roomId := item.room.GetId()
+		if _, ok := rooms[roomId]; !ok {
+			rooms[roomId] = item.room
+			expireAt[roomId] = p.server.expireAt(roomId)
+		}
+
+		messages = append(messages, newCreateMessage(roomId, item.msg, expireAt[roomId]))
+	}
+
+	res, err := p.save(messages)
+	defer p.forget(batch)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while save %d messages into database", len(messages)))
+		p.deadLetter(messages)
+		return
+	}
+
+	p.delivered(messages, res, rooms)
+}
+
+func newCreateMessage(roomId string, msg *Message, expireAt *time.Time) *messageDB.CreateMessage {
+	// the time is set by the server when the message is sent
+	createdAt, err := time.Parse(time.RFC3339, msg.Time)
+	if err != nil {
+		createdAt = time.Now()
+	}
+
+	status := msg.Status
+	if msg.Status == "acc" {
+		status = "delv"
+	}
+
+	var replyTo *primitive.ObjectID
+	if parentID, err := primitive.ObjectIDFromHex(msg.ReplyTo); err == nil {
+		replyTo = &parentID
+	}
+
+	return &messageDB.CreateMessage{
+		Action:       msg.Action,
+		Message:      msg.Message,
+		RoomId:       roomId,
+		Sender:       msg.Sender.(I_User).GetUID(),
+		Status:       status,
+		Type:         msg.Type,
+		ReplyTo:      replyTo,
+		Attachments:  msg.Attachments,
+		SenderDevice: msg.SenderDevice,
+		Envelopes:    msg.Envelopes,
+		Time:         createdAt,
+		Mentions:     msg.mentionIds,
+		ClientId:     msg.ClientId,
+		ExpireAt:     expireAt,
+	}
+}
+
+// save retries the messages with a growing backoff, a retried message keeps its client id
+// and is saved once
+func (p *persister) save(messages []*messageDB.CreateMessage) ([]*messageDB.DelvMessage, error) {
+	backoff := persistRetryBackoff
+	for attempt := 1; ; attempt++ {
+		res, err := p.server.msgRepository.AddMessages(messages)
+		if err == nil {
+			atomic.AddInt64(&p.saved, int64(len(messages)))
+			return res, nil
+		}
+
+		if attempt >= persistRetries {
+			return nil, err
+		}
+
+		atomic.AddInt64(&p.retries, 1)
+		utils.Log().Error(err, fmt.Sprintf("error while save messages, retry %d in %s", attempt, backoff))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > persistMaxBackoff {
+			backoff = persistMaxBackoff
+		}
+	}
+}
+
+// delivered tells the rooms their saved messages with a delv, in the order they were sent
+func (p *persister) delivered(messages []*messageDB.CreateMessage, res []*messageDB.DelvMessage, rooms map[string]*Room) {
+	var order []string
+	byRoom := make(map[string][]int)
+	for i, msg := range messages {
+		if _, ok := byRoom[msg.RoomId]; !ok {
+			order = append(order, msg.RoomId)
+		}
+		byRoom[msg.RoomId] = append(byRoom[msg.RoomId], i)
+	}
+
+	for _, roomId := range order {
+		var roomMessages []*messageDB.CreateMessage
+		var saved []*messageDB.DelvMessage
+		for _, i := range byRoom[roomId] {
+			roomMessages = append(roomMessages, messages[i])
+			saved = append(saved, res[i])
+		}
+
+		p.server.incReplyCounts(roomMessages, saved)
+		p.server.notifyMentions(roomMessages, saved)
+		p.server.recordMessages(roomId, roomMessages, saved)
+
+		room := rooms[roomId]
+		if room == nil {
+			id, _ := uuid.Parse(roomId)
+			room = &Room{ID: id}
+		}
+
+		message, err := jsonrpc2.Notify(Delivered, &Messages{
+			Action:   Delivered,
+			Target:   room,
+			Messages: saved,
+		})
+		if err != nil {
+			utils.Log().Error(err, "error while create delv notify msg")
+			continue
+		}
+
+		utils.Log().V(2).Info(fmt.Sprintf("message delivered count: %d", len(saved)))
+		if err := p.server.broker.Publish(roomChannel(roomId), message.Encode()); err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while publishing %s to room %s", Delivered, roomId))
+		}
+	}
+}
+
+// incReplyCounts counts the saved replies, not the duplicates of replies counted already
+func (server *WsServer) incReplyCounts(messages []*messageDB.CreateMessage, saved []*messageDB.DelvMessage) {
+	replies := make(map[primitive.ObjectID]int)
+	for i, msg := range messages {
+		if msg.ReplyTo != nil && i < len(saved) && !saved[i].Duplicate {
+			replies[*msg.ReplyTo]++
+		}
+	}
+
+	for parentID, n := range replies {
+		if err := server.msgRepository.IncReplyCount(parentID, n); err != nil {
+			utils.Log().Error(err, "error while update reply count")
+		}
+	}
+}
+
+// deadLetter appends the messages to deadLetterFile, the last resort before losing them
+func (p *persister) deadLetter(messages []*messageDB.CreateMessage) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			utils.Log().Error(err, "error while encoding dead letter message")
+		}
+	}
+
+	f, err := os.OpenFile(deadLetterFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while writing %d messages to %s, they are lost", len(messages), deadLetterFile))
+		return
+	}
+
+	atomic.AddInt64(&p.deadLettered, int64(len(messages)))
+	utils.Log().Info(fmt.Sprintf("%d messages written to %s", len(messages), deadLetterFile))
+}
+
+// replayDeadLetters saves the messages of deadLetterFile, the ones still failing are kept there.
+// It runs on the persister goroutine only, the file has no other writer.
+func (p *persister) replayDeadLetters() {
+	data, err := os.ReadFile(deadLetterFile)
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	var messages []*messageDB.CreateMessage
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg *messageDB.CreateMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg == nil {
+			utils.Log().Error(err, fmt.Sprintf("error while reading a message of %s, skipped", deadLetterFile))
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	var left []*messageDB.CreateMessage
+	for from := 0; from < len(messages); from += persistBatchSize {
+		to := from + persistBatchSize
+		if to > len(messages) {
+			to = len(messages)
+		}
+
+		batch := messages[from:to]
+		res, err := p.server.msgRepository.AddMessages(batch)
+		if err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while saving messages of %s", deadLetterFile))
+			left = messages[from:]
+			break
+		}
+
+		atomic.AddInt64(&p.replayed, int64(len(batch)))
+		p.delivered(batch, res, nil)
+	}
+
+	if len(left) == 0 {
+		if err := os.Remove(deadLetterFile); err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while removing %s", deadLetterFile))
+		}
+		return
+	}
+
+	if len(left) == len(messages) {
+		return
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, msg := range left {
+		encoder.Encode(msg)
+	}
+
+	// the saved messages are dropped from the file at once, not to be saved twice
+	tmp := deadLetterFile + ".tmp"
+	err = os.WriteFile(tmp, buf.Bytes(), 0600)
+	if err == nil {
+		err = os.Rename(tmp, deadLetterFile)
+	}
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while rewriting %s", deadLetterFile))
+	}
+}
+
+func (p *persister) stats() *PersistStats {
+	return &PersistStats{
+		Queued:       len(p.queue),
+		Capacity:     cap(p.queue),
+		Saved:        atomic.LoadInt64(&p.saved),
+		Retries:      atomic.LoadInt64(&p.retries),
+		DeadLettered: atomic.LoadInt64(&p.deadLettered),
+		Replayed:     atomic.LoadInt64(&p.replayed),
+		Rejected:     atomic.LoadInt64(&p.rejected),
+	}
+}
+
+// PersistStats returns the counters of the persistence of sent messages
+func (server *WsServer) PersistStats() *PersistStats {
+	return server.persister.stats()
+}
+
+// Shutdown refuses new messages and saves the queued ones, it is called once the http server is stopped
+func (server *WsServer) Shutdown() {
+	utils.Log().Info("flushing queued messages")
+	server.persister.close()
+}
+
+// serveMetrics writes the persistence counters in the prometheus text format, to local clients only.
+// The address is the one of the connection, forwarded headers are not trusted here.
+func (server *WsServer) serveMetrics(c *gin.Context) {
+	if ip := net.ParseIP(c.RemoteIP()); ip == nil || !ip.IsLoopback() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	stats := server.PersistStats()
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.String(http.StatusOK, "pesatu_persist_queue_depth %d\n"+
+		"pesatu_persist_queue_capacity %d\n"+
+		"pesatu_persist_saved_total %d\n"+
+		"pesatu_persist_retries_total %d\n"+
+		"pesatu_persist_dead_lettered_total %d\n"+
+		"pesatu_persist_replayed_total %d\n"+
+		"pesatu_persist_rejected_total %d\n",
+		stats.Queued, stats.Capacity, stats.Saved, stats.Retries, stats.DeadLettered, stats.Replayed, stats.Rejected)
+}
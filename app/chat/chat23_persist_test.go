@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pesatu/components/messageDB"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type downMsgRepo struct {
+	messageDB.I_MessageRepo
+	added int
+}
+
+func (me *downMsgRepo) AddMessages(messages []*messageDB.CreateMessage) ([]*messageDB.DelvMessage, error) {
+	me.added += len(messages)
+	return nil, fmt.Errorf("database down")
+}
+
+func Test_PersistDeadLetter(t *testing.T) {
+	asserts := assert.New(t)
+
+	SetDeadLetterFile(filepath.Join(t.TempDir(), "deadletter.jsonl"))
+	repo := &downMsgRepo{}
+	p := newPersister(&WsServer{msgRepository: repo})
+
+	p.deadLetter([]*messageDB.CreateMessage{
+		{RoomId: "r1", Sender: "u1", Message: "hi", ClientId: "c1"},
+		{RoomId: "r1", Sender: "u1", Message: "again", ClientId: "c2"},
+	})
+	asserts.Equal(int64(2), p.stats().DeadLettered)
+
+	// the database is still down, the messages stay in the file
+	p.replayDeadLetters()
+	asserts.Equal(2, repo.added)
+	asserts.Equal(int64(0), p.stats().Replayed)
+	data, err := os.ReadFile(deadLetterFile)
+	asserts.Nil(err)
+	asserts.Contains(string(data), `"client_id":"c2"`)
+
+	// a closed persister refuses new messages
+	go p.run()
+	p.close()
+	asserts.Equal(errPersistBusy, p.enqueue(&Room{}, &Message{}))
+	asserts.Equal(int64(1), p.stats().Rejected)
+}
+
+func Test_ServeMetricsLocal(t *testing.T) {
+	asserts := assert.New(t)
+	gin.SetMode(gin.TestMode)
+	server := newTestServer()
+	server.persister = newPersister(server)
+
+	// every proxy is trusted, the forwarded address must still be ignored
+	engine := gin.New()
+	engine.GET("/metrics", server.serveMetrics)
+
+	get := func(remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	asserts.Equal(http.StatusNotFound, get("203.0.113.7:4000", "127.0.0.1"))
+	asserts.Equal(http.StatusOK, get("127.0.0.1:4000", "203.0.113.7"))
+}
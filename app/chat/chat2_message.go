@@ -2,16 +2,44 @@ package chat
 
 import (
 	"encoding/json"
+	"pesatu/components/contacts"
+	"pesatu/components/messageDB"
+	"pesatu/components/notification"
 	"pesatu/utils"
 )
 
 type Message struct {
+	Id      string      `json:"id,omitempty" bson:"id,omitempty"`
 	Action  string      `json:"action" bson:"action"`
 	Message string      `json:"message" bson:"message"`
 	Target  *Room       `json:"target" bson:"target"`
 	Sender  interface{} `json:"sender" bson:"sender"`
 	Status  string      `json:"status" bson:"status"`
-	Time    string      `json:"time" bson:"time"`
+	Type    string      `json:"type,omitempty" bson:"type,omitempty"`
+	ReplyTo string      `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	// only the id is read from the sender, the rest is filled from the upload
+	Attachments []*messageDB.Attachment `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	// end-to-end encrypted content, one envelope per device of the members, Message is then empty
+	SenderDevice string                `json:"sender_device,omitempty" bson:"sender_device,omitempty"`
+	Envelopes    []*messageDB.Envelope `json:"envelopes,omitempty" bson:"envelopes,omitempty"`
+	// a forwarded copy, with the username of the sender of the original
+	Forwarded     bool   `json:"forwarded,omitempty" bson:"forwarded,omitempty"`
+	ForwardedFrom string `json:"forwarded_from,omitempty" bson:"forwarded_from,omitempty"`
+	// usernames of the members mentioned, set by the server
+	Mentions   []string `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	mentionIds []string
+	// id of a sent message given by the client, its delv ack has it with the id given by the server
+	ClientId string `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	// the server sets the time of a sent message
+	Time string `json:"time" bson:"time"`
+	// history page of a get-msg request
+	Query *messageDB.MessageQuery `json:"query,omitempty" bson:"-"`
+	// filters of a search-msg request
+	Search *messageDB.SearchRequest `json:"search,omitempty" bson:"-"`
+	// messages and rooms of a forward-msg request
+	Forward *messageDB.ForwardRequest `json:"forward,omitempty" bson:"-"`
+	// last event sequence the client got of a replay request, or last frame of an ack
+	Seq int64 `json:"seq,omitempty" bson:"-"`
 }
 
 type Messages struct {
@@ -23,6 +51,55 @@ type Messages struct {
 	Time     string      `json:"time" bson:"time"`
 }
 
+// PubSubMessage is a server wide event as it travels between nodes through the Broker,
+// it keeps the user ids which are never sent to websocket clients.
+type PubSubMessage struct {
+	Action   string  `json:"action"`
+	Message  string  `json:"message"`
+	RoomName string  `json:"room_name,omitempty"`
+	SenderID string  `json:"sender_id"`
+	Sender   *Sender `json:"sender"`
+	// the notification of a mention, sent to the clients of the user in Message
+	Notification *notification.DBNotification `json:"notification,omitempty"`
+	// a change of a contact of the user in Message
+	Contact *contacts.ContactEvent `json:"contact,omitempty"`
+	// the node which published the message, and the users connected to it in a presence-sync
+	Node     string           `json:"node,omitempty"`
+	Presence []*presenceEntry `json:"presence,omitempty"`
+}
+
+func NewPubSubMessage(action, message string, sender I_User) *PubSubMessage {
+	m := &PubSubMessage{Action: action, Message: message, SenderID: sender.GetUID()}
+	switch s := sender.(type) {
+	case *Client:
+		m.Sender = NewSender(s.GetUID(), s.Name, s.Username, s.Avatar)
+	case *Sender:
+		m.Sender = s
+	default:
+		m.Sender = NewSender(s.GetUID(), "", s.GetUsername(), "")
+	}
+
+	return m
+}
+
+// GetSender returns the publisher of the message with its uid restored
+func (message *PubSubMessage) GetSender() *Sender {
+	if message.Sender == nil {
+		return NewSender(message.SenderID, "", "", "")
+	}
+
+	return NewSender(message.SenderID, message.Sender.Name, message.Sender.Username, message.Sender.Avatar)
+}
+
+func (message *PubSubMessage) encode() []byte {
+	json, err := json.Marshal(message)
+	if err != nil {
+		utils.Log().Error(err, "error while marshaling pubsub message")
+	}
+
+	return json
+}
+
 func (message *Message) encode() []byte {
 	json, err := json.Marshal(message)
 	if err != nil {
@@ -0,0 +1,200 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// PubSubGeneralChannel carries server wide events: user join/left and private room invites
+	PubSubGeneralChannel = "pesatu:general"
+
+	// prefix of the channel used by every room, followed by the room id
+	pubSubRoomChannel = "pesatu:room:"
+
+	// max queued payloads per in-memory subscription
+	memoryBrokerQueueSize = 1024
+)
+
+// Broker fans out messages to every WsServer node sharing it,
+// including the node which published the message.
+type Broker interface {
+	Publish(channel string, payload []byte) error
+	// Subscribe calls handler for every payload published to channel,
+	// in publish order, until the returned unsubscribe func is called.
+	Subscribe(channel string, handler func(payload []byte)) (func(), error)
+	Close() error
+}
+
+func roomChannel(roomID string) string {
+	return pubSubRoomChannel + roomID
+}
+
+// roomSignalChannel carries the ephemeral signals of a room, like typing
+func roomSignalChannel(roomID string) string {
+	return pubSubRoomChannel + roomID + ":signal"
+}
+
+type memorySubscription struct {
+	queue chan []byte
+	done  chan struct{}
+}
+
+// MemoryBroker is an in-process Broker, used when only one node is running
+type MemoryBroker struct {
+	mu     sync.RWMutex
+	subs   map[string]map[*memorySubscription]bool
+	closed bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subs: make(map[string]map[*memorySubscription]bool),
+	}
+}
+
+func (me *MemoryBroker) Publish(channel string, payload []byte) error {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+
+	if me.closed {
+		return fmt.Errorf("broker closed")
+	}
+
+	var err error
+	for sub := range me.subs[channel] {
+		select {
+		case sub.queue <- payload:
+		default:
+			err = fmt.Errorf("subscriber queue of %s is full, payload dropped", channel)
+		}
+	}
+
+	return err
+}
+
+func (me *MemoryBroker) Subscribe(channel string, handler func(payload []byte)) (func(), error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.closed {
+		return nil, fmt.Errorf("broker closed")
+	}
+
+	sub := &memorySubscription{
+		queue: make(chan []byte, memoryBrokerQueueSize),
+		done:  make(chan struct{}),
+	}
+
+	if me.subs[channel] == nil {
+		me.subs[channel] = make(map[*memorySubscription]bool)
+	}
+	me.subs[channel][sub] = true
+
+	go func() {
+		for {
+			select {
+			case payload := <-sub.queue:
+				handler(payload)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	unsubscribe := func() {
+		me.mu.Lock()
+		defer me.mu.Unlock()
+
+		// already removed by a previous call or by Close
+		if _, ok := me.subs[channel][sub]; !ok {
+			return
+		}
+
+		delete(me.subs[channel], sub)
+		if len(me.subs[channel]) == 0 {
+			delete(me.subs, channel)
+		}
+		close(sub.done)
+	}
+
+	return unsubscribe, nil
+}
+
+func (me *MemoryBroker) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if me.closed {
+		return nil
+	}
+
+	me.closed = true
+	for _, subs := range me.subs {
+		for sub := range subs {
+			close(sub.done)
+		}
+	}
+	me.subs = nil
+
+	return nil
+}
+
+// RedisBroker shares messages between nodes through redis PUBLISH/SUBSCRIBE
+type RedisBroker struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// NewRedisBroker connects to redis, url format: redis://[:password@]host:port/db
+func NewRedisBroker(ctx context.Context, url string) (*RedisBroker, error) {
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %s", err.Error())
+	}
+
+	client := redis.NewClient(opt)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error connecting to redis: %s", err.Error())
+	}
+
+	return &RedisBroker{client, ctx}, nil
+}
+
+func (me *RedisBroker) Publish(channel string, payload []byte) error {
+	return me.client.Publish(me.ctx, channel, payload).Err()
+}
+
+func (me *RedisBroker) Subscribe(channel string, handler func(payload []byte)) (func(), error) {
+	pubsub := me.client.Subscribe(me.ctx, channel)
+
+	// wait for the subscription to be confirmed, so nothing published after return is missed
+	if _, err := pubsub.Receive(me.ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	ch := pubsub.Channel()
+	go func() {
+		for msg := range ch {
+			handler([]byte(msg.Payload))
+		}
+	}()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			pubsub.Close()
+		})
+	}
+
+	return unsubscribe, nil
+}
+
+func (me *RedisBroker) Close() error {
+	return me.client.Close()
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run test against a local redis-server with
+// REDIS_TEST_URL=redis://localhost:6379/0 go test -v -run=Test_RedisBroker
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'chat'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'chat'")
+}
+
+func receive(ch chan []byte) string {
+	select {
+	case payload := <-ch:
+		return string(payload)
+	case <-time.After(2 * time.Second):
+		return "#timeout"
+	}
+}
+
+func testBroker(t *testing.T, broker Broker) {
+	asserts := assert.New(t)
+
+	nodeA := make(chan []byte, 10)
+	nodeB := make(chan []byte, 10)
+	other := make(chan []byte, 10)
+
+	unsubA, err := broker.Subscribe(roomChannel("room-1"), func(p []byte) { nodeA <- p })
+	asserts.Nil(err)
+	unsubB, err := broker.Subscribe(roomChannel("room-1"), func(p []byte) { nodeB <- p })
+	asserts.Nil(err)
+	unsubO, err := broker.Subscribe(roomChannel("room-2"), func(p []byte) { other <- p })
+	asserts.Nil(err)
+	defer unsubO()
+
+	asserts.Nil(broker.Publish(roomChannel("room-1"), []byte("first")))
+	asserts.Nil(broker.Publish(roomChannel("room-1"), []byte("second")))
+
+	// every subscriber gets every message, in order
+	asserts.Equal("first", receive(nodeA))
+	asserts.Equal("second", receive(nodeA))
+	asserts.Equal("first", receive(nodeB))
+	asserts.Equal("second", receive(nodeB))
+
+	unsubA()
+	unsubA()
+	asserts.Nil(broker.Publish(roomChannel("room-1"), []byte("third")))
+	asserts.Equal("third", receive(nodeB))
+
+	select {
+	case p := <-nodeA:
+		t.Errorf("unsubscribed handler received %s", string(p))
+	case p := <-other:
+		t.Errorf("other channel received %s", string(p))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unsubB()
+}
+
+func Test_MemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	testBroker(t, broker)
+
+	asserts := assert.New(t)
+	asserts.Nil(broker.Close())
+	asserts.NotNil(broker.Publish(PubSubGeneralChannel, []byte("closed")))
+}
+
+func Test_RedisBroker(t *testing.T) {
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		t.Skip("REDIS_TEST_URL is not set")
+	}
+
+	broker, err := NewRedisBroker(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	testBroker(t, broker)
+}
+
+func Test_PubSubMessage(t *testing.T) {
+	asserts := assert.New(t)
+
+	sender := NewSender("3b241101-e2bb-4255-8caf-4136c566a962", "Ujang", "ujang", "/image/1")
+	message := NewPubSubMessage(JoinRoomPrivateAction, "target-uid", sender)
+	message.RoomName = "a-b"
+
+	var decoded PubSubMessage
+	asserts.Nil(json.Unmarshal(message.encode(), &decoded))
+	asserts.Equal("a-b", decoded.RoomName)
+	asserts.Equal("target-uid", decoded.Message)
+	asserts.Equal(sender.GetUID(), decoded.GetSender().GetUID())
+	asserts.Equal("ujang", decoded.GetSender().GetUsername())
+}
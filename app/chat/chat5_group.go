@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"pesatu/components/eventlog"
+	"pesatu/components/messageDB"
+	"pesatu/components/roommember"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"strconv"
+)
+
+// NotifyMemberEvent saves a group membership change or a room timer change as a room message
+// and pushes it to every node, it is called by the roommember rpc handlers
+func (server *WsServer) NotifyMemberEvent(event *roommember.MemberEvent) {
+	utils.Log().V(2).Info(fmt.Sprintf("notify %s %s in group %s", event.Action, event.Member, event.Room.GetId()))
+
+	// a timer change is saved with the seconds, it never disappears itself
+	content := event.Member
+	if event.Action == roommember.TimerChanged {
+		content = strconv.FormatInt(event.ExpireAfter, 10)
+	}
+
+	saved, err := server.msgRepository.AddMessage(&messageDB.CreateMessage{
+		Action:  event.Action,
+		Message: content,
+		RoomId:  event.Room.GetId(),
+		Sender:  event.ActorUID,
+		Status:  Delivered,
+		Time:    event.Time,
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while saving member event")
+	} else {
+		server.recordOffline(event.Room.GetId(), &eventlog.CreateEvent{Action: event.Action, MsgId: saved.Id.Hex(), Actor: event.Actor, Time: event.Time})
+	}
+
+	m, err := jsonrpc2.Notify(event.Action, event)
+	if err != nil {
+		utils.Log().Error(err, "error while create member event notify")
+		return
+	}
+
+	// members already in the room, a removed member is dropped after receiving it
+	if err := server.broker.Publish(roomChannel(event.Room.GetId()), m.Encode()); err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing %s", event.Action))
+	}
+
+	// new members are joined to the room on whichever node they are connected
+	if event.Action == roommember.GroupCreated || event.Action == roommember.MemberAdded {
+		message := NewPubSubMessage(event.Action, event.MemberUID, NewSender(event.ActorUID, "", event.Actor, ""))
+		message.RoomName = event.Room.GetName()
+		server.publish(message)
+	}
+}
+
+func (server *WsServer) handleMemberJoinGroup(message *PubSubMessage) {
+	targetClients := server.findClientByID(message.Message)
+	for _, targetClient := range targetClients {
+		_ = targetClient.joinRoom(message.RoomName, message.GetSender(), false, message.Action)
+	}
+}
+
+// handleGroupEvent drops the local clients of a member who is removed or left the group
+func (room *Room) handleGroupEvent(payload []byte) {
+	var rpc jsonrpc2.RPCRequest
+	if err := json.Unmarshal(payload, &rpc); err != nil {
+		return
+	}
+
+	if rpc.Method != roommember.MemberRemoved && rpc.Method != roommember.MemberLeft {
+		return
+	}
+
+	var event roommember.MemberEvent
+	if err := json.Unmarshal(rpc.Params, &event); err != nil {
+		utils.Log().Error(err, "error on unmarshal member event")
+		return
+	}
+
+	for client := range room.clients {
+		if client.GetUsername() != event.Member {
+			continue
+		}
+
+		utils.Log().V(2).Info(fmt.Sprintf("%s is removed from group %s", client.Name, room.Name))
+		room.stopSignal(client)
+		client.removeRoom(room)
+		delete(room.clients, client)
+	}
+
+	room.disposeIfEmpty()
+}
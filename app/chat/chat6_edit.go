@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/eventlog"
+	"pesatu/components/messageDB"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"strings"
+	"time"
+)
+
+// findRoomMessage checks the message belongs to a room the client is in and returns it from the database
+func (me *Client) findRoomMessage(action string, message Message) (*Room, *messageDB.DBMessage) {
+	if message.Target == nil {
+		me.notifyInfo(nil, me, action+", room is required", "error", message.Time)
+		return nil, nil
+	}
+
+	room := me.wsServer.findRoomByID(message.Target.GetId())
+	if room == nil || !me.isInRoom(room) {
+		me.notifyInfo(nil, me, action+", you are not in this room", "error", message.Time)
+		return nil, nil
+	}
+
+	dbMsg, err := me.wsServer.msgRepository.FindMessageById(message.Id)
+	if err != nil {
+		me.notifyInfo(room, me, action+", "+err.Error(), "error", message.Time)
+		return nil, nil
+	}
+
+	if dbMsg.RoomId != room.GetId() {
+		me.notifyInfo(room, me, action+", message is not in this room", "error", message.Time)
+		return nil, nil
+	}
+
+	return room, dbMsg
+}
+
+func (me *Client) handleEditMessage(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("edit msg %s by %s", message.Id, me.GetUsername()))
+
+	if len(strings.TrimSpace(message.Message)) == 0 {
+		me.notifyInfo(nil, me, EditMessageAction+", message can not empty", "error", message.Time)
+		return
+	}
+
+	room, dbMsg := me.findRoomMessage(EditMessageAction, message)
+	if dbMsg == nil {
+		return
+	}
+
+	if dbMsg.Sender != me.GetUID() {
+		me.notifyInfo(room, me, EditMessageAction+", only sender can edit the message", "error", message.Time)
+		return
+	}
+
+	if dbMsg.Deleted {
+		me.notifyInfo(room, me, EditMessageAction+", message has been deleted", "error", message.Time)
+		return
+	}
+
+	// an edit is plain text, it would reveal what the envelopes hide
+	if len(dbMsg.Envelopes) > 0 {
+		me.notifyInfo(room, me, EditMessageAction+", encrypted message can not be edited", "error", message.Time)
+		return
+	}
+
+	if time.Since(dbMsg.Time) > editMessageWindow {
+		me.notifyInfo(room, me, EditMessageAction+", edit time is over", "error", message.Time)
+		return
+	}
+
+	if dbMsg.Message == message.Message {
+		return
+	}
+
+	edited, err := me.wsServer.msgRepository.EditMessage(dbMsg, message.Message)
+	if err != nil {
+		me.notifyInfo(room, me, EditMessageAction+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	me.wsServer.recordOffline(room.GetId(), &eventlog.CreateEvent{Action: EditMessageAction, MsgId: message.Id, Actor: me.GetUsername(), Time: *edited.EditedAt})
+
+	room.broadcastMessage(&Message{
+		Id:      message.Id,
+		Action:  EditMessageAction,
+		Message: edited.Message,
+		Target:  room,
+		Sender:  me,
+		Status:  "edited",
+		Time:    edited.EditedAt.Format(time.RFC3339),
+	})
+}
+
+func (me *Client) handleDeleteMessage(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("delete msg %s for %s by %s", message.Id, message.Message, me.GetUsername()))
+
+	if message.Message != DeleteForEveryone && message.Message != DeleteForMe {
+		me.notifyInfo(nil, me, DeleteMessageAction+", unknown scope "+message.Message, "error", message.Time)
+		return
+	}
+
+	room, dbMsg := me.findRoomMessage(DeleteMessageAction, message)
+	if dbMsg == nil {
+		return
+	}
+
+	reply := &Message{
+		Id:      message.Id,
+		Action:  DeleteMessageAction,
+		Message: message.Message,
+		Target:  room,
+		Sender:  me,
+		Status:  "deleted",
+		Time:    time.Now().Format(time.RFC3339),
+	}
+
+	// hidden only for the user, the other members keep it
+	if message.Message == DeleteForMe {
+		err := me.wsServer.msgRepository.DeleteMessageForUser(dbMsg.Id, me.GetUID())
+		if err != nil {
+			me.notifyInfo(room, me, DeleteMessageAction+", "+err.Error(), "error", message.Time)
+			return
+		}
+
+		m, err := jsonrpc2.Notify(DeleteMessageAction, reply)
+		if err != nil {
+			utils.Log().Error(err, "error while create jsonrpc2 notify")
+			return
+		}
+		me.SendMsg(m.Encode())
+		return
+	}
+
+	if dbMsg.Sender != me.GetUID() {
+		me.notifyInfo(room, me, DeleteMessageAction+", only sender can delete the message for everyone", "error", message.Time)
+		return
+	}
+
+	if !dbMsg.Deleted {
+		_, err := me.wsServer.msgRepository.DeleteMessageForAll(dbMsg.Id)
+		if err != nil {
+			me.notifyInfo(room, me, DeleteMessageAction+", "+err.Error(), "error", message.Time)
+			return
+		}
+		me.wsServer.recordOffline(room.GetId(), &eventlog.CreateEvent{Action: DeleteMessageAction, MsgId: message.Id, Actor: me.GetUsername(), Time: time.Now()})
+	}
+
+	room.broadcastMessage(reply)
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// editRepo keeps one message and applies the edits to it
+type editRepo struct {
+	messageDB.I_MessageRepo
+	msg *messageDB.DBMessage
+}
+
+func (me *editRepo) FindMessageById(msgId string) (*messageDB.DBMessage, error) {
+	if msgId != me.msg.Id.Hex() {
+		return nil, fmt.Errorf("message not found")
+	}
+	return me.msg, nil
+}
+
+func (me *editRepo) EditMessage(msg *messageDB.DBMessage, newMessage string) (*messageDB.DBMessage, error) {
+	now := time.Now()
+	msg.Edits = append(msg.Edits, &messageDB.MessageEdit{Message: msg.Message, EditedAt: now})
+	msg.Message = newMessage
+	msg.EditedAt = &now
+	return msg, nil
+}
+
+func Test_EditMessageWindow(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	room := server.addRoom(NewRoom(server, "group", false))
+	client := newTestClient(server)
+	client.addRoom(room)
+
+	repo := &editRepo{msg: &messageDB.DBMessage{Id: primitive.NewObjectID(), RoomId: room.GetId(), Sender: client.GetUID(), Message: "hi"}}
+	server.msgRepository = repo
+	server.roomRepository = &memberRepo{}
+
+	edit := func(text string) {
+		client.handleEditMessage(Message{Id: repo.msg.Id.Hex(), Action: EditMessageAction, Message: text, Target: room})
+	}
+
+	// within the window the edit is saved and the previous content kept
+	repo.msg.Time = time.Now().Add(-editMessageWindow + time.Minute)
+	edit("hello")
+	asserts.Equal("hello", repo.msg.Message)
+	asserts.Len(repo.msg.Edits, 1)
+
+	// once the window is over it is refused
+	repo.msg.Time = time.Now().Add(-editMessageWindow - time.Minute)
+	edit("too late")
+	asserts.Equal("hello", repo.msg.Message)
+	asserts.Contains(string(<-client.send), "edit time is over")
+
+	// only the sender edits
+	repo.msg.Time = time.Now()
+	repo.msg.Sender = "someone"
+	edit("not mine")
+	asserts.Equal("hello", repo.msg.Message)
+	asserts.Contains(string(<-client.send), "only sender can edit the message")
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"strconv"
+	"strings"
+)
+
+// parsePageLimit reads the "page,limit" parts of a request
+func parsePageLimit(parts []string) (int, int, error) {
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("expected page,limit")
+	}
+
+	page, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid page number: %s", parts[0])
+	}
+
+	limit, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid limit number: %s", parts[1])
+	}
+
+	return page, limit, nil
+}
+
+// checkReplyTo makes sure the parent of a reply is in the same room
+func (me *Client) checkReplyTo(room *Room, message Message) bool {
+	parent, err := me.wsServer.msgRepository.FindMessageById(message.ReplyTo)
+	if err != nil {
+		me.notifyInfo(room, me, SendMessageAction+", reply to "+err.Error(), "error", message.Time)
+		return false
+	}
+
+	if parent.RoomId != room.GetId() {
+		me.notifyInfo(room, me, SendMessageAction+", reply to a message of another room", "error", message.Time)
+		return false
+	}
+
+	return true
+}
+
+// handleGetThread sends a message and its replies, message.Id is the parent and message.Message is "page,limit"
+func (me *Client) handleGetThread(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("get thread %s by %s", message.Id, me.GetUsername()))
+
+	page, limit, err := parsePageLimit(strings.Split(message.Message, ","))
+	if err != nil {
+		me.notifyInfo(nil, me, GetThread+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	parent, err := me.wsServer.msgRepository.FindMessageById(message.Id)
+	if err != nil {
+		me.notifyInfo(nil, me, GetThread+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	member, err := me.wsServer.roomRepository.FindMember(parent.RoomId, me.GetUID())
+	if err != nil {
+		me.notifyInfo(nil, me, GetThread+", you are not a member of this room", "error", message.Time)
+		return
+	}
+
+	// a cleared parent is gone for the member, as in get-msg
+	if !isAfterMark(parent.Id, member.ClearedUpTo) {
+		me.notifyInfo(nil, me, GetThread+", message unavailable", "error", message.Time)
+		return
+	}
+
+	replies, err := me.wsServer.msgRepository.FindThreadMessages(parent.Id, me.GetUID(), member.ClearedUpTo, page, limit)
+	if err != nil {
+		me.notifyInfo(nil, me, GetThread+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	// the parent sender is shown by username, as in get-msg
+	if sender, err := me.wsServer.msgRepository.FindUserById(parent.Sender); err == nil {
+		parent.Sender = sender.Username
+	}
+
+	m, err := jsonrpc2.Notify(GetThread, Messages{
+		Action:   GetThread,
+		Target:   message.Target,
+		Sender:   me,
+		Messages: &messageDB.Thread{Parent: parent, Replies: replies},
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create jsonrpc2 notify")
+		return
+	}
+	me.SendMsg(m.Encode())
+}
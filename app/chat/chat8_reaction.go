@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/utils"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isValidReaction accepts a short emoji, without spaces or control characters
+func isValidReaction(s string) bool {
+	if len(s) == 0 || len(s) > maxReactionSize || !utf8.ValidString(s) {
+		return false
+	}
+
+	// plain text is not an emoji, keycaps like 1️⃣ still start with ascii
+	ascii := true
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+		if r >= utf8.RuneSelf {
+			ascii = false
+		}
+	}
+
+	return !ascii
+}
+
+// handleReaction adds or removes the emoji in message.Message on the message message.Id
+func (me *Client) handleReaction(message Message) {
+	utils.Log().V(2).Info(fmt.Sprintf("%s %s on msg %s by %s", message.Action, message.Message, message.Id, me.GetUsername()))
+
+	if !isValidReaction(message.Message) {
+		me.notifyInfo(nil, me, message.Action+", invalid emoji", "error", message.Time)
+		return
+	}
+
+	room, dbMsg := me.findRoomMessage(message.Action, message)
+	if dbMsg == nil {
+		return
+	}
+
+	if dbMsg.Deleted {
+		me.notifyInfo(room, me, message.Action+", message has been deleted", "error", message.Time)
+		return
+	}
+
+	var err error
+	if message.Action == ReactAction {
+		err = me.wsServer.msgRepository.AddReaction(dbMsg, me.GetUID(), message.Message)
+	} else {
+		err = me.wsServer.msgRepository.RemoveReaction(dbMsg.Id, me.GetUID(), message.Message)
+	}
+
+	if err != nil {
+		me.notifyInfo(room, me, message.Action+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	room.broadcastMessage(&Message{
+		Id:      message.Id,
+		Action:  message.Action,
+		Message: message.Message,
+		Target:  room,
+		Sender:  me,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+)
+
+// roomSignal is an ephemeral room event as it travels through the Broker,
+// it is never saved and never sent back to the clients of its sender
+type roomSignal struct {
+	From    string          `json:"from"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func isSignalAction(action string) bool {
+	return action == TypingStartAction || action == TypingStopAction || action == RecordingAudioAction
+}
+
+// handleSignal forwards typing-start, typing-stop and recording-audio to the room, at most signalRate per second
+func (me *Client) handleSignal(message Message) {
+	if message.Target == nil || !isSignalAction(message.Action) {
+		return
+	}
+
+	room := me.wsServer.findRoomByID(message.Target.GetId())
+	if room == nil || !me.isInRoom(room) {
+		return
+	}
+
+	// a stop is always let through, so an indicator is not left on, the room drops a stop ending nothing
+	if message.Action != TypingStopAction && me.signalLimiter.TakeAvailable(1) == 0 {
+		utils.Log().V(2).Info(fmt.Sprintf("drop %s from %s, rate limited", message.Action, me.GetUsername()))
+		return
+	}
+
+	select {
+	case room.signal <- &Message{
+		Action: message.Action,
+		Target: room,
+		Sender: me,
+		Time:   time.Now().Format(time.RFC3339),
+	}:
+	case <-room.done:
+	}
+}
+
+// publishSignal sends a signal to the other clients of the room on every node
+func (room *Room) publishSignal(message *Message) {
+	client, ok := message.Sender.(*Client)
+	if !ok {
+		return
+	}
+
+	// a stop ends a signal of the client, the others are not relayed so stops can not flood the room
+	if message.Action == TypingStopAction {
+		if _, ok := room.signals[client]; !ok {
+			return
+		}
+		delete(room.signals, client)
+	} else {
+		room.signals[client] = message.Action
+	}
+
+	m, err := jsonrpc2.Notify(message.Action, message)
+	if err != nil {
+		utils.Log().Error(err, "error while create signal notify")
+		return
+	}
+
+	signal, err := json.Marshal(&roomSignal{From: client.GetUID(), Payload: m.Encode()})
+	if err != nil {
+		utils.Log().Error(err, "error while marshaling signal")
+		return
+	}
+
+	err = room.wsServer.broker.Publish(roomSignalChannel(room.GetId()), signal)
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing signal to room %s", room.GetName()))
+	}
+}
+
+// stopSignal expires the signal of a client which is leaving the room mid-typing
+func (room *Room) stopSignal(client *Client) {
+	if _, ok := room.signals[client]; !ok {
+		return
+	}
+
+	room.publishSignal(&Message{
+		Action: TypingStopAction,
+		Target: room,
+		Sender: client,
+		Time:   time.Now().Format(time.RFC3339),
+	})
+}
+
+func (room *Room) deliverSignal(signal *roomSignal) {
+	for client := range room.clients {
+		if client.GetUID() == signal.From {
+			continue
+		}
+		client.SendMsg(signal.Payload)
+	}
+}
+
+func (room *Room) subscribeToRoomSignals() {
+	unsubscribe, err := room.wsServer.broker.Subscribe(roomSignalChannel(room.GetId()), func(payload []byte) {
+		var signal roomSignal
+		if err := json.Unmarshal(payload, &signal); err != nil {
+			utils.Log().Error(err, "error on unmarshal room signal")
+			return
+		}
+		select {
+		case room.incomingSignal <- &signal:
+		case <-room.done:
+		}
+	})
+
+	if err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while subscribing to room signals %s", room.GetName()))
+		return
+	}
+
+	room.unsubscribeSignals = unsubscribe
+}
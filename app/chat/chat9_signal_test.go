@@ -0,0 +1,34 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SignalStop(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	room := NewRoom(server, "a-b", true)
+	client := newTestClient(server)
+
+	signals := make(chan []byte, 4)
+	_, err := server.broker.Subscribe(roomSignalChannel(room.GetId()), func(payload []byte) { signals <- payload })
+	asserts.Nil(err)
+
+	signal := func(action string) {
+		room.publishSignal(&Message{Action: action, Target: room, Sender: client})
+	}
+
+	// a stop without a signal to end is dropped
+	signal(TypingStopAction)
+	signal(TypingStartAction)
+	signal(TypingStopAction)
+	signal(TypingStopAction)
+	signal(RecordingAudioAction)
+
+	for _, action := range []string{TypingStartAction, TypingStopAction, RecordingAudioAction} {
+		asserts.Contains(string(<-signals), fmt.Sprintf(`"method":"%s"`, action))
+	}
+}
@@ -7,16 +7,20 @@ import (
 	"pesatu/app/vicall"
 	"pesatu/auth"
 	"pesatu/components/contacts"
+	"pesatu/components/messageDB"
+	"pesatu/components/roommember"
 	"pesatu/jsonrpc2"
 	"pesatu/utils"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/juju/ratelimit"
 )
 
 // Client represents the websocket client at the server
@@ -30,10 +34,16 @@ type Client struct {
 	Username       string `json:"username"`
 	Avatar         string `json:"avatar"`
 	rooms          map[*Room]bool
+	roomsMu        sync.Mutex
 	contactService contacts.I_ContactRepo
 	vicall         *vicall.JSONSignal
 	wg             *sync.WaitGroup
-	disposed       bool
+	signalLimiter  *ratelimit.Bucket
+	// send is closed once disposed, under sendMu so no message is sent to it then
+	disposed atomic.Bool
+	sendMu   sync.RWMutex
+	// nil unless the client connected with the session protocol
+	session *session
 }
 
 func newClient(conn *websocket.Conn, wsServer *WsServer, username string, ID string, contactRepo contacts.I_ContactRepo) (*Client, error) {
@@ -55,7 +65,7 @@ func newClient(conn *websocket.Conn, wsServer *WsServer, username string, ID str
 		rooms:          make(map[*Room]bool),
 		contactService: contactRepo,
 		wg:             &wg,
-		disposed:       false,
+		signalLimiter:  ratelimit.NewBucketWithRate(signalRate, signalBurst),
 	}
 
 	if ID != "" {
@@ -95,6 +105,16 @@ func ServeWs(wsServer *WsServer, c *gin.Context, contactRepo contacts.I_ContactR
 		return
 	}
 
+	// session=new starts a session, session=<id>&seq=<last frame> resumes one, a new one starts
+	// when it can not be resumed
+	sessionID := c.Query("session")
+	if len(sessionID) > 0 && sessionID != "new" {
+		seq, _ := strconv.ParseInt(c.Query("seq"), 10, 64)
+		if wsServer.resumeSession(sessionID, user.GetUID(), conn, seq) {
+			return
+		}
+	}
+
 	client, err := newClient(conn, wsServer, user.GetUsername(), user.GetUID(), contactRepo)
 	if err != nil {
 		utils.Log().Error(err, "error while creating client")
@@ -102,8 +122,12 @@ func ServeWs(wsServer *WsServer, c *gin.Context, contactRepo contacts.I_ContactR
 	}
 	client.vicall = vicall.NewJSONSignal(vicall.NewPeer(wsServer.ionsfu), utils.Log())
 
-	go client.writeThread()
-	go client.readThread()
+	if len(sessionID) > 0 {
+		wsServer.startSession(client, conn)
+	} else {
+		go client.writeThread()
+		go client.readThread(conn)
+	}
 
 	wsServer.register <- client
 	utils.Log().Info("ServeWs " + user.GetUsername())
@@ -117,23 +141,23 @@ func (me *Client) GetUsername() string {
 	return me.Username
 }
 
-func (me *Client) readThread() {
+func (me *Client) readThread(conn *websocket.Conn) {
 	defer func() {
 		me.wg.Done()
-		me.disconnect()
+		me.disconnect(conn)
 	}()
 
-	// me.conn.SetReadLimit(maxMessageSize)
-	me.conn.SetReadDeadline(time.Now().Add(pongWait))
-	me.conn.SetPongHandler(func(string) error {
+	// conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
 		// keep connection alive
-		me.conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
 	// Start endless read loop, waiting for messages from client
 	for {
-		_, jsonMessage, err := me.conn.ReadMessage()
+		_, jsonMessage, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				utils.Log().Error(err, "unexpected websocket close error")
@@ -151,7 +175,7 @@ func (me *Client) readThread() {
 
 		me.handleNewMessage(jsonMessage)
 
-		if me.disposed {
+		if me.disposed.Load() {
 			break
 		}
 	}
@@ -198,15 +222,46 @@ func (me *Client) writeThread() {
 	}
 }
 
-func (me *Client) disconnect() {
+// disconnect drops the client when conn is closed, unless its session waits for a resume
+func (me *Client) disconnect(conn *websocket.Conn) {
+	if me.session != nil && !me.disposed.Load() && me.session.detach(conn, me.teardown) {
+		utils.Log().V(2).Info(fmt.Sprintf("session %s of %s detached", me.session.id, me.Username))
+		return
+	}
+
+	if me.session == nil || me.session.claim() {
+		me.teardown()
+	}
+}
+
+func (me *Client) teardown() {
 	utils.Log().Info("disconnect " + me.Username)
+	if me.session != nil {
+		me.wsServer.removeSession(me.session.id)
+	}
+
 	me.vicall.Close()
 	me.wsServer.unregister <- me
-	for room := range me.rooms {
-		room.unregister <- me
+	for _, room := range me.takeRooms() {
+		room.leave(me)
 	}
+
+	me.sendMu.Lock()
+	me.disposed.Store(true)
 	close(me.send)
-	me.conn.Close()
+	me.sendMu.Unlock()
+	if conn := me.currentConn(); conn != nil {
+		conn.Close()
+	}
+}
+
+// currentConn returns the connection the client is served on, the one attached to its session
+// for a session client, which is nil while the session waits for a resume
+func (me *Client) currentConn() *websocket.Conn {
+	if me.session != nil {
+		return me.session.current()
+	}
+	return me.conn
 }
 
 func (me *Client) handleNewMessage(jsonMessage []byte) {
@@ -241,8 +296,45 @@ func (me *Client) handleNewMessage(jsonMessage []byte) {
 	case GetMessages:
 		me.handleGetMessages(message)
 
-	case HasBeenRead:
-		me.handleHasBeenRead(message)
+	case HasBeenRead, ReceivedAction:
+		message.Action = rpc.Method
+		me.handleReceipt(message)
+
+	case GetThread:
+		me.handleGetThread(message)
+
+	case SearchMessagesAction:
+		me.handleSearchMessages(message)
+
+	case EditMessageAction:
+		me.handleEditMessage(message)
+
+	case DeleteMessageAction:
+		me.handleDeleteMessage(message)
+
+	case ReactAction, UnreactAction:
+		// the method decides, the action in params is only what the client claims
+		message.Action = rpc.Method
+		me.handleReaction(message)
+
+	case PinAction, UnpinAction:
+		message.Action = rpc.Method
+		me.handlePin(message)
+
+	case ForwardMessageAction:
+		me.handleForwardMessages(message)
+
+	case ReplayAction:
+		me.handleReplay(message)
+
+	case AckAction:
+		if me.session != nil {
+			me.session.ack(message.Seq)
+		}
+
+	case TypingStartAction, TypingStopAction, RecordingAudioAction:
+		message.Action = rpc.Method
+		me.handleSignal(message)
 
 	default:
 		me.handleVicall(&rpc)
@@ -290,7 +382,7 @@ func (me *Client) handleVicall(rpc *jsonrpc2.RPCRequest) {
 		}
 
 		ok = room.CheckMemberID(me.GetUID())
-		if !ok {
+		if !ok || me.wsServer.isBlockedInRoom(room.GetId(), room.Private, me.GetUID()) {
 			replyError(fmt.Errorf("error, client rejected"))
 			return
 		}
@@ -301,86 +393,171 @@ func (me *Client) handleVicall(rpc *jsonrpc2.RPCRequest) {
 	}
 }
 
-func (me *Client) handleHasBeenRead(message Message) {
-	roomID := message.Target.GetId()
-	if room := me.wsServer.findRoomByID(roomID); room != nil {
-		utils.Log().V(2).Info(fmt.Sprintf("msg has been read id: %s", message.Message))
-		room.broadcast <- &message
-		room.writeMsgToDB <- &message
-	}
-}
-
+// handleGetMessages sends a page of the room history, message.Query holds the cursor,
+// after the last message seen syncs a client which has been offline
 func (me *Client) handleGetMessages(message Message) {
 	roomID := message.Target.GetId()
 	room := me.wsServer.findRoomByID(roomID)
 
 	flag := false
-	if room == nil {
+	if room == nil || !me.isInRoom(room) {
 
 		dbRoom, err := me.wsServer.roomRepository.FindRoomByName(message.Target.GetName())
 		if err != nil {
-			me.notifyInfo(nil, message.Sender.(I_User), JoinRoomPrivateAction+", can not find room", "error", message.Time)
+			me.notifyInfo(nil, message.Sender.(I_User), GetMessages+", can not find room", "error", message.Time)
 			return
 		}
 
+		if dbRoom.GetPrivate() || dbRoom.GetGroup() {
+			ok, _ := me.wsServer.roomRepository.CheckMemberExist(&roommember.Member{RoomID: dbRoom.GetId(), UserID: me.GetUID()})
+			if !ok {
+				me.notifyInfo(nil, me, GetMessages+", you are not a member of this room", "error", message.Time)
+				return
+			}
+		}
+
 		inputUUID, err := uuid.Parse(dbRoom.UID)
 		if err != nil {
-			me.notifyInfo(nil, message.Sender.(I_User), JoinRoomPrivateAction+", invalid uid", "error", message.Time)
+			me.notifyInfo(nil, message.Sender.(I_User), GetMessages+", invalid uid", "error", message.Time)
 			return
 		}
 
-		room = &Room{Name: dbRoom.Name, ID: inputUUID, Private: dbRoom.Private}
+		room = &Room{Name: dbRoom.Name, ID: inputUUID, Private: dbRoom.Private, Group: dbRoom.GetGroup(), Title: dbRoom.GetTitle()}
 		flag = true
 	}
 
-	if room != nil {
-		parts := strings.Split(message.Message, ",")
+	query := message.Query
+	if query == nil {
+		query = &messageDB.MessageQuery{}
+	}
 
-		page, err := strconv.Atoi(parts[0])
-		if err != nil {
-			utils.Log().Error(err, "invalid page number: %s\n", parts[0])
+	// public rooms have no members, nothing is cleared there
+	query.ClearedUpTo = nil
+	if member, err := me.wsServer.roomRepository.FindMember(room.GetId(), me.GetUID()); err == nil {
+		query.ClearedUpTo = member.ClearedUpTo
+	}
+
+	page, err := me.wsServer.msgRepository.FindMessagesByCursor(room.GetId(), me.GetUID(), query)
+	if err != nil {
+		me.notifyInfo(nil, me, GetMessages+", "+err.Error(), "error", message.Time)
+		return
+	}
+
+	retMsg := Messages{
+		Action:   message.Action,
+		Target:   room,
+		Sender:   me,
+		Messages: page,
+	}
+	if flag {
+		retMsg.Target.ID = uuid.Nil
+	}
+	m, err := jsonrpc2.Notify(message.Action, retMsg)
+	if err != nil {
+		utils.Log().Error(err, "error while create jsonrpc2 notify")
+		return
+	}
+	me.SendMsg(m.Encode())
+}
+
+func (me *Client) handleSendMessageAction(message Message) {
+	roomID := message.Target.GetId()
+	if room := me.wsServer.findRoomByID(roomID); room != nil {
+		utils.Log().V(2).Info(fmt.Sprintf("new msg in room %s", room.GetName()))
+
+		// a member removed from a group or who left it may still know the room id
+		if ok, _ := me.wsServer.roomRepository.CheckMemberExist(&roommember.Member{RoomID: room.GetId(), UserID: me.GetUID()}); !ok {
+			me.notifyInfo(room, me, SendMessageAction+", you are not a member of this room", "error", message.Time)
 			return
 		}
 
-		limit, err := strconv.Atoi(parts[1])
-		if err != nil {
-			utils.Log().Error(err, "invalid limit number: %s\n", parts[1])
+		if message.ReplyTo != "" && !me.checkReplyTo(room, message) {
 			return
 		}
 
-		msgs, err := me.wsServer.msgRepository.FindMessagesByRoom(room.GetId(), page, limit)
-		retMsg := Messages{
-			Action:   message.Action,
-			Target:   room,
-			Sender:   me,
-			Messages: msgs,
+		if len(message.Attachments) > 0 && !me.checkAttachments(room, &message) {
+			return
 		}
-		if flag {
-			retMsg.Target.ID = uuid.Nil
+
+		if message.Type != "" && !me.checkMessageType(room, message) {
+			return
 		}
-		utils.Log().V(2).Info(fmt.Sprintf("notify Room Joined, %s is registered in room %s", me.Name, room.Name))
-		m, err := jsonrpc2.Notify(message.Action, retMsg)
-		if err != nil {
-			utils.Log().Error(err, "error while create jsonrpc2 notify")
+
+		if (len(message.Envelopes) > 0 || message.SenderDevice != "") && !me.checkEnvelopes(room, message) {
+			return
+		}
+
+		if me.wsServer.isBlockedInRoom(room.GetId(), room.Private, me.GetUID()) {
+			me.notifyInfo(room, me, SendMessageAction+", user unavailable", "error", message.Time)
+			return
+		}
+
+		if len(message.ClientId) > maxClientIdSize {
+			me.notifyInfo(room, me, SendMessageAction+", client id is too long", "error", message.Time)
+			return
+		}
+
+		// a message sent again without its delv is not broadcast again, a saved one is acked to the sender
+		if len(message.ClientId) > 0 {
+			saved, err := me.wsServer.msgRepository.FindSentMessage(room.GetId(), me.GetUID(), message.ClientId)
+			if err != nil {
+				me.notifyInfo(room, me, SendMessageAction+", "+err.Error(), "error", message.Time)
+				return
+			}
+			if saved != nil {
+				me.sendDelivered(room, saved)
+				return
+			}
+		}
+
+		me.resolveMentions(room, &message)
+
+		message.Time = time.Now().UTC().Format(time.RFC3339Nano)
+		message.Status = "acc"
+		switch err := me.wsServer.persister.enqueue(room, &message); err {
+		case nil:
+			room.broadcastMessage(&message)
+		case errPersistPending:
+			// its delv comes once the first one is saved
+		default:
+			me.notifyInfo(room, me, SendMessageAction+", "+err.Error(), "error", message.Time)
 		}
-		me.SendMsg(m.Encode())
 	}
 }
 
-func (me *Client) handleSendMessageAction(message Message) {
-	roomID := message.Target.GetId()
-	if room := me.wsServer.findRoomByID(roomID); room != nil {
-		utils.Log().V(2).Info(fmt.Sprintf("new msg in room %s", room.GetName()))
-		message.Status = "acc"
-		room.broadcast <- &message
-		room.writeMsgToDB <- &message
+// sendDelivered sends the delv of a message saved already to this client only
+func (me *Client) sendDelivered(room *Room, saved *messageDB.DelvMessage) {
+	m, err := jsonrpc2.Notify(Delivered, &Messages{
+		Action:   Delivered,
+		Target:   room,
+		Messages: []*messageDB.DelvMessage{saved},
+	})
+	if err != nil {
+		utils.Log().Error(err, "error while create delv notify msg")
+		return
 	}
+
+	me.SendMsg(m.Encode())
 }
 
 func (me *Client) handleJoinRoomMessage(message Message) {
 	//message was a room name
 	roomName := message.Message
-	me.joinRoom(roomName, nil, false, "")
+
+	// private and group rooms are only for their members
+	dbRoom, err := me.wsServer.roomRepository.FindRoomByName(roomName)
+	if err == nil && (dbRoom.GetPrivate() || dbRoom.GetGroup()) {
+		ok, _ := me.wsServer.roomRepository.CheckMemberExist(&roommember.Member{RoomID: dbRoom.GetId(), UserID: me.GetUID()})
+		if !ok {
+			me.notifyInfo(nil, me, JoinRoomAction+", you are not a member of this room", "error", message.Time)
+			return
+		}
+	}
+
+	room := me.joinRoom(roomName, nil, false, "")
+	if room != nil && !room.Private && !room.Group {
+		_ = room.AddMemberID(me.GetUID())
+	}
 }
 
 func (me *Client) handleLeaveRoomMessage(message Message) {
@@ -392,12 +569,11 @@ func (me *Client) handleLeaveRoomMessage(message Message) {
 		return
 	}
 
-	if _, ok := me.rooms[room]; ok {
-		delete(me.rooms, room)
+	if me.removeRoom(room) {
 		utils.Log().V(2).Info(fmt.Sprintf("%s leave room %s", me.Name, room.Name))
 	}
 
-	room.unregister <- me
+	room.leave(me)
 }
 
 // func (me *Client) handleInfoMessage(message Message) {
@@ -452,6 +628,12 @@ func (me *Client) handleJoinRoomPrivateMessage(message Message) {
 
 	roomName := utils.JoinAndSort(me.GetUsername(), targetuser.Username, "-")
 
+	if blocked, err := me.contactService.IsBlocked(me.GetUID(), targetuser.UID); err != nil || blocked {
+		sender := NewSender("", "", message.Message, JoinRoomPrivateAction)
+		me.notifyInfo(nil, sender, JoinRoomPrivateAction+", user unavailable", "error", message.Time)
+		return
+	}
+
 	if targetuser.Contact == nil || targetuser.Contact.Status != contacts.Accepted {
 		utils.Log().Info("Join Room Private but you are not in their contact")
 		sender := NewSender(targetuser.UID, targetuser.Name, targetuser.Username, targetuser.Avatar)
@@ -472,83 +654,106 @@ func (me *Client) handleJoinRoomPrivateMessage(message Message) {
 		return
 	}
 
-	targets := me.wsServer.findClientByID(targetuser.UID)
-
-	//todo next
-	//target := me.wsServer.findUserByID(message.Message)
-
-	if len(targets) == 0 || targets == nil {
+	// the target may be connected to any node, server.online is filled through the broker
+	connected := me.wsServer.findUserByID(targetuser.UID) != nil
+	if !connected {
 		utils.Log().Info(fmt.Sprintf("get request Join Room Private from %s to none, target unavailable", me.GetUsername()))
-		sender := NewSender(targetuser.UID, targetuser.Name, targetuser.Username, targetuser.Avatar)
-
-		room := me.joinRoom(roomName, sender, true, "offline")
-		_ = room.AddMemberID(me.GetUID())
-		_ = room.AddMemberID(targetuser.UID)
-		return
 	}
 
-	var room *Room
-	for i := 0; i < len(targets); i++ {
-		_ = me.joinRoom(roomName, targets[i], true, "online")
-		room = targets[i].joinRoom(roomName, me, true, "online")
+	// an invisible target is shown offline, it is still invited
+	status := "offline"
+	if me.wsServer.isShownOnline(targetuser.UID) {
+		status = "online"
 	}
 
+	sender := NewSender(targetuser.UID, targetuser.Name, targetuser.Username, targetuser.Avatar)
+	room := me.joinRoom(roomName, sender, true, status)
 	if room != nil {
 		_ = room.AddMemberID(me.GetUID())
 		_ = room.AddMemberID(targetuser.UID)
-	}
 
-	//todo next Join room
-	//joinedRoom := me.joinRoom(roomName, target)
-
-	// Invite target user
-	// if joinedRoom != nil {
-	// 	utils.Log().V(2).Info("get request Join Room Private from", me.GetUsername(), "to", target.GetName(), "room id:", joinedRoom.GetId())
-	// 	me.inviteTargetUser(target, joinedRoom)
-	// }
+		// Invite target user on whichever node it is connected
+		if connected {
+			me.inviteTargetUser(targetuser.UID, room)
+		}
+	}
 }
 
 func (me *Client) joinRoom(roomName string, sender I_User, isPrivate bool, msg string) *Room {
-	room := me.wsServer.findRoomByName(roomName)
-	if room == nil {
-		room = me.wsServer.createRoom(roomName, isPrivate)
+	// the room found may stop before the client is registered, it is then run again
+	for attempt := 0; attempt < maxJoinAttempts; attempt++ {
+		room := me.wsServer.findRoomByName(roomName)
 		if room == nil {
-			return nil
+			room = me.wsServer.createRoom(roomName, isPrivate)
+			if room == nil {
+				return nil
+			}
 		}
-	}
 
-	if !me.isInRoom(room) {
-		me.rooms[room] = true
-		room.register <- me
-	}
+		if me.addRoom(room) && !room.join(me) {
+			me.removeRoom(room)
+			continue
+		}
+
+		me.notifyRoomJoined(room, sender, msg)
 
-	me.notifyRoomJoined(room, sender, msg)
+		return room
+	}
 
-	return room
+	utils.Log().Error(nil, fmt.Sprintf("%s can not join room %s", me.GetUsername(), roomName))
+	return nil
 }
 
 func (me *Client) isInRoom(room *Room) bool {
-	if _, ok := me.rooms[room]; ok {
-		return true
+	me.roomsMu.Lock()
+	defer me.roomsMu.Unlock()
+
+	return me.rooms[room]
+}
+
+// addRoom returns false when the client is in the room already, or leaving all its rooms
+func (me *Client) addRoom(room *Room) bool {
+	me.roomsMu.Lock()
+	defer me.roomsMu.Unlock()
+
+	if me.rooms == nil || me.rooms[room] {
+		return false
 	}
+	me.rooms[room] = true
+	return true
+}
+
+// removeRoom returns false when the client is not in the room
+func (me *Client) removeRoom(room *Room) bool {
+	me.roomsMu.Lock()
+	defer me.roomsMu.Unlock()
 
-	return false
+	if !me.rooms[room] {
+		return false
+	}
+	delete(me.rooms, room)
+	return true
 }
 
-//todo next
-// func (me *Client) inviteTargetUser(target I_User, room *Room) {
-// 	utils.Log().V(2).Info(me.Name, "inviteTargetUser", target.GetUsername(), "to room name:", room.GetName(), ",id:", room.GetId())
-// 	inviteMessage := &Message{
-// 		Action:  JoinRoomPrivateAction,
-// 		Message: target.GetUID(),
-// 		Target:  room,
-// 		Sender:  me,
-// 	}
+// takeRooms removes the client from all its rooms and returns them, it joins no room after
+func (me *Client) takeRooms() []*Room {
+	me.roomsMu.Lock()
+	defer me.roomsMu.Unlock()
 
-// 	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, inviteMessage.encode()).Err(); err != nil {
-// 		log.Println(err)
-// 	}
-// }
+	rooms := make([]*Room, 0, len(me.rooms))
+	for room := range me.rooms {
+		rooms = append(rooms, room)
+	}
+	me.rooms = nil
+	return rooms
+}
+
+func (me *Client) inviteTargetUser(targetUID string, room *Room) {
+	utils.Log().V(2).Info(fmt.Sprintf("%s invite %s to room name: %s, id: %s", me.GetUsername(), targetUID, room.GetName(), room.GetId()))
+	inviteMessage := NewPubSubMessage(JoinRoomPrivateAction, targetUID, me)
+	inviteMessage.RoomName = room.GetName()
+	me.wsServer.publish(inviteMessage)
+}
 
 func (me *Client) notifyRoomJoined(room *Room, sender I_User, msg string) {
 	message := Message{
@@ -582,7 +787,10 @@ func (me *Client) notifyInfo(room *Room, sender I_User, msg, status, time string
 }
 
 func (me *Client) SendMsg(msg []byte) {
-	if me.disposed {
+	me.sendMu.RLock()
+	defer me.sendMu.RUnlock()
+
+	if me.disposed.Load() {
 		return
 	}
 
@@ -590,7 +798,18 @@ func (me *Client) SendMsg(msg []byte) {
 	case me.send <- msg:
 		utils.Log().V(2).Info(fmt.Sprintf("send msg"))
 	default:
-		//channel closed
-		utils.Log().Error(nil, "send msg error, chanel closed")
+		// the client does not keep up, it is dropped rather than missing messages
+		utils.Log().Error(nil, fmt.Sprintf("send msg error, queue of %s is full", me.GetUsername()))
+		if conn := me.currentConn(); conn != nil {
+			closeSlowConsumer(conn)
+		}
 	}
 }
+
+// closeSlowConsumer tells the client it is dropped for being too slow and closes the connection,
+// the client then reconnects and syncs
+func closeSlowConsumer(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+	conn.Close()
+}
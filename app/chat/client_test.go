@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"fmt"
+	"pesatu/components/messageDB"
+	"pesatu/components/room"
+	"pesatu/components/roommember"
+	"testing"
+
+	"github.com/juju/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memberRepo knows the members of the rooms by room id
+type memberRepo struct {
+	roommember.I_RoomMember
+	members map[string][]string
+}
+
+func (me *memberRepo) CheckMemberExist(member *roommember.Member) (bool, error) {
+	for _, uid := range me.members[member.RoomID] {
+		if uid == member.UserID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (me *memberRepo) FindRoomByUID(uid string) (*room.Room, error) {
+	if _, ok := me.members[uid]; !ok {
+		return nil, fmt.Errorf("room not found")
+	}
+	return &room.Room{UID: uid}, nil
+}
+
+func (me *memberRepo) FindMember(roomId, userId string) (*roommember.DBMember, error) {
+	if ok, _ := me.CheckMemberExist(&roommember.Member{RoomID: roomId, UserID: userId}); !ok {
+		return nil, fmt.Errorf("member not found")
+	}
+	return &roommember.DBMember{RoomID: roomId, UserID: userId}, nil
+}
+
+func (me *memberRepo) FindMembers(roomId string, page int, limit int) ([]*roommember.DBMember, error) {
+	var members []*roommember.DBMember
+	for _, uid := range me.members[roomId] {
+		members = append(members, &roommember.DBMember{RoomID: roomId, UserID: uid})
+	}
+	return members, nil
+}
+
+// sentRepo knows the delv of the messages saved by client id
+type sentRepo struct {
+	messageDB.I_MessageRepo
+	saved map[string]*messageDB.DelvMessage
+}
+
+func (me *sentRepo) FindSentMessage(roomId, sender, clientId string) (*messageDB.DelvMessage, error) {
+	return me.saved[clientId], nil
+}
+
+func Test_SendMessageNotMember(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	server.persister = newPersister(server)
+	room := NewRoom(server, "group", false)
+	room.Group = true
+	server.rooms[room.GetId()] = room
+
+	client := newTestClient(server)
+	server.roomRepository = &memberRepo{members: map[string][]string{room.GetId(): {"someone"}}}
+
+	client.handleSendMessageAction(Message{Action: SendMessageAction, Message: "hi", Target: room, Sender: client})
+	asserts.Equal(0, len(server.persister.queue))
+	asserts.Contains(string(<-client.send), "you are not a member of this room")
+}
+
+func Test_SendMessageAgain(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	server.persister = newPersister(server)
+	room := server.addRoom(NewRoom(server, "group", false))
+	room.Group = true
+
+	client := newTestClient(server)
+	server.roomRepository = &memberRepo{members: map[string][]string{room.GetId(): {client.GetUID()}}}
+	repo := &sentRepo{saved: map[string]*messageDB.DelvMessage{}}
+	server.msgRepository = repo
+
+	broadcasts := make(chan []byte, 4)
+	_, err := server.broker.Subscribe(roomChannel(room.GetId()), func(payload []byte) { broadcasts <- payload })
+	asserts.Nil(err)
+
+	send := func() {
+		client.handleSendMessageAction(Message{Action: SendMessageAction, Message: "hi", ClientId: "c1", Target: room, Sender: client})
+	}
+
+	// sent again while the first one is queued, it is neither queued nor broadcast twice
+	send()
+	send()
+	asserts.Equal(1, len(server.persister.queue))
+	asserts.Contains(string(<-broadcasts), `"client_id":"c1"`)
+	asserts.Equal(0, len(broadcasts))
+
+	// sent again once saved, only the sender gets the delv
+	server.persister.forget([]*persistItem{<-server.persister.queue})
+	repo.saved["c1"] = &messageDB.DelvMessage{ClientId: "c1", Seq: 7}
+	send()
+	asserts.Equal(0, len(server.persister.queue))
+	asserts.Equal(0, len(broadcasts))
+	delv := string(<-client.send)
+	asserts.Contains(delv, Delivered)
+	asserts.Contains(delv, `"seq":7`)
+}
+
+// clearedRepo gives every member the same history cutoff
+type clearedRepo struct {
+	memberRepo
+	clearedUpTo primitive.ObjectID
+}
+
+func (me *clearedRepo) FindMember(roomId, userId string) (*roommember.DBMember, error) {
+	member, err := me.memberRepo.FindMember(roomId, userId)
+	if err != nil {
+		return nil, err
+	}
+	member.ClearedUpTo = &me.clearedUpTo
+	return member, nil
+}
+
+// historyRepo keeps the last history query
+type historyRepo struct {
+	messageDB.I_MessageRepo
+	query *messageDB.MessageQuery
+}
+
+func (me *historyRepo) FindMessagesByCursor(roomId, userId string, query *messageDB.MessageQuery) (*messageDB.MessagePage, error) {
+	me.query = query
+	return &messageDB.MessagePage{}, nil
+}
+
+func Test_GetMessagesCleared(t *testing.T) {
+	asserts := assert.New(t)
+	server := newTestServer()
+	room := server.addRoom(NewRoom(server, "group", false))
+	client := newTestClient(server)
+	client.addRoom(room)
+
+	members := &clearedRepo{memberRepo: memberRepo{members: map[string][]string{room.GetId(): {client.GetUID()}}}, clearedUpTo: primitive.NewObjectID()}
+	server.roomRepository = members
+	repo := &historyRepo{}
+	server.msgRepository = repo
+
+	// the cutoff is the one of the member, never the one sent by the client
+	sent := primitive.NewObjectID()
+	client.handleGetMessages(Message{Action: GetMessages, Target: room, Sender: client, Query: &messageDB.MessageQuery{ClearedUpTo: &sent}})
+	asserts.Equal(members.clearedUpTo, *repo.query.ClearedUpTo)
+
+	// nothing is cleared for who is not a member
+	members.members[room.GetId()] = nil
+	client.handleGetMessages(Message{Action: GetMessages, Target: room, Sender: client, Query: &messageDB.MessageQuery{ClearedUpTo: &sent}})
+	asserts.Nil(repo.query.ClearedUpTo)
+}
+
+// dispatchRepo keeps one message and records the handlers run on it
+type dispatchRepo struct {
+	messageDB.I_MessageRepo
+	memberRepo
+	msg   *messageDB.DBMessage
+	calls []string
+}
+
+func (me *dispatchRepo) FindMessageById(msgId string) (*messageDB.DBMessage, error) {
+	if msgId != me.msg.Id.Hex() {
+		return nil, fmt.Errorf("message not found")
+	}
+	return me.msg, nil
+}
+
+func (me *dispatchRepo) AddReaction(msg *messageDB.DBMessage, userId, emoji string) error {
+	me.calls = append(me.calls, ReactAction)
+	return nil
+}
+
+func (me *dispatchRepo) RemoveReaction(msgId primitive.ObjectID, userId, emoji string) error {
+	me.calls = append(me.calls, UnreactAction)
+	return nil
+}
+
+func (me *dispatchRepo) PinMessage(msg *messageDB.DBMessage, userId string, max int) error {
+	me.calls = append(me.calls, PinAction)
+	return nil
+}
+
+func (me *dispatchRepo) UnpinMessage(msg *messageDB.DBMessage) error {
+	me.calls = append(me.calls, UnpinAction)
+	return nil
+}
+
+// MoveReceiptMark records which mark a receipt moves, and stops it there
+func (me *dispatchRepo) MoveReceiptMark(roomId, userId string, msgId primitive.ObjectID, read bool) (*roommember.DBMember, error) {
+	if read {
+		me.calls = append(me.calls, HasBeenRead)
+	} else {
+		me.calls = append(me.calls, ReceivedAction)
+	}
+	return nil, fmt.Errorf("stop")
+}
+
+func Test_DispatchFromMethod(t *testing.T) {
+	asserts := assert.New(t)
+
+	// the handler and what it does come from the rpc method, whatever the action in params says
+	tests := []struct {
+		method string
+		action string
+	}{
+		{ReactAction, UnreactAction},
+		{UnreactAction, ReactAction},
+		{PinAction, UnpinAction},
+		{UnpinAction, PinAction},
+		{ReceivedAction, HasBeenRead},
+		{HasBeenRead, ReceivedAction},
+		{TypingStartAction, SendMessageAction},
+		{RecordingAudioAction, TypingStopAction},
+	}
+
+	for _, test := range tests {
+		server := newTestServer()
+		// not run, the test reads the signals of the room itself
+		room := NewRoom(server, "a-b", true)
+		server.rooms[room.GetId()] = room
+		client := newTestClient(server)
+		client.signalLimiter = ratelimit.NewBucketWithRate(signalRate, signalBurst)
+		client.addRoom(room)
+
+		repo := &dispatchRepo{
+			msg:        &messageDB.DBMessage{Id: primitive.NewObjectID(), RoomId: room.GetId()},
+			memberRepo: memberRepo{members: map[string][]string{room.GetId(): {client.GetUID()}}},
+		}
+		server.msgRepository = repo
+		server.roomRepository = repo
+		server.msgController = messageDB.NewMessageController(repo, repo)
+
+		client.handleNewMessage([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"%s","params":{"id":"%s","action":"%s","message":"👍","target":{"id":"%s"}}}`,
+			test.method, repo.msg.Id.Hex(), test.action, room.GetId())))
+
+		select {
+		case signal := <-room.signal:
+			repo.calls = append(repo.calls, signal.Action)
+		default:
+		}
+
+		asserts.Equal([]string{test.method}, repo.calls, test.method)
+	}
+}
@@ -2,13 +2,19 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"pesatu/components/attachment"
 	"pesatu/components/contacts"
+	"pesatu/components/eventlog"
 	"pesatu/components/messageDB"
+	"pesatu/components/notification"
+	"pesatu/components/presence"
 	roommodel "pesatu/components/room"
 	room "pesatu/components/roommember"
-	"pesatu/jsonrpc2"
 	"pesatu/utils"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -17,60 +23,154 @@ import (
 )
 
 type WsServer struct {
-	users          []I_User
-	clients        map[*Client]bool
-	register       chan *Client
-	unregister     chan *Client
-	broadcast      chan []byte
-	rooms          map[*Room]bool
-	roomRepository room.I_RoomMember
-	msgRepository  messageDB.I_MessageRepo
-	ionsfu         *sfu.SFU
+	register           chan *Client
+	unregister         chan *Client
+	broadcast          chan []byte
+	roomRepository     room.I_RoomMember
+	msgRepository      messageDB.I_MessageRepo
+	msgController      messageDB.MessageController
+	attachments        attachment.AttachmentController
+	notifRepository    notification.I_NotifRepo
+	eventRepository    eventlog.I_EventRepo
+	presenceRepository presence.I_PresenceRepo
+	contactRepository  contacts.I_ContactRepo
+	ionsfu             *sfu.SFU
+	broker             Broker
+	pubsub             chan *PubSubMessage
+	persister          *persister
+
+	// local clients by uid, and running rooms by id and by name
+	clients   map[string]map[*Client]bool
+	clientsMu sync.RWMutex
+	rooms     map[string]*Room
+	roomNames map[string]*Room
+	roomsMu   sync.RWMutex
+
+	// id of this node, users online on every node by uid, read by the presence rpc too,
+	// and the last heartbeat of every node
+	node     string
+	online   map[string]*onlineUser
+	nodes    map[string]time.Time
+	onlineMu sync.RWMutex
+
+	// clients of the session protocol by session id, including the ones waiting for a resume
+	sessions   map[string]*Client
+	sessionsMu sync.Mutex
 }
 
-// NewWebsocketServer creates a new WsServer type
-func NewWebsocketServer(mongoclient *mongo.Client, ctx context.Context, s *sfu.SFU) *WsServer {
+// NewWebsocketServer creates a new WsServer type,
+// broker may be nil when this is the only node, an in-memory broker is used then
+func NewWebsocketServer(mongoclient *mongo.Client, ctx context.Context, s *sfu.SFU, broker Broker) *WsServer {
 	collectionRoom := mongoclient.Database("pesatu").Collection("rooms")
 	memberCollection := mongoclient.Database("pesatu").Collection("roommembers")
 
 	userCollection := mongoclient.Database("pesatu").Collection("users")
 	msgCollection := mongoclient.Database("pesatu").Collection("messages")
+	presenceCollection := mongoclient.Database("pesatu").Collection("presence")
+	notifCollection := mongoclient.Database("pesatu").Collection("notifications")
+	eventCollection := mongoclient.Database("pesatu").Collection("events")
+
+	if broker == nil {
+		broker = NewMemoryBroker()
+	}
+
+	roomRepository := room.NewRoomMemberService(collectionRoom, memberCollection, ctx)
+	msgRepository := messageDB.NewMsgRepository(userCollection, msgCollection, ctx)
+	if err := msgRepository.CreateIndexes(); err != nil {
+		utils.Log().Error(err, "error while creating message indexes")
+	}
+
+	eventRepository := eventlog.NewEventRepository(eventCollection, ctx)
+	if err := eventRepository.CreateIndexes(); err != nil {
+		utils.Log().Error(err, "error while creating event indexes")
+	}
+
+	attachmentBucket, err := attachment.NewAttachmentBucket(mongoclient)
+	if err != nil {
+		utils.Log().Error(err, "error creating attachments GridFS bucket")
+	}
+	attachmentRepository := attachment.NewAttachmentService(attachmentBucket, ctx)
 
 	wsServer := &WsServer{
-		clients:        make(map[*Client]bool),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		broadcast:      make(chan []byte),
-		rooms:          make(map[*Room]bool),
-		roomRepository: room.NewRoomMemberService(collectionRoom, memberCollection, ctx),
-		msgRepository:  messageDB.NewMsgRepository(userCollection, msgCollection, ctx),
-		ionsfu:         s,
+		register:           make(chan *Client),
+		unregister:         make(chan *Client),
+		broadcast:          make(chan []byte),
+		clients:            make(map[string]map[*Client]bool),
+		rooms:              make(map[string]*Room),
+		roomNames:          make(map[string]*Room),
+		roomRepository:     roomRepository,
+		msgRepository:      msgRepository,
+		msgController:      messageDB.NewMessageController(msgRepository, roomRepository),
+		attachments:        attachment.NewAttachmentController(attachmentRepository, roomRepository),
+		notifRepository:    notification.NewNotifRepository(notifCollection, ctx),
+		eventRepository:    eventRepository,
+		presenceRepository: presence.NewPresenceService(presenceCollection, ctx),
+		ionsfu:             s,
+		broker:             broker,
+		pubsub:             make(chan *PubSubMessage, 256),
+		node:               uuid.New().String(),
+		online:             make(map[string]*onlineUser),
+		nodes:              make(map[string]time.Time),
+		sessions:           make(map[string]*Client),
 	}
+	wsServer.persister = newPersister(wsServer)
 
-	// Add users from database to server
-	//wsServer.users = userRepository.GetAllUsers()
 	return wsServer
 }
 
 func (server *WsServer) InitRouteTo(rg *gin.RouterGroup, contactRepo contacts.I_ContactRepo, allowOrigins []string) {
+	server.contactRepository = contactRepo
 	rg.GET("/ws", func(c *gin.Context) {
 		ServeWs(server, c, contactRepo, allowOrigins)
 	})
+	rg.GET("/ws/metrics", server.serveMetrics)
 }
 
 // Run our websocket server, accepting various requests
 func (server *WsServer) Run() {
-	//todo next
-	//go server.listenPubSubChannel()
+	server.listenPubSubChannel()
+	go server.persister.run()
+	go server.sweepExpiredLoop()
+	go server.compactEventsLoop()
+
+	// the other nodes answer the first snapshot of this node with theirs
+	server.publishPresence()
+	heartbeat := time.NewTicker(presenceHeartbeat)
+	defer heartbeat.Stop()
+
 	for {
 		select {
 
+		case <-heartbeat.C:
+			server.publishPresence()
+			server.expireNodes()
+
 		case client := <-server.register:
 			server.registerClient(client)
 
 		case client := <-server.unregister:
 			server.unregisterClient(client)
 
+		case message := <-server.pubsub:
+			switch message.Action {
+			case UserJoinedAction:
+				server.handleUserJoined(message)
+			case UserLeftAction:
+				server.handleUserLeft(message)
+			case PresenceAction:
+				server.handlePresenceChanged(message)
+			case PresenceSyncAction:
+				server.handlePresenceSync(message)
+			case JoinRoomPrivateAction:
+				server.handleUserJoinPrivate(message)
+			case room.GroupCreated, room.MemberAdded:
+				server.handleMemberJoinGroup(message)
+			case MentionAction:
+				server.handleMention(message)
+			case ContactAction:
+				server.handleContactEvent(message)
+			}
+
 			// case message := <-server.broadcast:
 			// 	server.broadcastToClients(message)
 		}
@@ -80,197 +180,138 @@ func (server *WsServer) Run() {
 
 // add new client connection
 func (server *WsServer) registerClient(client *Client) {
-	server.notifyClientJoined(client)
-
-	//todo Publish user in PubSub
-	//server.publishClientJoined(client)
+	// Publish user in PubSub, every node (this one too) will notify the contacts
+	server.publishClientJoined(client, server.findPresenceMode(client.GetUID()))
 
 	server.listOnlineClients(client)
-	server.clients[client] = true
+	count := server.addClient(client)
 
 	utils.Log().V(2).Info(fmt.Sprintf("registered %s id: %s", client.GetUsername(), client.GetUID()))
-	utils.Log().V(2).Info(fmt.Sprintf("client counts %d", len(server.clients)))
+	utils.Log().V(2).Info(fmt.Sprintf("users counts %d", count))
 }
 
 // remove client connection
 func (server *WsServer) unregisterClient(client *Client) {
-	if _, ok := server.clients[client]; ok {
+	if server.removeClient(client) {
 
-		server.notifyClientLeft(client)
+		// Publish user left in PubSub
+		server.publishClientLeft(client)
 
-		//todo: Publish user left in PubSub
-		//server.publishClientLeft(client)
+		err := server.presenceRepository.SetLastSeen(client.GetUID(), time.Now())
+		if err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while saving last seen of %s", client.GetUsername()))
+		}
 
 		utils.Log().V(2).Info(fmt.Sprintf("del connection %s @%s", client.Name, client.conn.RemoteAddr().String()))
-		client.disposed = true
 		client.wg.Wait()
-		delete(server.clients, client)
 		// Remove user from repo
 		// server.userRepository.RemoveUser(client)
 
 	}
 }
 
-//todo next:
-// func (server *WsServer) publishClientJoined(client *Client) {
-// 	message := &Message{
-// 		Action: UserJoinedAction,
-// 		Sender: client,
-// 	}
-// 	log.Println("publishClientJoined", client.Name)
-// 	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, message.encode()).Err(); err != nil {
-// 		log.Println(err)
-// 	}
-// }
-
-func (server *WsServer) notifyClientJoined(client *Client) {
-	message := &Message{
-		Action: UserJoinedAction,
-		Sender: client,
+// addClient returns the count of users with local clients
+func (server *WsServer) addClient(client *Client) int {
+	server.clientsMu.Lock()
+	defer server.clientsMu.Unlock()
+
+	if server.clients[client.GetUID()] == nil {
+		server.clients[client.GetUID()] = make(map[*Client]bool)
 	}
-	utils.Log().V(2).Info(fmt.Sprintf("notify User Joined %s to", client.GetUsername()))
-	server.broadcastToClientsInRoom(client, message)
+	server.clients[client.GetUID()][client] = true
+	return len(server.clients)
 }
 
-//todo next
-// func (server *WsServer) publishClientLeft(client *Client) {
-
-// 	message := &Message{
-// 		Action: UserLeftAction,
-// 		Sender: client,
-// 	}
+func (server *WsServer) removeClient(client *Client) bool {
+	server.clientsMu.Lock()
+	defer server.clientsMu.Unlock()
 
-// 	log.Println("publishClientLeft", client.Name)
-// 	if err := config.Redis.Publish(ctx, PubSubGeneralChannel, message.encode()).Err(); err != nil {
-// 		log.Println(err)
-// 	}
-// }
+	clients := server.clients[client.GetUID()]
+	if !clients[client] {
+		return false
+	}
 
-func (server *WsServer) notifyClientLeft(client *Client) {
-	message := &Message{
-		Action: UserLeftAction,
-		Sender: client,
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(server.clients, client.GetUID())
 	}
+	return true
+}
 
-	utils.Log().V(2).Info(fmt.Sprintf("notify User Left %s to", client.Name))
-	server.broadcastToClientsInRoom(client, message)
+func (server *WsServer) hasLocalClients() bool {
+	server.clientsMu.RLock()
+	defer server.clientsMu.RUnlock()
+
+	return len(server.clients) > 0
 }
 
-//todo next
-// func (server *WsServer) listenPubSubChannel() {
-
-// 	pubsub := config.Redis.Subscribe(ctx, PubSubGeneralChannel)
-
-// 	ch := pubsub.Channel()
-
-// 	for msg := range ch {
-
-// 		var message Message
-// 		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
-// 			log.Printf("Error on unmarshal JSON message %s", err)
-// 			return
-// 		}
-
-// 		switch message.Action {
-// 		case UserJoinedAction:
-// 			server.handleUserJoined(message)
-// 		case UserLeftAction:
-// 			server.handleUserLeft(message)
-// 		case JoinRoomPrivateAction:
-// 			server.handleUserJoinPrivate(message)
-// 		}
-
-// 	}
-// }
-
-// func (server *WsServer) handleUserJoined(message Message) {
-// 	// Add the user to the slice
-// 	server.users = append(server.users, message.Sender.(I_User))
-// 	server.broadcastToClients(message.encode())
-// }
-
-// func (server *WsServer) handleUserLeft(message Message) {
-// 	// Remove the user from the slice
-// 	for i, user := range server.users {
-// 		if user.GetUID() == message.Sender.(I_User).GetUID() {
-// 			server.users[i] = server.users[len(server.users)-1]
-// 			server.users = server.users[:len(server.users)-1]
-// 			break // added this break to only remove the first occurrence
-// 		}
-// 	}
-
-// 	server.broadcastToClients(message.encode())
-// }
-
-// func (server *WsServer) handleUserJoinPrivate(message Message) {
-// 	// Find client for given user, if found add the user to the room.
-// 	targetClients := server.findClientByID(message.Message)
-// 	// if targetClient != nil {
-// 	// 	targetClient.joinRoom(message.Target.GetName(), message.Sender)
-// 	// }
-// 	for _, targetClient := range targetClients {
-// 		_ = targetClient.joinRoom(message.Target.GetName(), message.Sender.(I_User), true)
-// 	}
-// }
-
-func (server *WsServer) listOnlineClients(client *Client) {
-	var uniqueUsers = make(map[string]bool)
-	for _, user := range server.users {
-		if ok := uniqueUsers[user.GetUID()]; !ok {
-			message := &Message{
-				Action: UserJoinedAction,
-				Sender: user,
-			}
-			utils.Log().V(2).Info(fmt.Sprintf("Tell %s existing User Joined %s id:%s", client.Name, user.GetUsername(), user.GetUID()))
-			uniqueUsers[user.GetUID()] = true
-			// client.send <- message.encode()
-			client.SendMsg(message.encode())
+// localClients returns the clients connected to this node
+func (server *WsServer) localClients() []*Client {
+	server.clientsMu.RLock()
+	defer server.clientsMu.RUnlock()
+
+	var clients []*Client
+	for _, userClients := range server.clients {
+		for client := range userClients {
+			clients = append(clients, client)
 		}
 	}
+	return clients
 }
 
-func (server *WsServer) broadcastToClients(message []byte) {
-	for client := range server.clients {
-		utils.Log().V(2).Info(fmt.Sprintf("\tBroadcast []byte :%s @ %s", client.Name, client.conn.RemoteAddr().String()))
-		// client.send <- message
-		client.SendMsg(message)
+func (server *WsServer) publish(message *PubSubMessage) {
+	message.Node = server.node
+	if err := server.broker.Publish(PubSubGeneralChannel, message.encode()); err != nil {
+		utils.Log().Error(err, fmt.Sprintf("error while publishing %s", message.Action))
 	}
 }
 
-func (server *WsServer) broadcastToClientsInRoom(client *Client, message *Message) {
-	m, err := jsonrpc2.Notify(message.Action, message)
+func (server *WsServer) publishClientJoined(client *Client, mode string) {
+	utils.Log().V(2).Info(fmt.Sprintf("publish Client Joined %s", client.GetUsername()))
+	server.publish(NewPubSubMessage(UserJoinedAction, mode, client))
+}
+
+func (server *WsServer) publishClientLeft(client *Client) {
+	utils.Log().V(2).Info(fmt.Sprintf("publish Client Left %s", client.GetUsername()))
+	server.publish(NewPubSubMessage(UserLeftAction, "", client))
+}
+
+// listenPubSubChannel forwards general channel events into the Run loop
+func (server *WsServer) listenPubSubChannel() {
+	_, err := server.broker.Subscribe(PubSubGeneralChannel, func(payload []byte) {
+		var message PubSubMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			utils.Log().Error(err, "error on unmarshal pubsub message")
+			return
+		}
+		server.pubsub <- &message
+	})
+
 	if err != nil {
-		utils.Log().Error(err, "error while broadcast To Clients In Room")
-		return
+		utils.Log().Error(err, "error while subscribing to general channel")
 	}
+}
 
-	page := 1
-	var rooms []*roommodel.Room
-	for page == 1 || len(rooms) == 10 {
-		rooms, err = server.roomRepository.FindRoomByMemberID(client.GetUID(), page, 10)
-		page = page + 1
-		if err != nil {
-			utils.Log().Error(err, "error while finding rooms by user uid")
-		}
-		for room := range server.rooms {
-			for r := range rooms {
-				if room.GetId() != rooms[r].GetId() {
-					continue
-				}
-				room.broadcastToClientsInRoom(m.Encode())
-			}
-		}
+func (server *WsServer) handleUserJoinPrivate(message *PubSubMessage) {
+	// Find client for given user, if found add the user to the room.
+	targetClients := server.findClientByID(message.Message)
+	for _, targetClient := range targetClients {
+		_ = targetClient.joinRoom(message.RoomName, message.GetSender(), true, "online")
 	}
 }
 
-func (server *WsServer) findRoomByName(name string) *Room {
-	var foundRoom *Room
-	for room := range server.rooms {
-		if room.GetName() == name {
-			foundRoom = room
-			break
-		}
+func (server *WsServer) broadcastToClients(message []byte) {
+	for _, client := range server.localClients() {
+		utils.Log().V(2).Info(fmt.Sprintf("\tBroadcast []byte :%s @ %s", client.Name, client.conn.RemoteAddr().String()))
+		// client.send <- message
+		client.SendMsg(message)
 	}
+}
+
+func (server *WsServer) findRoomByName(name string) *Room {
+	server.roomsMu.RLock()
+	foundRoom := server.roomNames[name]
+	server.roomsMu.RUnlock()
 
 	// NEW: if there is no room, try to create it from the repo
 	if foundRoom == nil {
@@ -288,24 +329,47 @@ func (server *WsServer) runRoomFromRepository(name string) *Room {
 	if dbRoom != nil {
 		room = NewRoom(server, dbRoom.GetName(), dbRoom.GetPrivate())
 		room.ID, _ = uuid.Parse(dbRoom.GetId())
+		room.Group = dbRoom.GetGroup()
+		room.Title = dbRoom.GetTitle()
+		room = server.addRoom(room)
+	}
+
+	return room
+}
 
-		go room.RunRoom()
-		server.rooms[room] = true
+// addRoom runs the room unless one of the same name runs already, it returns the running one
+func (server *WsServer) addRoom(room *Room) *Room {
+	server.roomsMu.Lock()
+	if running := server.roomNames[room.GetName()]; running != nil {
+		server.roomsMu.Unlock()
+		return running
 	}
+	server.rooms[room.GetId()] = room
+	server.roomNames[room.GetName()] = room
+	server.roomsMu.Unlock()
 
+	go room.RunRoom()
 	return room
 }
 
-func (server *WsServer) findRoomByID(ID string) *Room {
-	var foundRoom *Room
-	for room := range server.rooms {
-		if room.GetId() == ID {
-			foundRoom = room
-			break
-		}
+// removeRoom is called by a stopping room, a room run again since then is kept
+func (server *WsServer) removeRoom(room *Room) {
+	server.roomsMu.Lock()
+	defer server.roomsMu.Unlock()
+
+	if server.rooms[room.GetId()] == room {
+		delete(server.rooms, room.GetId())
+	}
+	if server.roomNames[room.GetName()] == room {
+		delete(server.roomNames, room.GetName())
 	}
+}
 
-	return foundRoom
+func (server *WsServer) findRoomByID(ID string) *Room {
+	server.roomsMu.RLock()
+	defer server.roomsMu.RUnlock()
+
+	return server.rooms[ID]
 }
 
 func (server *WsServer) createRoom(name string, private bool) *Room {
@@ -322,31 +386,30 @@ func (server *WsServer) createRoom(name string, private bool) *Room {
 		return nil
 	}
 
-	go newRoom.RunRoom()
-	server.rooms[newRoom] = true
+	newRoom = server.addRoom(newRoom)
 	utils.Log().V(2).Info(fmt.Sprintf("room %s is created, id: %s", name, newRoom.GetId()))
 
 	return newRoom
 }
 
 func (server *WsServer) findUserByID(ID string) I_User {
-	var foundUser I_User
-	for _, client := range server.users {
-		if client.GetUID() == ID {
-			foundUser = client
-			break
-		}
+	server.onlineMu.RLock()
+	defer server.onlineMu.RUnlock()
+
+	if o, ok := server.online[ID]; ok {
+		return o.user
 	}
 
-	return foundUser
+	return nil
 }
 
 func (server *WsServer) findClientByID(ID string) []*Client {
+	server.clientsMu.RLock()
+	defer server.clientsMu.RUnlock()
+
 	var foundClients []*Client
-	for client := range server.clients {
-		if client.GetUID() == ID {
-			foundClients = append(foundClients, client)
-		}
+	for client := range server.clients[ID] {
+		foundClients = append(foundClients, client)
 	}
 
 	return foundClients
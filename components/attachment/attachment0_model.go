@@ -0,0 +1,49 @@
+package attachment
+
+import (
+	"pesatu/components/messageDB"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Voice is the kind of an upload recorded as a voice note
+const Voice = "voice"
+
+// AttachmentMetadata is kept with the file in GridFS
+type AttachmentMetadata struct {
+	Owner    string `json:"owner" bson:"owner"`
+	RoomId   string `json:"room_id" bson:"room_id"`
+	Name     string `json:"name" bson:"name"`
+	MimeType string `json:"mime_type" bson:"mime_type"`
+	Width    int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height   int    `json:"height,omitempty" bson:"height,omitempty"`
+	Kind     string `json:"kind,omitempty" bson:"kind,omitempty"`
+	Duration int64  `json:"duration_ms,omitempty" bson:"duration_ms,omitempty"`
+	Waveform []int  `json:"waveform,omitempty" bson:"waveform,omitempty"`
+	// rooms the file was forwarded to, their members may download it too
+	SharedRooms []string `json:"shared_rooms,omitempty" bson:"shared_rooms,omitempty"`
+}
+
+// DBAttachment is a file of the attachments bucket
+type DBAttachment struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	Length     int64              `json:"size" bson:"length"`
+	UploadDate time.Time          `json:"upload_date" bson:"uploadDate"`
+	Metadata   AttachmentMetadata `json:"metadata" bson:"metadata"`
+}
+
+// ToAttachment returns what a message keeps about the file
+func (me *DBAttachment) ToAttachment() *messageDB.Attachment {
+	return &messageDB.Attachment{
+		Id:       me.Id.Hex(),
+		Name:     me.Metadata.Name,
+		Size:     me.Length,
+		MimeType: me.Metadata.MimeType,
+		Width:    me.Metadata.Width,
+		Height:   me.Metadata.Height,
+		Kind:     me.Metadata.Kind,
+		Duration: me.Metadata.Duration,
+		Waveform: me.Metadata.Waveform,
+	}
+}
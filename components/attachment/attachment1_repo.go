@@ -0,0 +1,197 @@
+package attachment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"pesatu/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type I_AttachmentRepo interface {
+	SaveAttachment(src io.Reader, metadata *AttachmentMetadata) (*DBAttachment, error)
+	FindAttachment(id string) (*DBAttachment, error)
+	FindAttachments(ids []string) ([]*DBAttachment, error)
+	OpenAttachment(attachment *DBAttachment) io.ReadSeekCloser
+	DeleteAttachment(id string) error
+	ShareAttachments(ids []string, roomId string) error
+}
+
+type AttachmentService struct {
+	gridfsBucket *gridfs.Bucket
+	ctx          context.Context
+}
+
+func NewAttachmentService(gridfsBucket *gridfs.Bucket, ctx context.Context) I_AttachmentRepo {
+	return &AttachmentService{gridfsBucket, ctx}
+}
+
+func (me *AttachmentService) SaveAttachment(src io.Reader, metadata *AttachmentMetadata) (*DBAttachment, error) {
+	doc, err := utils.ToDoc(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error creating metadata: %s", err.Error())
+	}
+
+	fileID := primitive.NewObjectID()
+	uploadStream, err := me.gridfsBucket.OpenUploadStreamWithID(fileID, fileID.Hex(), options.GridFSUpload().SetMetadata(doc))
+	if err != nil {
+		return nil, fmt.Errorf("error saving attachment to GridFS bucket: %s", err.Error())
+	}
+
+	_, err = io.Copy(uploadStream, src)
+	if err != nil {
+		uploadStream.Abort()
+		return nil, fmt.Errorf("error copying attachment to GridFS bucket: %s", err.Error())
+	}
+
+	// the file is only listed once the stream is closed
+	if err := uploadStream.Close(); err != nil {
+		return nil, fmt.Errorf("error saving attachment to GridFS bucket: %s", err.Error())
+	}
+
+	return me.FindAttachment(fileID.Hex())
+}
+
+func (me *AttachmentService) FindAttachment(id string) (*DBAttachment, error) {
+	attachments, err := me.FindAttachments([]string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attachments) == 0 {
+		return nil, fmt.Errorf("attachment unavailable")
+	}
+
+	return attachments[0], nil
+}
+
+// FindAttachments returns the attachments found, the ones missing are left out
+func (me *AttachmentService) FindAttachments(ids []string) ([]*DBAttachment, error) {
+	var objectIDs []primitive.ObjectID
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attachment id: %s", id)
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	cursor, err := me.gridfsBucket.FindContext(me.ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var attachments []*DBAttachment
+	if err := cursor.All(me.ctx, &attachments); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+// OpenAttachment returns a reader of the file which can seek, for http range requests
+func (me *AttachmentService) OpenAttachment(attachment *DBAttachment) io.ReadSeekCloser {
+	return &seekableStream{bucket: me.gridfsBucket, id: attachment.Id, size: attachment.Length}
+}
+
+// DeleteAttachment removes the file and its chunks, a file already gone is not an error
+func (me *AttachmentService) DeleteAttachment(id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid attachment id")
+	}
+
+	if err := me.gridfsBucket.Delete(objectID); err != nil && err != gridfs.ErrFileNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// ShareAttachments lets the members of roomId download the files, the files are not copied
+func (me *AttachmentService) ShareAttachments(ids []string, roomId string) error {
+	var objectIDs []primitive.ObjectID
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return fmt.Errorf("invalid attachment id: %s", id)
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": objectIDs}, "metadata.room_id": bson.M{"$ne": roomId}}
+	update := bson.M{"$addToSet": bson.M{"metadata.shared_rooms": roomId}}
+	if _, err := me.gridfsBucket.GetFilesCollection().UpdateMany(me.ctx, filter, update); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// seekableStream opens the GridFS download stream on the first read after a seek,
+// it skips the chunks before the position so only the requested range is fetched
+type seekableStream struct {
+	bucket *gridfs.Bucket
+	id     primitive.ObjectID
+	size   int64
+	pos    int64
+	stream *gridfs.DownloadStream
+}
+
+func (me *seekableStream) Read(p []byte) (int, error) {
+	if me.pos >= me.size {
+		return 0, io.EOF
+	}
+
+	if me.stream == nil {
+		stream, err := me.bucket.OpenDownloadStream(me.id)
+		if err != nil {
+			return 0, fmt.Errorf("attachment not found: %s", err.Error())
+		}
+		if _, err := stream.Skip(me.pos); err != nil {
+			stream.Close()
+			return 0, err
+		}
+		me.stream = stream
+	}
+
+	n, err := me.stream.Read(p)
+	me.pos += int64(n)
+	return n, err
+}
+
+func (me *seekableStream) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += me.pos
+	case io.SeekEnd:
+		pos += me.size
+	}
+
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+
+	if pos != me.pos {
+		me.Close()
+		me.pos = pos
+	}
+
+	return pos, nil
+}
+
+func (me *seekableStream) Close() error {
+	if me.stream == nil {
+		return nil
+	}
+
+	err := me.stream.Close()
+	me.stream = nil
+	return err
+}
@@ -0,0 +1,340 @@
+package attachment
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"pesatu/auth"
+	"pesatu/components/roommember"
+	"pesatu/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxAttachments of one message
+const MaxAttachments = 10
+
+// max runes of a file name, longer names are cut keeping the extension
+const maxNameLength = 128
+
+var (
+	maxSize      int64 = 10 * 1024 * 1024 // 10MB
+	allowedTypes       = []string{"image/", "video/", "audio/", "application/pdf", "text/plain", "application/zip"}
+)
+
+// SetConfig sets the max size in bytes and the allowed mime types of an upload,
+// a type ending with / allows every subtype
+func SetConfig(size int64, types []string) {
+	if size > 0 {
+		maxSize = size
+	}
+
+	if len(types) > 0 {
+		allowedTypes = types
+	}
+}
+
+func isAllowedType(mimeType string) bool {
+	for _, t := range allowedTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mimeType, t) || mimeType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInline tells whether the browser may show the file instead of saving it
+func isInline(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml" ||
+		strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/")
+}
+
+func cleanName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	runes := []rune(name)
+	if len(runes) > maxNameLength {
+		ext := []rune(filepath.Ext(name))
+		if len(ext) >= maxNameLength {
+			ext = nil
+		}
+		name = string(runes[:maxNameLength-len(ext)]) + string(ext)
+	}
+
+	return name
+}
+
+type AttachmentController struct {
+	service       I_AttachmentRepo
+	memberService roommember.I_RoomMember
+}
+
+func NewAttachmentController(service I_AttachmentRepo, memberService roommember.I_RoomMember) AttachmentController {
+	return AttachmentController{service, memberService}
+}
+
+func (me *AttachmentController) isMember(roomId, uid string) bool {
+	ok, _ := me.memberService.CheckMemberExist(&roommember.Member{RoomID: roomId, UserID: uid})
+	return ok
+}
+
+// canDownload tells whether uid is a member of the room of the file or of a room it was forwarded to
+func (me *AttachmentController) canDownload(attachment *DBAttachment, uid string) bool {
+	if me.isMember(attachment.Metadata.RoomId, uid) {
+		return true
+	}
+
+	for _, roomId := range attachment.Metadata.SharedRooms {
+		if me.isMember(roomId, uid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UploadAttachment saves a file for the room, the mime type is sniffed from the content
+// when the client does not send it, images get their dimensions and voice notes their duration and waveform
+func (me *AttachmentController) UploadAttachment(owner, roomId, kind string, file *multipart.FileHeader) (*DBAttachment, error) {
+	if file.Size > maxSize {
+		return nil, fmt.Errorf("file size exceeds maximum allowed, max %d bytes", maxSize)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s", err.Error())
+	}
+	defer src.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("error reading file: %s", err.Error())
+	}
+	head = head[:n]
+
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(file.Header.Get("Content-Type"), ";")[0]))
+	if len(mimeType) == 0 || mimeType == "application/octet-stream" {
+		mimeType = strings.Split(http.DetectContentType(head), ";")[0]
+	}
+
+	metadata := &AttachmentMetadata{
+		Owner:    owner,
+		RoomId:   roomId,
+		Name:     cleanName(file.Filename),
+		MimeType: mimeType,
+	}
+
+	switch kind {
+	case Voice:
+		if err := me.readVoice(file, metadata); err != nil {
+			return nil, err
+		}
+	case "":
+		if !isAllowedType(mimeType) || utils.ValidateLinkOrJS(mimeType) {
+			return nil, fmt.Errorf("file type %s is not allowed", mimeType)
+		}
+	default:
+		return nil, fmt.Errorf("unknown kind %s", kind)
+	}
+
+	content := io.MultiReader(bytes.NewReader(head), src)
+	if strings.HasPrefix(mimeType, "image/") {
+		// the header of the image is read again when saving
+		var buf bytes.Buffer
+		config, _, err := image.DecodeConfig(io.TeeReader(content, &buf))
+		if err == nil {
+			metadata.Width = config.Width
+			metadata.Height = config.Height
+		}
+		content = io.MultiReader(&buf, content)
+	}
+
+	return me.service.SaveAttachment(content, metadata)
+}
+
+// readVoice fills the duration and waveform of a voice note, whatever the allowed types are
+func (me *AttachmentController) readVoice(file *multipart.FileHeader, metadata *AttachmentMetadata) error {
+	if metadata.MimeType == "application/ogg" {
+		metadata.MimeType = "audio/ogg"
+	}
+
+	voiceType := false
+	for _, t := range VoiceTypes {
+		voiceType = voiceType || metadata.MimeType == t
+	}
+
+	if !voiceType {
+		return fmt.Errorf("voice note type %s is not allowed", metadata.MimeType)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error opening file: %s", err.Error())
+	}
+	defer src.Close()
+
+	duration, wave, err := ReadVoice(src)
+	if err != nil {
+		return err
+	}
+
+	metadata.Kind = Voice
+	metadata.Duration = duration
+	metadata.Waveform = wave
+	return nil
+}
+
+// FindRoomAttachments returns the attachments when all of them were uploaded by owner to the room
+func (me *AttachmentController) FindRoomAttachments(owner, roomId string, ids []string) ([]*DBAttachment, error) {
+	if len(ids) > MaxAttachments {
+		return nil, fmt.Errorf("too many attachments, max %d", MaxAttachments)
+	}
+
+	attachments, err := me.service.FindAttachments(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*DBAttachment)
+	for _, a := range attachments {
+		if a.Metadata.Owner == owner && a.Metadata.RoomId == roomId {
+			found[a.Id.Hex()] = a
+		}
+	}
+
+	// in the order they were sent
+	var results []*DBAttachment
+	for _, id := range ids {
+		a, ok := found[id]
+		if !ok {
+			return nil, fmt.Errorf("attachment %s unavailable", id)
+		}
+		results = append(results, a)
+	}
+
+	return results, nil
+}
+
+// ShareAttachments lets the members of roomId download the files of a forwarded message
+func (me *AttachmentController) ShareAttachments(ids []string, roomId string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return me.service.ShareAttachments(ids, roomId)
+}
+
+// DeleteAttachments removes files of messages which are gone, it goes on after a failure
+func (me *AttachmentController) DeleteAttachments(ids []string) error {
+	var failed error
+	for _, id := range ids {
+		if err := me.service.DeleteAttachment(id); err != nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+func (me *AttachmentController) UploadHandler(c *gin.Context) {
+	vuser, ok := c.Get("validuser")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired"})
+		return
+	}
+
+	// room for the multipart headers around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+64*1024)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error retrieving file: " + err.Error()})
+		return
+	}
+
+	roomId := c.PostForm("room_id")
+	if !utils.IsValidUid(roomId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room id"})
+		return
+	}
+
+	if !me.isMember(roomId, validuser.GetUID()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not a member of this room"})
+		return
+	}
+
+	attachment, err := me.UploadAttachment(validuser.GetUID(), roomId, c.PostForm("kind"), file)
+	if err != nil {
+		Logger.V(2).Error(err, "error while uploading attachment")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error uploading attachment: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment.ToAttachment())
+}
+
+// DownloadHandler sends the file to the members of its rooms only
+func (me *AttachmentController) DownloadHandler(c *gin.Context) {
+	vuser, ok := c.Get("validuser")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired"})
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	attachment, err := me.service.FindAttachment(id.Hex())
+	if err != nil || !me.canDownload(attachment, validuser.GetUID()) {
+		// the same answer for both, ids of other rooms are not disclosed
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found"})
+		return
+	}
+
+	disposition := "attachment"
+	if isInline(attachment.Metadata.MimeType) {
+		disposition = "inline"
+	}
+
+	c.Header("Content-Type", attachment.Metadata.MimeType)
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, attachment.Metadata.Name))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+
+	// ServeContent answers range requests, so audio and video can seek
+	content := me.service.OpenAttachment(attachment)
+	defer content.Close()
+	http.ServeContent(c.Writer, c.Request, "", attachment.UploadDate, content)
+}
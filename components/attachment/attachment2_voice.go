@@ -0,0 +1,328 @@
+package attachment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// bars of a voice note waveform
+	waveformBars = 64
+
+	// max value of a waveform bar
+	waveformMax = 100
+
+	// MaxVoiceDuration of a voice note in milliseconds
+	MaxVoiceDuration = 5 * 60 * 1000
+
+	// opus granule positions always count 48kHz samples
+	opusSampleRate = 48000
+)
+
+// VoiceTypes are the containers accepted for a voice note, all carrying opus
+var VoiceTypes = []string{"audio/ogg", "audio/opus", "audio/webm", "video/webm"}
+
+// voicePacket is the size of an opus packet at its time, loud audio needs more bytes
+type voicePacket struct {
+	at   int64
+	size int
+}
+
+type voiceInfo struct {
+	duration int64 // milliseconds
+	packets  []voicePacket
+}
+
+// ReadVoice checks the container of a voice note and returns its duration in milliseconds and its waveform.
+// The opus packets are not decoded, the waveform follows their sizes which grow with the loudness of the audio.
+func ReadVoice(r io.Reader) (int64, []int, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return 0, nil, fmt.Errorf("voice note too short")
+	}
+
+	var info *voiceInfo
+	switch {
+	case bytes.Equal(magic, []byte("OggS")):
+		info, err = readOgg(br)
+	case bytes.Equal(magic, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		info, err = readWebm(br)
+	default:
+		return 0, nil, fmt.Errorf("voice note must be ogg or webm")
+	}
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if info.duration <= 0 || len(info.packets) == 0 {
+		return 0, nil, fmt.Errorf("voice note has no audio")
+	}
+
+	if info.duration > MaxVoiceDuration {
+		return 0, nil, fmt.Errorf("voice note is longer than %d seconds", MaxVoiceDuration/1000)
+	}
+
+	return info.duration, waveform(info.packets), nil
+}
+
+// waveform averages the packet sizes into bars scaled to waveformMax
+func waveform(packets []voicePacket) []int {
+	bars := waveformBars
+	if len(packets) < bars {
+		bars = len(packets)
+	}
+
+	sums := make([]float64, bars)
+	counts := make([]int, bars)
+	for i, p := range packets {
+		b := i * bars / len(packets)
+		sums[b] += float64(p.size)
+		counts[b]++
+	}
+
+	max := 0.0
+	for b := range sums {
+		sums[b] /= float64(counts[b])
+		max = math.Max(max, sums[b])
+	}
+
+	wave := make([]int, bars)
+	for b := range sums {
+		if max > 0 {
+			wave[b] = int(math.Round(sums[b] / max * waveformMax))
+		}
+	}
+
+	return wave
+}
+
+// readOgg walks the pages of an ogg opus stream, the last granule position is the duration
+func readOgg(r io.Reader) (*voiceInfo, error) {
+	info := &voiceInfo{}
+	var preSkip int64
+	var granule int64
+	page := 0
+	var packet int
+	header := make([]byte, 27)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF && page > 0 {
+				break
+			}
+			return nil, fmt.Errorf("invalid ogg page")
+		}
+
+		if !bytes.Equal(header[:4], []byte("OggS")) || header[4] != 0 {
+			return nil, fmt.Errorf("invalid ogg page")
+		}
+
+		if g := int64(binary.LittleEndian.Uint64(header[6:14])); g > 0 {
+			granule = g
+		}
+
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return nil, fmt.Errorf("invalid ogg page")
+		}
+
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("invalid ogg page")
+		}
+
+		// the first page is the opus head, the second the tags
+		if page == 0 {
+			if len(body) < 19 || !bytes.Equal(body[:8], []byte("OpusHead")) {
+				return nil, fmt.Errorf("ogg voice note must be opus")
+			}
+			preSkip = int64(binary.LittleEndian.Uint16(body[10:12]))
+		}
+
+		if page >= 2 {
+			// a packet ends on a lacing value below 255
+			for _, s := range segments {
+				packet += int(s)
+				if s < 255 {
+					info.packets = append(info.packets, voicePacket{at: granule, size: packet})
+					packet = 0
+				}
+			}
+		}
+		page++
+	}
+
+	info.duration = (granule - preSkip) * 1000 / opusSampleRate
+	return info, nil
+}
+
+// ebml element ids read from a webm file
+const (
+	ebmlHeader    = 0x1A45DFA3
+	ebmlDocType   = 0x4282
+	segment       = 0x18538067
+	segmentInfo   = 0x1549A966
+	timecodeScale = 0x2AD7B1
+	infoDuration  = 0x4489
+	tracks        = 0x1654AE6B
+	trackEntry    = 0xAE
+	codecID       = 0x86
+	cluster       = 0x1F43B675
+	clusterTime   = 0xE7
+	simpleBlock   = 0xA3
+	blockGroup    = 0xA0
+	block         = 0xA1
+)
+
+// vintLength is the length of an ebml variable size integer from its first byte, 0 when invalid
+func vintLength(first byte) int {
+	for length := 1; length <= 8; length++ {
+		if first&(0x80>>(length-1)) != 0 {
+			return length
+		}
+	}
+
+	return 0
+}
+
+// readVint reads an ebml variable size integer, keeping the length marker for ids
+func readVint(r *bufio.Reader, keepMarker bool) (uint64, bool, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	length := vintLength(first)
+	if length == 0 {
+		return 0, false, fmt.Errorf("invalid webm element")
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	unknown := value == uint64(0xFF>>length)
+
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		value = value<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+
+	return value, unknown && !keepMarker, nil
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// readWebm walks the elements of a webm file without building a tree, master elements are entered
+// in place so the unknown sizes written by browsers recording live are fine
+func readWebm(r *bufio.Reader) (*voiceInfo, error) {
+	info := &voiceInfo{}
+	scale := int64(1000000) // nanoseconds per timecode
+	var duration float64
+	var clusterAt int64
+	docType, codec := "", ""
+
+	for {
+		id, _, err := readVint(r, true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid webm element")
+		}
+
+		size, unknown, err := readVint(r, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webm element")
+		}
+
+		switch id {
+		case ebmlHeader, segment, segmentInfo, tracks, trackEntry, cluster, blockGroup:
+			continue
+		}
+
+		if unknown || size > 16*1024*1024 {
+			return nil, fmt.Errorf("invalid webm element size")
+		}
+
+		switch id {
+		case ebmlDocType, timecodeScale, infoDuration, codecID, clusterTime, simpleBlock, block:
+		default:
+			if _, err := r.Discard(int(size)); err != nil {
+				return nil, fmt.Errorf("truncated webm")
+			}
+			continue
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("truncated webm")
+		}
+
+		switch id {
+		case ebmlDocType:
+			docType = string(data)
+		case timecodeScale:
+			scale = int64(readUint(data))
+		case infoDuration:
+			if size == 4 {
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+			} else if size == 8 {
+				duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+			}
+		case codecID:
+			codec = string(data)
+		case clusterTime:
+			clusterAt = int64(readUint(data))
+		case simpleBlock, block:
+			// track number, then the time relative to the cluster and the flags
+			header := 0
+			if len(data) > 0 {
+				header = vintLength(data[0])
+			}
+			if header == 0 || len(data) < header+3 {
+				return nil, fmt.Errorf("invalid webm block")
+			}
+			at := clusterAt + int64(int16(binary.BigEndian.Uint16(data[header:header+2])))
+			info.packets = append(info.packets, voicePacket{at: at, size: len(data) - header - 3})
+		}
+	}
+
+	if docType != "webm" {
+		return nil, fmt.Errorf("voice note must be webm")
+	}
+
+	if codec != "A_OPUS" {
+		return nil, fmt.Errorf("webm voice note must be opus")
+	}
+
+	// recorders streaming live do not write the duration, the last block tells it
+	if duration > 0 {
+		info.duration = int64(duration) * scale / 1000000
+	} else if len(info.packets) > 0 {
+		info.duration = info.packets[len(info.packets)-1].at * scale / 1000000
+	}
+
+	return info, nil
+}
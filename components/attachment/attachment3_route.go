@@ -0,0 +1,60 @@
+package attachment
+
+import (
+	"context"
+	"net/http"
+	"pesatu/components/roommember"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/juju/ratelimit"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var Logger logr.Logger = logr.Discard()
+
+// GridFS bucket of the attachments, apart from the images
+const BucketName = "attachments"
+
+type AttachmentRoute struct {
+	controller AttachmentController
+	limiter    *ratelimit.Bucket
+}
+
+// NewAttachmentBucket opens the GridFS bucket of the attachments
+func NewAttachmentBucket(mongoclient *mongo.Client) (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(mongoclient.Database("pesatu"), options.GridFSBucket().SetName(BucketName))
+}
+
+func NewAttachmentRoute(mongoclient *mongo.Client, ctx context.Context, l logr.Logger, limiter *ratelimit.Bucket) AttachmentRoute {
+	Logger = l
+	Logger.V(2).Info("New Attachment Route created")
+	gridfsBucket, err := NewAttachmentBucket(mongoclient)
+	if err != nil {
+		Logger.Error(err, "Error creating GridFS bucket")
+	}
+	roomCollection := mongoclient.Database("pesatu").Collection("rooms")
+	memberCollection := mongoclient.Database("pesatu").Collection("roommembers")
+	service := NewAttachmentService(gridfsBucket, ctx)
+	memberService := roommember.NewRoomMemberService(roomCollection, memberCollection, ctx)
+	controller := NewAttachmentController(service, memberService)
+	return AttachmentRoute{controller, limiter}
+}
+
+func (me *AttachmentRoute) InitRouteTo(rg *gin.RouterGroup) {
+	router := rg.Group("/attachment")
+	router.POST("/upload", me.RateLimit, me.controller.UploadHandler)
+	router.GET("/:id", me.RateLimit, me.controller.DownloadHandler)
+}
+
+func (me *AttachmentRoute) RateLimit(ctx *gin.Context) {
+	// Check if the request is allowed by the rate limiter
+	if me.limiter.TakeAvailable(1) == 0 {
+		// The request is not allowed, so return an error
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	ctx.Next()
+}
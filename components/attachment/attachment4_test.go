@@ -0,0 +1,95 @@
+package attachment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'attachment'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'attachment'")
+}
+
+func oggPage(granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+
+	page := []byte("OggS")
+	page = append(page, 0, 0)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = append(page, make([]byte, 12)...) // serial, sequence, checksum
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func Test_ReadVoiceOgg(t *testing.T) {
+	asserts := assert.New(t)
+
+	head := append([]byte("OpusHead"), 1, 1)
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = append(head, make([]byte, 7)...)
+
+	var ogg []byte
+	ogg = append(ogg, oggPage(0, head)...)
+	ogg = append(ogg, oggPage(0, []byte("OpusTags"))...)
+	ogg = append(ogg, oggPage(24312, make([]byte, 10), make([]byte, 300))...)
+	ogg = append(ogg, oggPage(48312, make([]byte, 150))...)
+
+	duration, wave, err := ReadVoice(bytes.NewReader(ogg))
+	asserts.NoError(err)
+	asserts.Equal(int64(1000), duration)
+	asserts.Equal([]int{3, 100, 50}, wave)
+
+	_, _, err = ReadVoice(bytes.NewReader([]byte("RIFF....WAVE")))
+	asserts.Error(err)
+}
+
+func ebml(id []byte, data []byte) []byte {
+	// size as an 8 bytes vint, the marker then 7 bytes
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(data)))
+	size[0] = 0x01
+	e := append(append([]byte{}, id...), size...)
+	return append(e, data...)
+}
+
+func Test_ReadVoiceWebm(t *testing.T) {
+	asserts := assert.New(t)
+
+	var webm []byte
+	webm = append(webm, ebml([]byte{0x1A, 0x45, 0xDF, 0xA3}, ebml([]byte{0x42, 0x82}, []byte("webm")))...)
+	// segment and cluster of unknown size, as written by a live recorder
+	webm = append(webm, 0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	webm = append(webm, ebml([]byte{0x16, 0x54, 0xAE, 0x6B}, ebml([]byte{0xAE}, ebml([]byte{0x86}, []byte("A_OPUS"))))...)
+	webm = append(webm, 0x1F, 0x43, 0xB6, 0x75, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	webm = append(webm, ebml([]byte{0xE7}, []byte{0})...)
+	for i := 0; i < 50; i++ {
+		block := []byte{0x81}
+		block = binary.BigEndian.AppendUint16(block, uint16(i*20))
+		block = append(block, 0x80)
+		block = append(block, make([]byte, 40+i)...)
+		webm = append(webm, ebml([]byte{0xA3}, block)...)
+	}
+
+	duration, wave, err := ReadVoice(bytes.NewReader(webm))
+	asserts.NoError(err)
+	asserts.Equal(int64(980), duration)
+	asserts.Len(wave, 50)
+	asserts.Equal(100, wave[49])
+}
@@ -19,6 +19,15 @@ const (
 
 var ValidStatuses = [5]Status{Waiting, Pending, Accepted, Rejected, Blocked}
 
+type EventAction = string
+
+// contact events, pushed to the other user of the contact, a block is never told
+const (
+	ContactRequested EventAction = "contact-requested"
+	ContactAccepted  EventAction = "contact-accepted"
+	ContactRemoved   EventAction = "contact-removed"
+)
+
 type SearchUser struct {
 	UID     string `json:"uid"`
 	Keyword string `json:"keyword"`
@@ -33,6 +42,12 @@ type SearchUserCount struct {
 	Status  string `json:"status"`
 }
 
+type SearchBlocked struct {
+	UID   string `json:"uid"`
+	Page  string `json:"page"`
+	Limit string `json:"limit"`
+}
+
 type ResponseStatus struct {
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
@@ -81,3 +96,19 @@ type DBUserContact struct {
 	Avatar    string             `json:"avatar" bson:"avatar"`
 	Contact   *DBContact
 }
+
+// ContactEvent is a change of a contact told to the user of ToUID, about the user who made it,
+// Status is the contact as the receiver sees it now
+type ContactEvent struct {
+	Action   string    `json:"action"`
+	Name     string    `json:"name"`
+	Username string    `json:"username"`
+	Avatar   string    `json:"avatar"`
+	Status   string    `json:"status,omitempty"`
+	ToUID    string    `json:"-"`
+	Time     time.Time `json:"time"`
+}
+
+type I_ContactNotifier interface {
+	NotifyContactEvent(event *ContactEvent)
+}
@@ -23,11 +23,16 @@ type I_ContactRepo interface {
 	FindMyContacts(myUid, status string, page, limit int) ([]*DBContact, error)
 	FindMyContactTo(myUid, to string) (*DBContact, error)
 	FindContactsRequest(toUid string, page, limit int) ([]*DBContact, error)
+	FindContactUIDs(myUid, status string) ([]string, error)
 	FindUserConnection(uidOwner, toUsername string) (*DBUserContact, error)
 	FindUsersByName(uidOwner, name, username, status string, page, limit int) ([]*UserContact, error)
 	FindUsersByUsername(uidOwner, name, status string, page, limit int) ([]*UserContact, error)
 	FindUserCountByName(uidOwner, name, username, status string) (int64, error)
 	FindUserCountByUsername(uidOwner, username, status string) (int64, error)
+	BlockContact(owner, to string) error
+	UnblockContact(owner, to string) error
+	IsBlocked(uid1, uid2 string) (bool, error)
+	FindBlockedUsers(owner string, page, limit int) ([]*UserContact, error)
 }
 
 type ContactService struct {
@@ -196,6 +201,23 @@ func (me *ContactService) FindMyContactTo(myUid, to string) (*DBContact, error)
 	return contacts[0], nil
 }
 
+// FindContactUIDs returns the uid of every contact of myUid with the status, without paging
+func (me *ContactService) FindContactUIDs(myUid, status string) ([]string, error) {
+	values, err := me.contactCollection.Distinct(me.ctx, "to", bson.M{"owner": myUid, "status": status})
+	if err != nil {
+		return nil, err
+	}
+
+	uids := make([]string, 0, len(values))
+	for _, v := range values {
+		if uid, ok := v.(string); ok {
+			uids = append(uids, uid)
+		}
+	}
+
+	return uids, nil
+}
+
 func (me *ContactService) FindContactsRequest(toUid string, page, limit int) ([]*DBContact, error) {
 	if page == 0 {
 		page = 1
@@ -335,6 +357,11 @@ func (me *ContactService) FindUsersByName(uidOwner, name, username, status strin
 
 	skip := (page - 1) * limit
 
+	hidden, err := me.hiddenUIDs(uidOwner)
+	if err != nil {
+		return nil, err
+	}
+
 	temp := []bson.M{
 		{"$eq": []interface{}{"$owner", "$$userUID"}},
 		{"$eq": []interface{}{"$to", uidOwner}},
@@ -350,7 +377,7 @@ func (me *ContactService) FindUsersByName(uidOwner, name, username, status strin
 				{"name": bson.M{"$regex": fmt.Sprintf(".*%s.*", name), "$options": "i"}},
 				{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}},
 			},
-			"uid": bson.M{"$ne": uidOwner},
+			"uid": bson.M{"$nin": hidden},
 		}},
 		{"$lookup": bson.M{
 			"from": "contact",
@@ -394,6 +421,11 @@ func (me *ContactService) FindUsersByUsername(uidOwner, username, status string,
 
 	skip := (page - 1) * limit
 
+	hidden, err := me.hiddenUIDs(uidOwner)
+	if err != nil {
+		return nil, err
+	}
+
 	temp := []bson.M{
 		{"$eq": []interface{}{"$owner", "$$userUID"}},
 		{"$eq": []interface{}{"$to", uidOwner}},
@@ -404,7 +436,7 @@ func (me *ContactService) FindUsersByUsername(uidOwner, username, status string,
 	}
 
 	pipeline := []bson.M{
-		{"$match": bson.M{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}, "uid": bson.M{"$ne": uidOwner}}},
+		{"$match": bson.M{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}, "uid": bson.M{"$nin": hidden}}},
 		{"$lookup": bson.M{
 			"from": "contact",
 			"let":  bson.M{"userUID": "$uid"},
@@ -437,6 +469,11 @@ func (me *ContactService) FindUsersByUsername(uidOwner, username, status string,
 }
 
 func (me *ContactService) FindUserCountByName(uidOwner, name, username, status string) (int64, error) {
+	hidden, err := me.hiddenUIDs(uidOwner)
+	if err != nil {
+		return 0, err
+	}
+
 	temp := []bson.M{
 		{"$eq": []interface{}{"$owner", "$$userUID"}},
 		{"$eq": []interface{}{"$to", uidOwner}},
@@ -452,7 +489,7 @@ func (me *ContactService) FindUserCountByName(uidOwner, name, username, status s
 				{"name": bson.M{"$regex": fmt.Sprintf(".*%s.*", name), "$options": "i"}},
 				{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}},
 			},
-			"uid": bson.M{"$ne": uidOwner},
+			"uid": bson.M{"$nin": hidden},
 		}},
 		{"$lookup": bson.M{
 			"from": "contact",
@@ -490,6 +527,11 @@ func (me *ContactService) FindUserCountByName(uidOwner, name, username, status s
 }
 
 func (me *ContactService) FindUserCountByUsername(uidOwner, username, status string) (int64, error) {
+	hidden, err := me.hiddenUIDs(uidOwner)
+	if err != nil {
+		return 0, err
+	}
+
 	temp := []bson.M{
 		{"$eq": []interface{}{"$owner", "$$userUID"}},
 		{"$eq": []interface{}{"$to", uidOwner}},
@@ -500,7 +542,7 @@ func (me *ContactService) FindUserCountByUsername(uidOwner, username, status str
 	}
 
 	pipeline := []bson.M{
-		{"$match": bson.M{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}, "uid": bson.M{"$ne": uidOwner}}},
+		{"$match": bson.M{"username": bson.M{"$regex": fmt.Sprintf(".*%s.*", username)}, "uid": bson.M{"$nin": hidden}}},
 		{"$lookup": bson.M{
 			"from": "contact",
 			"let":  bson.M{"userUID": "$uid"},
@@ -535,3 +577,124 @@ func (me *ContactService) FindUserCountByUsername(uidOwner, username, status str
 
 	return result.TotalCount, nil
 }
+
+// BlockContact makes to blocked by owner, the contact to has with owner is removed unless to
+// blocked owner too, each block stays until its owner unblocks
+func (me *ContactService) BlockContact(owner, to string) error {
+	now := time.Now()
+	query := bson.M{"owner": owner, "to": to}
+	update := bson.M{
+		"$set":         bson.M{"status": Blocked, "updated_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+
+	if _, err := me.contactCollection.UpdateOne(me.ctx, query, update, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	reverse := bson.M{"owner": to, "to": owner, "status": bson.M{"$ne": Blocked}}
+	if _, err := me.contactCollection.DeleteOne(me.ctx, reverse); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (me *ContactService) UnblockContact(owner, to string) error {
+	res, err := me.contactCollection.DeleteOne(me.ctx, bson.M{"owner": owner, "to": to, "status": Blocked})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return errors.New("user is not blocked")
+	}
+
+	return nil
+}
+
+// IsBlocked tells whether one of the users has blocked the other
+func (me *ContactService) IsBlocked(uid1, uid2 string) (bool, error) {
+	query := bson.M{
+		"$or": []bson.M{
+			{"owner": uid1, "to": uid2},
+			{"owner": uid2, "to": uid1},
+		},
+		"status": Blocked,
+	}
+
+	count, err := me.contactCollection.CountDocuments(me.ctx, query)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// FindBlockedUsers returns the users blocked by owner, the latest first
+func (me *ContactService) FindBlockedUsers(owner string, page, limit int) ([]*UserContact, error) {
+	if page == 0 {
+		page = 1
+	}
+
+	if limit == 0 {
+		limit = 10
+	}
+
+	skip := (page - 1) * limit
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"owner": owner, "status": Blocked}},
+		{"$sort": bson.M{"updated_at": -1}},
+		{"$skip": skip},
+		{"$limit": limit},
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "to",
+			"foreignField": "uid",
+			"as":           "user",
+		}},
+		{"$unwind": "$user"},
+		{"$project": bson.M{
+			"_id":      0,
+			"name":     "$user.name",
+			"username": "$user.username",
+			"avatar":   "$user.avatar",
+			"contact": bson.M{
+				"status":     "$status",
+				"created_at": "$created_at",
+				"updated_at": "$updated_at",
+			},
+		}},
+	}
+
+	cursor, err := me.contactCollection.Aggregate(me.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	ucontacts := []*UserContact{}
+	if err := cursor.All(me.ctx, &ucontacts); err != nil {
+		return nil, err
+	}
+
+	return ucontacts, nil
+}
+
+// hiddenUIDs are the users uidOwner can not find, uidOwner and who blocked them
+func (me *ContactService) hiddenUIDs(uidOwner string) ([]string, error) {
+	values, err := me.contactCollection.Distinct(me.ctx, "owner", bson.M{"to": uidOwner, "status": Blocked})
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := []string{uidOwner}
+	for _, v := range values {
+		if uid, ok := v.(string); ok {
+			hidden = append(hidden, uid)
+		}
+	}
+
+	return hidden, nil
+}
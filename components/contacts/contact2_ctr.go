@@ -15,10 +15,11 @@ import (
 
 type ContactController struct {
 	contactService I_ContactRepo
+	notifier       I_ContactNotifier
 }
 
 func NewContactController(contactService I_ContactRepo) ContactController {
-	return ContactController{contactService}
+	return ContactController{contactService: contactService}
 }
 
 func checkStatus(s Status) bool {
@@ -57,8 +58,14 @@ func (me *ContactController) CreateContact(validuser *auth.Claims, newContact *C
 		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "user uid did not match 2"}, http.StatusOK
 	}
 
+	if targetuser.Contact != nil && targetuser.Contact.Status == Blocked {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "can not add this user"}, http.StatusOK
+	}
+
 	if user.Contact != nil {
 		switch user.Contact.Status {
+		case Blocked:
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "unblock the user first"}, http.StatusOK
 		case Pending:
 			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "friend request already sent"}, http.StatusOK
 		case Accepted:
@@ -83,6 +90,7 @@ func (me *ContactController) CreateContact(validuser *auth.Claims, newContact *C
 	}
 
 	var res *DBContact
+	action, status := ContactRequested, Waiting
 	if targetuser.Contact != nil {
 		nc.Status = Accepted
 
@@ -93,9 +101,7 @@ func (me *ContactController) CreateContact(validuser *auth.Claims, newContact *C
 			Logger.Error(err, "error updating user target contact")
 			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusInternalServerError
 		}
-
-		//todo notif friend request accepted
-
+		action, status = ContactAccepted, Accepted
 	} else {
 		tnc := &Contact{
 			Owner:     targetuser.UID,
@@ -109,8 +115,6 @@ func (me *ContactController) CreateContact(validuser *auth.Claims, newContact *C
 			Logger.Error(err, "error creating user target contact")
 			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusInternalServerError
 		}
-
-		//todo notif target got a friend request
 	}
 
 	// if res == nil {
@@ -136,6 +140,8 @@ func (me *ContactController) CreateContact(validuser *auth.Claims, newContact *C
 	utils.CopyStruct(res, &result)
 	result.Owner = targetuser.Username
 
+	me.notify(action, user, targetuser.UID, status)
+
 	Logger.V(2).Info("create contact success")
 	return &result, nil, http.StatusCreated
 }
@@ -184,10 +190,105 @@ func (me *ContactController) RemoveContact(validuser *auth.Claims, delContact *C
 		return false, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusInternalServerError
 	}
 
+	me.notify(ContactRemoved, user, targetuser.UID, "")
+
 	Logger.V(2).Info("delete contact success")
 	return true, nil, http.StatusCreated
 }
 
+// notify tells the user of toUID about the change made by from, when a notifier is set
+func (me *ContactController) notify(action EventAction, from *DBUserContact, toUID, status string) {
+	if me.notifier == nil {
+		return
+	}
+
+	me.notifier.NotifyContactEvent(&ContactEvent{
+		Action:   action,
+		Name:     from.Name,
+		Username: from.Username,
+		Avatar:   from.Avatar,
+		Status:   status,
+		ToUID:    toUID,
+		Time:     time.Now(),
+	})
+}
+
+// BlockUser blocks o.ToUsrName for the user, a contact between them is removed
+func (me *ContactController) BlockUser(validuser *auth.Claims, o *CreateContact) (bool, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("block %s by %s", o.ToUsrName, o.UID))
+
+	targetuser, rpcErr := me.findBlockTarget(validuser, o)
+	if rpcErr != nil {
+		return false, rpcErr, http.StatusOK
+	}
+
+	if err := me.contactService.BlockContact(validuser.GetUID(), targetuser.UID); err != nil {
+		Logger.Error(err, "error blocking contact")
+		return false, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusInternalServerError
+	}
+
+	return true, nil, http.StatusOK
+}
+
+func (me *ContactController) UnblockUser(validuser *auth.Claims, o *CreateContact) (bool, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("unblock %s by %s", o.ToUsrName, o.UID))
+
+	targetuser, rpcErr := me.findBlockTarget(validuser, o)
+	if rpcErr != nil {
+		return false, rpcErr, http.StatusOK
+	}
+
+	if err := me.contactService.UnblockContact(validuser.GetUID(), targetuser.UID); err != nil {
+		return false, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}, http.StatusOK
+	}
+
+	return true, nil, http.StatusOK
+}
+
+func (me *ContactController) findBlockTarget(validuser *auth.Claims, o *CreateContact) (*DBUserContact, *jsonrpc2.RPCError) {
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}
+	}
+
+	if _, err := utils.IsValidUsername(o.ToUsrName); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if o.ToUsrName == validuser.GetUsername() {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "can not block yourself"}
+	}
+
+	targetuser, err := me.contactService.FindUserConnection(validuser.GetUID(), o.ToUsrName)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: fmt.Sprintf("can not find requested user. %s", err.Error())}
+	}
+
+	return targetuser, nil
+}
+
+func (me *ContactController) GetBlockedList(validuser *auth.Claims, o *SearchBlocked) ([]*UserContact, *jsonrpc2.RPCError, int) {
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	page, err := strconv.Atoi(o.Page)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid page input"}, http.StatusOK
+	}
+
+	limit, err := strconv.Atoi(o.Limit)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid limit input"}, http.StatusOK
+	}
+
+	users, err := me.contactService.FindBlockedUsers(validuser.GetUID(), page, limit)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return users, nil, http.StatusOK
+}
+
 func (me *ContactController) SearchUsers(keyword, pageStr, limitStr, userUID, status string) ([]*UserContact, *jsonrpc2.RPCError, int) {
 	Logger.V(2).Info(fmt.Sprintf("search users %s", keyword))
 	var page = pageStr
@@ -47,6 +47,11 @@ func (me *ContactRoute) RateLimit(ctx *gin.Context) {
 	ctx.Next()
 }
 
+// SetNotifier sets the receiver of contact events, usually the websocket server
+func (me *ContactRoute) SetNotifier(notifier I_ContactNotifier) {
+	me.contactController.notifier = notifier
+}
+
 func (me *ContactRoute) GetContactService() I_ContactRepo {
 	return me.contactController.contactService
 }
@@ -75,6 +80,12 @@ func (me *ContactRoute) RPCHandle(ctx *gin.Context) {
 		statuscode = me.method_AddContact(ctx, &jreq, jres)
 	case "RemoveContact":
 		statuscode = me.method_RemoveContact(ctx, &jreq, jres)
+	case "BlockUser":
+		statuscode = me.method_BlockUser(ctx, &jreq, jres)
+	case "UnblockUser":
+		statuscode = me.method_UnblockUser(ctx, &jreq, jres)
+	case "GetBlockedList":
+		statuscode = me.method_GetBlockedList(ctx, &jreq, jres)
 	// case "GetContacts":
 	// 	statuscode = me.method_GetContacts(ctx, &jreq, jres)
 	default:
@@ -224,3 +235,92 @@ func (me *ContactRoute) method_RemoveContact(ctx *gin.Context, jreq *jsonrpc2.RP
 
 	return code
 }
+
+func (me *ContactRoute) method_BlockUser(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *CreateContact
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.contactController.BlockUser(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *ContactRoute) method_UnblockUser(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *CreateContact
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.contactController.UnblockUser(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *ContactRoute) method_GetBlockedList(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *SearchBlocked
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	if reg.Page == "" {
+		reg.Page = "1"
+	}
+
+	if reg.Limit == "" {
+		reg.Limit = "10"
+	}
+
+	res, e, code := me.contactController.GetBlockedList(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
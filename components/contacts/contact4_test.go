@@ -0,0 +1,69 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// run the repository tests against a local mongod with
+// MONGO_TEST_URL=mongodb://localhost:27017 go test -v ./components/contacts
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'contacts'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'contacts'")
+}
+
+// testDB returns an empty database dropped after the test
+func testDB(t *testing.T) *mongo.Database {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database(fmt.Sprintf("pesatu_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return db
+}
+
+func Test_MutualBlock(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewContactService(db.Collection("users"), db.Collection("contacts"), context.Background())
+
+	_, err := repo.CreateContact(&Contact{Owner: "a", To: "b", Status: Accepted})
+	asserts.Nil(err)
+
+	// a block removes the contact of the other user, not the block of the other user
+	asserts.Nil(repo.BlockContact("a", "b"))
+	asserts.Nil(repo.BlockContact("b", "a"))
+	asserts.Nil(repo.UnblockContact("b", "a"))
+
+	blocked, err := repo.IsBlocked("a", "b")
+	asserts.Nil(err)
+	asserts.True(blocked)
+
+	asserts.Nil(repo.UnblockContact("a", "b"))
+	blocked, _ = repo.IsBlocked("b", "a")
+	asserts.False(blocked)
+	asserts.NotNil(repo.UnblockContact("a", "b"))
+}
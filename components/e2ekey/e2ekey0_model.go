@@ -0,0 +1,78 @@
+package e2ekey
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SignedPrekey is a medium term key of a device, signed by its identity key.
+// Keys and signatures are base64, the server keeps them as published and never verifies them.
+type SignedPrekey struct {
+	KeyID     uint32 `json:"key_id" bson:"key_id"`
+	PublicKey string `json:"public_key" bson:"public_key"`
+	Signature string `json:"signature" bson:"signature"`
+}
+
+// Prekey is a one-time key, handed out once in a bundle then deleted
+type Prekey struct {
+	KeyID     uint32 `json:"key_id" bson:"key_id"`
+	PublicKey string `json:"public_key" bson:"public_key"`
+}
+
+// PublishKeysRequest registers a device of the user, every field but UID and Device is optional
+// after the first publish, so a device can refill its one-time prekeys alone
+type PublishKeysRequest struct {
+	UID          string        `json:"uid"`
+	Device       string        `json:"device"`
+	IdentityKey  string        `json:"identity_key,omitempty"`
+	SignedPrekey *SignedPrekey `json:"signed_prekey,omitempty"`
+	Prekeys      []*Prekey     `json:"prekeys,omitempty"`
+}
+
+// GetBundlesRequest asks for a bundle of every device of Username, or of Device only
+type GetBundlesRequest struct {
+	UID      string `json:"uid"`
+	Username string `json:"username"`
+	Device   string `json:"device,omitempty"`
+}
+
+type DeviceRequest struct {
+	UID    string `json:"uid"`
+	Device string `json:"device"`
+}
+
+type DBDevice struct {
+	Id           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UID          string             `json:"uid" bson:"uid"`
+	Device       string             `json:"device" bson:"device"`
+	IdentityKey  string             `json:"identity_key" bson:"identity_key"`
+	SignedPrekey *SignedPrekey      `json:"signed_prekey" bson:"signed_prekey"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type DBPrekey struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UID       string             `json:"uid" bson:"uid"`
+	Device    string             `json:"device" bson:"device"`
+	KeyID     uint32             `json:"key_id" bson:"key_id"`
+	PublicKey string             `json:"public_key" bson:"public_key"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PrekeyBundle starts a session with one device, Prekey is nil once the device ran out of one-time prekeys
+type PrekeyBundle struct {
+	Username     string        `json:"username"`
+	Device       string        `json:"device"`
+	IdentityKey  string        `json:"identity_key"`
+	SignedPrekey *SignedPrekey `json:"signed_prekey"`
+	Prekey       *Prekey       `json:"prekey,omitempty"`
+}
+
+type ResponseDevice struct {
+	Device      string    `json:"device"`
+	IdentityKey string    `json:"identity_key"`
+	Prekeys     int64     `json:"prekeys"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
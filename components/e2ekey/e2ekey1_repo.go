@@ -0,0 +1,171 @@
+package e2ekey
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type I_KeyRepo interface {
+	SaveDevice(device *DBDevice) (*DBDevice, error)
+	FindDevice(uid, device string) (*DBDevice, error)
+	FindDevices(uid string) ([]*DBDevice, error)
+	CountDevices(uid string) (int64, error)
+	RemoveDevice(uid, device string) error
+	AddPrekeys(uid, device string, prekeys []*Prekey) error
+	CountPrekeys(uid, device string) (int64, error)
+	TakePrekey(uid, device string) (*DBPrekey, error)
+}
+
+type KeyService struct {
+	deviceCollection *mongo.Collection
+	prekeyCollection *mongo.Collection
+	ctx              context.Context
+}
+
+func NewKeyService(deviceCollection, prekeyCollection *mongo.Collection, ctx context.Context) I_KeyRepo {
+	return &KeyService{deviceCollection, prekeyCollection, ctx}
+}
+
+// SaveDevice creates or updates the keys of a device, a new identity key drops the one-time prekeys
+// published with the previous one since no session can be started with them anymore
+func (me *KeyService) SaveDevice(device *DBDevice) (*DBDevice, error) {
+	now := time.Now()
+	filter := bson.M{"uid": device.UID, "device": device.Device}
+	update := bson.M{
+		"$set": bson.M{
+			"identity_key":  device.IdentityKey,
+			"signed_prekey": device.SignedPrekey,
+			"updated_at":    now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var before *DBDevice
+	err := me.deviceCollection.FindOneAndUpdate(me.ctx, filter, update, opts).Decode(&before)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if err := me.createDeviceIndexes(); err != nil {
+		return nil, err
+	}
+
+	if before != nil && before.IdentityKey != device.IdentityKey {
+		if _, err := me.prekeyCollection.DeleteMany(me.ctx, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return me.FindDevice(device.UID, device.Device)
+}
+
+func (me *KeyService) createDeviceIndexes() error {
+	opt := options.Index()
+	opt.SetUnique(true)
+
+	index := mongo.IndexModel{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "device", Value: 1}}, Options: opt}
+	_, err := me.deviceCollection.Indexes().CreateOne(me.ctx, index)
+	return err
+}
+
+func (me *KeyService) FindDevice(uid, device string) (*DBDevice, error) {
+	var res *DBDevice
+	if err := me.deviceCollection.FindOne(me.ctx, bson.M{"uid": uid, "device": device}).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (me *KeyService) FindDevices(uid string) ([]*DBDevice, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := me.deviceCollection.Find(me.ctx, bson.M{"uid": uid}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var devices []*DBDevice
+	if err := cursor.All(me.ctx, &devices); err != nil {
+		return nil, err
+	}
+
+	if len(devices) == 0 {
+		return []*DBDevice{}, nil
+	}
+
+	return devices, nil
+}
+
+func (me *KeyService) CountDevices(uid string) (int64, error) {
+	return me.deviceCollection.CountDocuments(me.ctx, bson.M{"uid": uid})
+}
+
+// RemoveDevice deletes a device with its one-time prekeys
+func (me *KeyService) RemoveDevice(uid, device string) error {
+	filter := bson.M{"uid": uid, "device": device}
+	res, err := me.deviceCollection.DeleteOne(me.ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	_, err = me.prekeyCollection.DeleteMany(me.ctx, filter)
+	return err
+}
+
+// AddPrekeys stores one-time prekeys, publishing a key id again replaces its key
+func (me *KeyService) AddPrekeys(uid, device string, prekeys []*Prekey) error {
+	if len(prekeys) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(prekeys))
+	for _, p := range prekeys {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"uid": uid, "device": device, "key_id": p.KeyID}).
+			SetReplacement(&DBPrekey{UID: uid, Device: device, KeyID: p.KeyID, PublicKey: p.PublicKey, CreatedAt: now}).
+			SetUpsert(true))
+	}
+
+	if _, err := me.prekeyCollection.BulkWrite(me.ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	opt := options.Index()
+	opt.SetUnique(true)
+
+	index := mongo.IndexModel{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "device", Value: 1}, {Key: "key_id", Value: 1}}, Options: opt}
+	_, err := me.prekeyCollection.Indexes().CreateOne(me.ctx, index)
+	return err
+}
+
+func (me *KeyService) CountPrekeys(uid, device string) (int64, error) {
+	return me.prekeyCollection.CountDocuments(me.ctx, bson.M{"uid": uid, "device": device})
+}
+
+// TakePrekey deletes and returns the oldest one-time prekey of a device in a single operation,
+// so two requesters never get the same key. It returns nil when none is left.
+func (me *KeyService) TakePrekey(uid, device string) (*DBPrekey, error) {
+	opts := options.FindOneAndDelete().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	var prekey *DBPrekey
+	err := me.prekeyCollection.FindOneAndDelete(me.ctx, bson.M{"uid": uid, "device": device}, opts).Decode(&prekey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return prekey, nil
+}
@@ -0,0 +1,315 @@
+package e2ekey
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/contacts"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// devices a user may register
+	MaxDevices = 10
+	// one-time prekeys kept for a device, and sent in one publish
+	MaxPrekeys        = 200
+	MaxPrekeysPublish = 100
+)
+
+// curve25519 keys, optionally prefixed by their type byte, and ed25519 or xeddsa signatures
+const (
+	keySize       = 32
+	typedKeySize  = 33
+	signatureSize = 64
+)
+
+var deviceRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type KeyController struct {
+	keyService     I_KeyRepo
+	contactService contacts.I_ContactRepo
+}
+
+func NewKeyController(keyService I_KeyRepo, contactService contacts.I_ContactRepo) KeyController {
+	return KeyController{keyService: keyService, contactService: contactService}
+}
+
+// CheckDevice validates a device id, chosen by the client
+func CheckDevice(device string) error {
+	if !deviceRegex.MatchString(device) {
+		return fmt.Errorf("invalid device id")
+	}
+
+	return nil
+}
+
+func checkKey(key string) error {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || (len(b) != keySize && len(b) != typedKeySize) {
+		return fmt.Errorf("invalid public key")
+	}
+
+	return nil
+}
+
+func checkSignedPrekey(p *SignedPrekey) error {
+	if err := checkKey(p.PublicKey); err != nil {
+		return fmt.Errorf("signed prekey: %s", err.Error())
+	}
+
+	b, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil || len(b) != signatureSize {
+		return fmt.Errorf("signed prekey: invalid signature")
+	}
+
+	return nil
+}
+
+func checkPrekeys(prekeys []*Prekey) error {
+	ids := make(map[uint32]bool)
+	for _, p := range prekeys {
+		if p == nil {
+			return fmt.Errorf("empty prekey")
+		}
+
+		if ids[p.KeyID] {
+			return fmt.Errorf("duplicate prekey id %d", p.KeyID)
+		}
+		ids[p.KeyID] = true
+
+		if err := checkKey(p.PublicKey); err != nil {
+			return fmt.Errorf("prekey %d: %s", p.KeyID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// PublishKeys registers or updates a device of the user. The first publish needs the identity key
+// and a signed prekey, later ones may rotate the signed prekey or only add one-time prekeys.
+func (me *KeyController) PublishKeys(validuser *auth.Claims, o *PublishKeysRequest) (*ResponseDevice, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("publish keys of device %s by %s", o.Device, validuser.GetUsername()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	if err := CheckDevice(o.Device); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+	}
+
+	if o.IdentityKey != "" {
+		if err := checkKey(o.IdentityKey); err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "identity key: " + err.Error()}, http.StatusOK
+		}
+	}
+
+	if o.SignedPrekey != nil {
+		if err := checkSignedPrekey(o.SignedPrekey); err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	if len(o.Prekeys) > MaxPrekeysPublish {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("max %d prekeys per publish", MaxPrekeysPublish)}, http.StatusOK
+	}
+
+	if err := checkPrekeys(o.Prekeys); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+	}
+
+	device, err := me.keyService.FindDevice(validuser.GetUID(), o.Device)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if device == nil {
+		if o.IdentityKey == "" || o.SignedPrekey == nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "a new device needs an identity key and a signed prekey"}, http.StatusOK
+		}
+
+		count, err := me.keyService.CountDevices(validuser.GetUID())
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		if count >= MaxDevices {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("max %d devices, remove one first", MaxDevices)}, http.StatusOK
+		}
+
+		device = &DBDevice{UID: validuser.GetUID(), Device: o.Device}
+	}
+
+	// the signed prekey of the previous identity can not be verified with the new one
+	if o.IdentityKey != "" && o.IdentityKey != device.IdentityKey && o.SignedPrekey == nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "a new identity key needs a new signed prekey"}, http.StatusOK
+	}
+
+	if o.IdentityKey != "" || o.SignedPrekey != nil {
+		if o.IdentityKey != "" {
+			device.IdentityKey = o.IdentityKey
+		}
+		if o.SignedPrekey != nil {
+			device.SignedPrekey = o.SignedPrekey
+		}
+
+		device, err = me.keyService.SaveDevice(device)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	count, err := me.keyService.CountPrekeys(validuser.GetUID(), o.Device)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if len(o.Prekeys) > 0 {
+		if count+int64(len(o.Prekeys)) > MaxPrekeys {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("max %d prekeys per device, %d stored", MaxPrekeys, count)}, http.StatusOK
+		}
+
+		if err := me.keyService.AddPrekeys(validuser.GetUID(), o.Device, o.Prekeys); err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		// a republished key id replaces its key instead of adding one
+		count, err = me.keyService.CountPrekeys(validuser.GetUID(), o.Device)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	return toResponse(device, count), nil, http.StatusOK
+}
+
+// GetBundles hands out a prekey bundle of the devices of a user who has the requester as accepted contact,
+// each bundle consumes one one-time prekey of its device
+func (me *KeyController) GetBundles(validuser *auth.Claims, o *GetBundlesRequest) ([]*PrekeyBundle, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("get prekey bundles of %s by %s", o.Username, validuser.GetUsername()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	_, err := utils.IsValidUsername(o.Username)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+	}
+
+	if o.Device != "" {
+		if err := CheckDevice(o.Device); err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	targetuser, err := me.contactService.FindUserConnection(validuser.GetUID(), o.Username)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: "user not found"}, http.StatusOK
+	}
+
+	// the contact of the target to the requester, the other devices of the requester are always allowed
+	accepted := targetuser.Contact != nil && targetuser.Contact.Status == contacts.Accepted
+	if !accepted && targetuser.UID != validuser.GetUID() {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not in their contact"}, http.StatusOK
+	}
+
+	var devices []*DBDevice
+	if o.Device != "" {
+		device, err := me.keyService.FindDevice(targetuser.UID, o.Device)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: "device not found"}, http.StatusOK
+			}
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+		devices = append(devices, device)
+	} else {
+		devices, err = me.keyService.FindDevices(targetuser.UID)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	bundles := []*PrekeyBundle{}
+	for _, device := range devices {
+		prekey, err := me.keyService.TakePrekey(device.UID, device.Device)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		bundle := &PrekeyBundle{
+			Username:     targetuser.Username,
+			Device:       device.Device,
+			IdentityKey:  device.IdentityKey,
+			SignedPrekey: device.SignedPrekey,
+		}
+		if prekey != nil {
+			bundle.Prekey = &Prekey{KeyID: prekey.KeyID, PublicKey: prekey.PublicKey}
+		}
+
+		bundles = append(bundles, bundle)
+	}
+
+	return bundles, nil, http.StatusOK
+}
+
+// GetDevices lists the devices of the user with their one-time prekeys left, so each can refill in time
+func (me *KeyController) GetDevices(validuser *auth.Claims, o *DeviceRequest) ([]*ResponseDevice, *jsonrpc2.RPCError, int) {
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	devices, err := me.keyService.FindDevices(validuser.GetUID())
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	res := []*ResponseDevice{}
+	for _, device := range devices {
+		count, err := me.keyService.CountPrekeys(device.UID, device.Device)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		res = append(res, toResponse(device, count))
+	}
+
+	return res, nil, http.StatusOK
+}
+
+// RemoveDevice unregisters a device, no new session can be started with it
+func (me *KeyController) RemoveDevice(validuser *auth.Claims, o *DeviceRequest) (bool, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("remove device %s by %s", o.Device, validuser.GetUsername()))
+
+	if validuser.GetUID() != o.UID {
+		return false, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	if err := CheckDevice(o.Device); err != nil {
+		return false, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+	}
+
+	if err := me.keyService.RemoveDevice(validuser.GetUID(), o.Device); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: "device not found"}, http.StatusOK
+		}
+		return false, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return true, nil, http.StatusOK
+}
+
+func toResponse(device *DBDevice, prekeys int64) *ResponseDevice {
+	return &ResponseDevice{
+		Device:      device.Device,
+		IdentityKey: device.IdentityKey,
+		Prekeys:     prekeys,
+		UpdatedAt:   device.UpdatedAt,
+	}
+}
@@ -0,0 +1,195 @@
+package e2ekey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/contacts"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/juju/ratelimit"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var Logger logr.Logger = logr.Discard()
+
+type KeyRoute struct {
+	controller KeyController
+	limiter    *ratelimit.Bucket
+}
+
+func NewKeyRoute(mongoclient *mongo.Client, ctx context.Context, l logr.Logger, limiter *ratelimit.Bucket, contactService contacts.I_ContactRepo) KeyRoute {
+	Logger = l
+	Logger.V(2).Info("NewKeyRoute created")
+	deviceCollection := mongoclient.Database("pesatu").Collection("devicekeys")
+	prekeyCollection := mongoclient.Database("pesatu").Collection("prekeys")
+	service := NewKeyService(deviceCollection, prekeyCollection, ctx)
+	controller := NewKeyController(service, contactService)
+	return KeyRoute{controller, limiter}
+}
+
+func (me *KeyRoute) InitRouteTo(rg *gin.RouterGroup) {
+	router := rg.Group("/keys")
+	router.POST("/rpc", me.RateLimit, me.RPCHandle)
+}
+
+func (me *KeyRoute) RateLimit(ctx *gin.Context) {
+	// Check if the request is allowed by the rate limiter
+	if me.limiter.TakeAvailable(1) == 0 {
+		// The request is not allowed, so return an error
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	ctx.Next()
+}
+
+func (me *KeyRoute) GetKeyService() I_KeyRepo {
+	return me.controller.keyService
+}
+
+func (me *KeyRoute) RPCHandle(ctx *gin.Context) {
+	var jreq jsonrpc2.RPCRequest
+	if err := ctx.ShouldBindJSON(&jreq); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "jsonrpc fail", "message": err.Error()})
+		return
+	}
+
+	Logger.V(2).Info(fmt.Sprintf("RPCHandle %s", jreq.Method))
+
+	jres := &jsonrpc2.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      jreq.ID,
+	}
+
+	statuscode := http.StatusBadRequest
+	switch jreq.Method {
+	case "PublishKeys":
+		statuscode = me.method_PublishKeys(ctx, &jreq, jres)
+	case "GetBundles":
+		statuscode = me.method_GetBundles(ctx, &jreq, jres)
+	case "GetDevices":
+		statuscode = me.method_GetDevices(ctx, &jreq, jres)
+	case "RemoveDevice":
+		statuscode = me.method_RemoveDevice(ctx, &jreq, jres)
+	default:
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+
+	if jres.Error != nil {
+		Logger.Error(fmt.Errorf(jres.Error.Message), "response with error")
+	}
+	ctx.JSON(statuscode, jres)
+}
+
+func (me *KeyRoute) method_PublishKeys(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *PublishKeysRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.PublishKeys(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *KeyRoute) method_GetBundles(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *GetBundlesRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.GetBundles(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *KeyRoute) method_GetDevices(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *DeviceRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.GetDevices(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *KeyRoute) method_RemoveDevice(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *DeviceRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.RemoveDevice(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
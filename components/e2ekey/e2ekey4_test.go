@@ -0,0 +1,52 @@
+package e2ekey
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.Exit(code)
+}
+
+func Test_CheckKey(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Nil(checkKey(base64.StdEncoding.EncodeToString(make([]byte, 32))))
+	asserts.Nil(checkKey(base64.StdEncoding.EncodeToString(make([]byte, 33))))
+	asserts.NotNil(checkKey(base64.StdEncoding.EncodeToString(make([]byte, 31))))
+	asserts.NotNil(checkKey("not base64!"))
+
+	asserts.Nil(checkSignedPrekey(&SignedPrekey{
+		PublicKey: base64.StdEncoding.EncodeToString(make([]byte, 33)),
+		Signature: base64.StdEncoding.EncodeToString(make([]byte, 64)),
+	}))
+	asserts.NotNil(checkSignedPrekey(&SignedPrekey{
+		PublicKey: base64.StdEncoding.EncodeToString(make([]byte, 33)),
+		Signature: base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	}))
+}
+
+func Test_CheckPrekeys(t *testing.T) {
+	asserts := assert.New(t)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	asserts.Nil(checkPrekeys([]*Prekey{{KeyID: 1, PublicKey: key}, {KeyID: 2, PublicKey: key}}))
+	asserts.NotNil(checkPrekeys([]*Prekey{{KeyID: 1, PublicKey: key}, {KeyID: 1, PublicKey: key}}))
+	asserts.NotNil(checkPrekeys([]*Prekey{nil}))
+}
+
+func Test_CheckDevice(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Nil(CheckDevice("phone-1"))
+	asserts.Nil(CheckDevice("3f2a_b"))
+	asserts.NotNil(CheckDevice(""))
+	asserts.NotNil(CheckDevice("a/b"))
+	asserts.NotNil(CheckDevice(strings.Repeat("a", 65)))
+}
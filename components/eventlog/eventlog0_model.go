@@ -0,0 +1,49 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateEvent is something a user missed while offline, Seq is given by the repository.
+// Events about a message keep its id only, the message is read again when replayed.
+type CreateEvent struct {
+	UID     string          `json:"uid" bson:"uid"`
+	Seq     int64           `json:"seq" bson:"seq"`
+	Action  string          `json:"action" bson:"action"`
+	RoomId  string          `json:"room,omitempty" bson:"room,omitempty"`
+	MsgId   string          `json:"msg_id,omitempty" bson:"msg_id,omitempty"`
+	Actor   string          `json:"actor,omitempty" bson:"actor,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty" bson:"payload,omitempty"`
+	Time    time.Time       `json:"time" bson:"time"`
+}
+
+type DBEvent struct {
+	Id      primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UID     string             `json:"-" bson:"uid"`
+	Seq     int64              `json:"seq" bson:"seq"`
+	Action  string             `json:"action" bson:"action"`
+	RoomId  string             `json:"room,omitempty" bson:"room,omitempty"`
+	MsgId   string             `json:"msg_id,omitempty" bson:"msg_id,omitempty"`
+	Actor   string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	Payload json.RawMessage    `json:"payload,omitempty" bson:"payload,omitempty"`
+	Time    time.Time          `json:"time" bson:"time"`
+}
+
+// DBSeq is the last sequence given to the events of a user, the events up to Floor are compacted
+type DBSeq struct {
+	UID   string `bson:"_id"`
+	Seq   int64  `bson:"seq"`
+	Floor int64  `bson:"floor,omitempty"`
+}
+
+// EventPage holds the events after a sequence, oldest first. Reset tells some events after the sequence
+// were compacted, the client reloads its rooms then goes on from LastSeq.
+type EventPage struct {
+	Events  []*DBEvent `json:"events"`
+	HasMore bool       `json:"has_more"`
+	Reset   bool       `json:"reset,omitempty"`
+	LastSeq int64      `json:"last_seq"`
+}
@@ -0,0 +1,176 @@
+package eventlog
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// max events of a replay page
+const MaxEventPage = 200
+
+type I_EventRepo interface {
+	CreateIndexes() error
+	AddEvents(events []*CreateEvent) error
+	FindEvents(uid string, after int64, limit int) (*EventPage, error)
+	Compact(before time.Time) (int64, error)
+}
+
+type EventRepository struct {
+	eventCollection *mongo.Collection
+	seqCollection   *mongo.Collection
+	ctx             context.Context
+}
+
+func NewEventRepository(eventCollection *mongo.Collection, ctx context.Context) I_EventRepo {
+	seqCollection := eventCollection.Database().Collection("eventseqs")
+	return &EventRepository{eventCollection, seqCollection, ctx}
+}
+
+// AddEvents gives the events the next sequences of their user, in the order they are listed
+func (me *EventRepository) AddEvents(events []*CreateEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	byUser := make(map[string][]*CreateEvent)
+	var uids []string
+	for _, event := range events {
+		if _, found := byUser[event.UID]; !found {
+			uids = append(uids, event.UID)
+		}
+		byUser[event.UID] = append(byUser[event.UID], event)
+	}
+
+	docs := make([]interface{}, 0, len(events))
+	for _, uid := range uids {
+		userEvents := byUser[uid]
+		last, err := me.nextSeq(uid, int64(len(userEvents)))
+		if err != nil {
+			return err
+		}
+
+		first := last - int64(len(userEvents)) + 1
+		for i, event := range userEvents {
+			event.Seq = first + int64(i)
+			docs = append(docs, event)
+		}
+	}
+
+	_, err := me.eventCollection.InsertMany(me.ctx, docs)
+	return err
+}
+
+// nextSeq reserves n sequences for the user and returns the last of them
+func (me *EventRepository) nextSeq(uid string, n int64) (int64, error) {
+	update := bson.M{"$inc": bson.M{"seq": n}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var seq *DBSeq
+	if err := me.seqCollection.FindOneAndUpdate(me.ctx, bson.M{"_id": uid}, update, opts).Decode(&seq); err != nil {
+		return 0, err
+	}
+
+	return seq.Seq, nil
+}
+
+// CreateIndexes backs the replay of a user by sequence and the compaction by time,
+// it is called once at startup
+func (me *EventRepository) CreateIndexes() error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "uid", Value: 1}, {Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "time", Value: 1}}},
+	}
+
+	_, err := me.eventCollection.Indexes().CreateMany(me.ctx, indexes)
+	return err
+}
+
+// FindEvents returns a page of the events of the user after the sequence, a sequence the user
+// has never been given, or which is compacted, resets the page to the oldest event kept
+func (me *EventRepository) FindEvents(uid string, after int64, limit int) (*EventPage, error) {
+	if limit <= 0 || limit > MaxEventPage {
+		limit = MaxEventPage
+	}
+
+	var seq *DBSeq
+	if err := me.seqCollection.FindOne(me.ctx, bson.M{"_id": uid}).Decode(&seq); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &EventPage{Events: []*DBEvent{}, Reset: after > 0}, nil
+		}
+		return nil, err
+	}
+
+	page := &EventPage{LastSeq: seq.Seq}
+	if after < seq.Floor || after > seq.Seq {
+		page.Reset = true
+		after = seq.Floor
+	}
+
+	filter := bson.M{"uid": uid, "seq": bson.M{"$gt": after}}
+	opts := options.Find().SetSort(bson.M{"seq": 1}).SetLimit(int64(limit + 1))
+
+	cursor, err := me.eventCollection.Find(me.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	if err := cursor.All(me.ctx, &page.Events); err != nil {
+		return nil, err
+	}
+
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.HasMore = true
+	}
+
+	if page.HasMore {
+		page.LastSeq = page.Events[len(page.Events)-1].Seq
+	}
+
+	if page.Events == nil {
+		page.Events = []*DBEvent{}
+	}
+
+	return page, nil
+}
+
+// Compact deletes the events older than before, raising the floor of their users
+// so a replay from a deleted sequence is reset
+func (me *EventRepository) Compact(before time.Time) (int64, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"time": bson.M{"$lt": before}}},
+		{"$group": bson.M{"_id": "$uid", "seq": bson.M{"$max": "$seq"}}},
+	}
+
+	cursor, err := me.eventCollection.Aggregate(me.ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var floors []*DBSeq
+	if err := cursor.All(me.ctx, &floors); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, floor := range floors {
+		_, err := me.seqCollection.UpdateOne(me.ctx, bson.M{"_id": floor.UID}, bson.M{"$max": bson.M{"floor": floor.Seq}})
+		if err != nil {
+			return deleted, err
+		}
+
+		res, err := me.eventCollection.DeleteMany(me.ctx, bson.M{"uid": floor.UID, "seq": bson.M{"$lte": floor.Seq}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += res.DeletedCount
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package eventlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// run the repository tests against a local mongod with
+// MONGO_TEST_URL=mongodb://localhost:27017 go test -v ./components/eventlog
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'eventlog'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'eventlog'")
+}
+
+// testDB returns an empty database dropped after the test
+func testDB(t *testing.T) *mongo.Database {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database(fmt.Sprintf("pesatu_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return db
+}
+
+func Test_Compact(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewEventRepository(db.Collection("events"), context.Background())
+	asserts.Nil(repo.CreateIndexes())
+
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	asserts.Nil(repo.AddEvents([]*CreateEvent{
+		{UID: "a", Action: "send-msg", Time: old},
+		{UID: "a", Action: "send-msg", Time: old},
+		{UID: "b", Action: "send-msg", Time: old},
+		{UID: "a", Action: "send-msg", Time: now},
+	}))
+
+	deleted, err := repo.Compact(now.Add(-time.Minute))
+	asserts.Nil(err)
+	asserts.Equal(int64(3), deleted)
+
+	// a replay from a compacted sequence is reset to the oldest event kept
+	page, err := repo.FindEvents("a", 1, 10)
+	asserts.Nil(err)
+	asserts.True(page.Reset)
+	asserts.Len(page.Events, 1)
+	asserts.Equal(int64(3), page.Events[0].Seq)
+	asserts.Equal(int64(3), page.LastSeq)
+
+	// from the floor on, nothing was missed
+	page, err = repo.FindEvents("a", 2, 10)
+	asserts.Nil(err)
+	asserts.False(page.Reset)
+	asserts.Len(page.Events, 1)
+
+	// the sequences go on after a compaction
+	asserts.Nil(repo.AddEvents([]*CreateEvent{{UID: "b", Action: "send-msg", Time: now}}))
+	page, err = repo.FindEvents("b", 0, 10)
+	asserts.Nil(err)
+	asserts.True(page.Reset)
+	asserts.Equal(int64(2), page.Events[0].Seq)
+}
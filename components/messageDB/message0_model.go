@@ -7,28 +7,252 @@ import (
 )
 
 type CreateMessage struct {
-	Action    string    `json:"action" bson:"action"`
-	Message   string    `json:"message" bson:"message"`
-	RoomId    string    `json:"room" bson:"room"`
-	Sender    string    `json:"sender" bson:"sender"`
-	Status    string    `json:"status" bson:"status"`
-	Time      time.Time `json:"time,omitempty" bson:"time,omitempty"`
-	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Action       string              `json:"action" bson:"action"`
+	Message      string              `json:"message" bson:"message"`
+	RoomId       string              `json:"room" bson:"room"`
+	Sender       string              `json:"sender" bson:"sender"`
+	Status       string              `json:"status" bson:"status"`
+	Type         string              `json:"type,omitempty" bson:"type,omitempty"`
+	ReplyTo      *primitive.ObjectID `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	Attachments  []*Attachment       `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	SenderDevice string              `json:"sender_device,omitempty" bson:"sender_device,omitempty"`
+	Envelopes    []*Envelope         `json:"envelopes,omitempty" bson:"envelopes,omitempty"`
+	// a forwarded message keeps the uid of the sender of the original
+	Forwarded     bool   `json:"forwarded,omitempty" bson:"forwarded,omitempty"`
+	ForwardedFrom string `json:"forwarded_from,omitempty" bson:"forwarded_from,omitempty"`
+	// uids of the members mentioned with @username
+	Mentions []string `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	// id given by the sender, a message is saved once per id, and the order in the room set on save
+	ClientId  string     `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	Seq       int64      `json:"seq,omitempty" bson:"seq,omitempty"`
+	Time      time.Time  `json:"time,omitempty" bson:"time,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	ExpireAt  *time.Time `json:"expire_at,omitempty" bson:"expire_at,omitempty"`
+	KeyID     string     `json:"-" bson:"key_id,omitempty"`
+	DataKey   []byte     `json:"-" bson:"data_key,omitempty"`
+	Tokens    []string   `json:"-" bson:"tokens,omitempty"`
+}
+
+// Attachment is a file sent with a message, enough to show it without fetching the file
+type Attachment struct {
+	Id       string `json:"id" bson:"id"`
+	Name     string `json:"name,omitempty" bson:"name"`
+	Size     int64  `json:"size,omitempty" bson:"size"`
+	MimeType string `json:"mime_type,omitempty" bson:"mime_type"`
+	Width    int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height   int    `json:"height,omitempty" bson:"height,omitempty"`
+	// voice notes only, the waveform bars go from 0 to 100
+	Kind     string `json:"kind,omitempty" bson:"kind,omitempty"`
+	Duration int64  `json:"duration_ms,omitempty" bson:"duration_ms,omitempty"`
+	Waveform []int  `json:"waveform,omitempty" bson:"waveform,omitempty"`
+}
+
+// Envelope is the end-to-end encrypted content of a message for one device of a recipient,
+// the server stores and routes it without being able to read it
+type Envelope struct {
+	Username   string `json:"username" bson:"username"`
+	Device     string `json:"device" bson:"device"`
+	Type       int    `json:"type" bson:"type"`
+	Ciphertext string `json:"ciphertext" bson:"ciphertext"`
+}
+
+// type of an envelope, whether the ciphertext starts a session from a prekey bundle or continues one
+const (
+	PrekeyEnvelope  = 1
+	SessionEnvelope = 2
+)
+
+// ForwardRequest copies the messages into the rooms, all of them by id
+type ForwardRequest struct {
+	Messages []string `json:"messages"`
+	Rooms    []string `json:"rooms"`
+}
+
+// MessageEdit is a previous content of an edited message
+type MessageEdit struct {
+	Message  string    `json:"message" bson:"message"`
+	EditedAt time.Time `json:"edited_at" bson:"edited_at"`
+}
+
+// MessageQuote is the parent of a reply, as shown above the reply
+type MessageQuote struct {
+	Id      primitive.ObjectID `json:"id" bson:"_id"`
+	Action  string             `json:"action" bson:"action"`
+	Message string             `json:"message" bson:"message"`
+	Sender  string             `json:"sender" bson:"sender"`
+	Deleted bool               `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	KeyID   string             `json:"-" bson:"key_id,omitempty"`
+	DataKey []byte             `json:"-" bson:"data_key,omitempty"`
+}
+
+// MessageQuery is a page of a room history, anchored on at most one of Before, After or Around.
+// A cursor is a message id or an RFC3339 time, without any the latest messages are returned.
+type MessageQuery struct {
+	Before         string `json:"before,omitempty"`
+	After          string `json:"after,omitempty"`
+	Around         string `json:"around,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+	ExcludeReplies bool   `json:"exclude_replies,omitempty"`
+	// set by the server to the history cutoff of the member, the messages up to it are hidden
+	ClearedUpTo *primitive.ObjectID `json:"-"`
+}
+
+// MessagePage holds messages newest first, HasMoreBefore is set for the latest, before and around pages,
+// HasMoreAfter for the after and around pages
+type MessagePage struct {
+	Messages      []*DBMessage `json:"messages"`
+	HasMoreBefore bool         `json:"has_more_before"`
+	HasMoreAfter  bool         `json:"has_more_after"`
+}
+
+// SearchRequest finds messages of the rooms of the user by text, Sender is a username,
+// From and To are RFC3339 times and Before is the next_cursor of the previous page
+type SearchRequest struct {
+	UID    string `json:"uid"`
+	Query  string `json:"query"`
+	RoomID string `json:"room_id,omitempty"`
+	Sender string `json:"sender,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Before string `json:"before,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// SearchFilter is a SearchRequest resolved to ids
+type SearchFilter struct {
+	RoomIds []string
+	UserId  string
+	Text    string
+	// the words of Text, searched as tokens when messages are encrypted
+	Terms    []string
+	SenderId string
+	From     *time.Time
+	To       *time.Time
+	Before   *primitive.ObjectID
+	Limit    int
+	// history cutoffs of the user by room id, the messages up to them are not searched
+	ClearedUpTo map[string]primitive.ObjectID
+}
+
+// Highlight is a match in a snippet, from Start to End in runes
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type SearchResult struct {
+	Message    *DBMessage   `json:"message"`
+	Snippet    string       `json:"snippet"`
+	Highlights []*Highlight `json:"highlights"`
+}
+
+type SearchPage struct {
+	Results    []*SearchResult `json:"results"`
+	HasMore    bool            `json:"has_more"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Thread is a message with its replies
+type Thread struct {
+	Parent  *DBMessage   `json:"parent"`
+	Replies []*DBMessage `json:"replies"`
 }
 
 type DBMessage struct {
-	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Action    string             `json:"action" bson:"action"`
-	Message   string             `json:"message" bson:"message"`
-	RoomId    string             `json:"room" bson:"room"`
-	Sender    string             `json:"sender" bson:"sender"`
-	Status    string             `json:"status" bson:"status"`
-	Time      time.Time          `json:"time,omitempty" bson:"time,omitempty"`
-	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Id           primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	Action       string              `json:"action" bson:"action"`
+	Message      string              `json:"message" bson:"message"`
+	RoomId       string              `json:"room" bson:"room"`
+	Sender       string              `json:"sender" bson:"sender"`
+	Status       string              `json:"status" bson:"status"`
+	Type         string              `json:"type,omitempty" bson:"type,omitempty"`
+	ReplyTo      *primitive.ObjectID `json:"reply_to,omitempty" bson:"reply_to,omitempty"`
+	ReplyCount   int                 `json:"reply_count,omitempty" bson:"reply_count,omitempty"`
+	Attachments  []*Attachment       `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	SenderDevice string              `json:"sender_device,omitempty" bson:"sender_device,omitempty"`
+	Envelopes    []*Envelope         `json:"envelopes,omitempty" bson:"envelopes,omitempty"`
+	// ForwardedFrom is the username of the sender of the original
+	Forwarded     bool   `json:"forwarded,omitempty" bson:"forwarded,omitempty"`
+	ForwardedFrom string `json:"forwarded_from,omitempty" bson:"forwarded_from,omitempty"`
+	// usernames of the members mentioned
+	Mentions   []string       `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	ClientId   string         `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	Seq        int64          `json:"seq,omitempty" bson:"seq,omitempty"`
+	Quote      *MessageQuote  `json:"reply_to_msg,omitempty" bson:"reply_to_msg,omitempty"`
+	Time       time.Time      `json:"time,omitempty" bson:"time,omitempty"`
+	UpdatedAt  time.Time      `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	ExpireAt   *time.Time     `json:"expire_at,omitempty" bson:"expire_at,omitempty"`
+	EditedAt   *time.Time     `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+	Edits      []*MessageEdit `json:"edits,omitempty" bson:"edits,omitempty"`
+	Deleted    bool           `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	DeletedFor []string       `json:"-" bson:"deleted_for,omitempty"`
+	Reactions  []*Reaction    `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	Receipts   []*Receipt     `json:"receipts,omitempty" bson:"receipts,omitempty"`
+	// encryption at rest, see the keyring package
+	KeyID   string `json:"-" bson:"key_id,omitempty"`
+	DataKey []byte `json:"-" bson:"data_key,omitempty"`
+}
+
+// Reaction is the count and usernames of one emoji on a message
+type Reaction struct {
+	Emoji string   `json:"emoji" bson:"emoji"`
+	Count int      `json:"count" bson:"count"`
+	Users []string `json:"users" bson:"users"`
+}
+
+type DBReaction struct {
+	Id     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	MsgId  primitive.ObjectID `json:"msg_id" bson:"msg_id"`
+	RoomId string             `json:"room" bson:"room"`
+	UserId string             `json:"usr_id" bson:"usr_id"`
+	Emoji  string             `json:"emoji" bson:"emoji"`
+	Time   time.Time          `json:"time" bson:"time"`
+}
+
+// Receipt tells when a member other than the sender received and read a message
+type Receipt struct {
+	Username    string     `json:"username" bson:"username"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty" bson:"read_at,omitempty"`
+}
+
+type DBReceipt struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	MsgId       primitive.ObjectID `json:"msg_id" bson:"msg_id"`
+	RoomId      string             `json:"room" bson:"room"`
+	UserId      string             `json:"usr_id" bson:"usr_id"`
+	DeliveredAt *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	ReadAt      *time.Time         `json:"read_at,omitempty" bson:"read_at,omitempty"`
+}
+
+type DBPin struct {
+	Id     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	MsgId  primitive.ObjectID `json:"msg_id" bson:"msg_id"`
+	RoomId string             `json:"room" bson:"room"`
+	UserId string             `json:"usr_id" bson:"usr_id"`
+	Time   time.Time          `json:"time" bson:"time"`
+}
+
+// PinnedMessage is a pinned message with who pinned it and when, the latest pin first
+type PinnedMessage struct {
+	Message  *DBMessage `json:"message"`
+	PinnedBy string     `json:"pinned_by"`
+	PinnedAt time.Time  `json:"pinned_at"`
+}
+
+type PinnedRequest struct {
+	UID    string `json:"uid"`
+	RoomID string `json:"room_id"`
 }
 
+// DelvMessage is a saved message as acked to the sender, ClientId maps it to the message the
+// client shows until then. A Duplicate was saved before under the same client id, it is the saved one.
 type DelvMessage struct {
 	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ClientId  string             `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	Seq       int64              `json:"seq,omitempty" bson:"seq,omitempty"`
 	Time      string             `json:"time,omitempty" bson:"time,omitempty"`
 	UpdatedAt string             `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	ExpireAt  string             `json:"expire_at,omitempty" bson:"expire_at,omitempty"`
+	Duplicate bool               `json:"-" bson:"-"`
 }
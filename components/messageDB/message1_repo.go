@@ -4,75 +4,170 @@ import (
 	"context"
 	"fmt"
 	"pesatu/components/user"
+	"pesatu/keyring"
 	"pesatu/utils"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MessageRepository struct {
 	user.I_UserRepo
-	msgCollection *mongo.Collection
-	ctx           context.Context
+	msgCollection      *mongo.Collection
+	reactionCollection *mongo.Collection
+	receiptCollection  *mongo.Collection
+	pinCollection      *mongo.Collection
+	// the number of pins of every room, so the pin limit holds with concurrent pins
+	pinCountCollection *mongo.Collection
+	seqCollection      *mongo.Collection
+	ctx                context.Context
 }
 
+const (
+	// max messages marked by one receipt, older ones are left to the member mark
+	maxReceiptBatch = 500
+
+	// max messages of a history page
+	MaxMessagePage = 100
+)
+
 type I_MessageRepo interface {
 	user.I_UserRepo
 	GetMsgCollection() *mongo.Collection
+	CreateIndexes() error
 	AddMessages(messages []*CreateMessage) ([]*DelvMessage, error)
 	AddMessage(message *CreateMessage) (*DBMessage, error)
-	FindMessagesByRoom(roomId string, page, limit int) ([]*DBMessage, error)
+	FindSentMessage(roomId, sender, clientId string) (*DelvMessage, error)
+	FindMessageById(msgId string) (*DBMessage, error)
+	FindMessagesByCursor(roomId, userId string, query *MessageQuery) (*MessagePage, error)
+	FindThreadMessages(parentId primitive.ObjectID, userId string, clearedUpTo *primitive.ObjectID, page, limit int) ([]*DBMessage, error)
+	FindMessagesByIds(ids []primitive.ObjectID, userId string) ([]*DBMessage, error)
+	SearchMessages(filter *SearchFilter) ([]*DBMessage, bool, error)
 	FindMessagesByRoomName(roomName string, page, limit int) ([]*DBMessage, error)
 	RemoveMessage(msgId string) error
 	RemoveMessages(msgIds []string) error
 	UpdateStatus(msgId []*primitive.ObjectID, status string) error
+	EditMessage(msg *DBMessage, newMessage string) (*DBMessage, error)
+	DeleteMessageForAll(msgId primitive.ObjectID) (*DBMessage, error)
+	DeleteMessageForUser(msgId primitive.ObjectID, userId string) error
+	IncReplyCount(parentId primitive.ObjectID, n int) error
+	AddReaction(msg *DBMessage, userId, emoji string) error
+	RemoveReaction(msgId primitive.ObjectID, userId, emoji string) error
+	AddReceipts(roomId, userId string, after *primitive.ObjectID, upTo primitive.ObjectID, read bool) ([]*primitive.ObjectID, error)
+	RotateKeys(batch int) (int, error)
+	TakeExpiredMessages(now time.Time, limit int) ([]*DBMessage, error)
+	FindUsedAttachments(ids []string) ([]string, error)
+	PinMessage(msg *DBMessage, userId string, max int) error
+	UnpinMessage(msg *DBMessage) error
+	FindPinnedMessages(roomId, userId string, clearedUpTo *primitive.ObjectID) ([]*PinnedMessage, error)
 }
 
 func NewMsgRepository(userCollection, msgCollection *mongo.Collection, ctx context.Context) I_MessageRepo {
 	userService := user.NewUserService(userCollection, ctx)
-	return &MessageRepository{userService, msgCollection, ctx}
+	reactionCollection := msgCollection.Database().Collection("reactions")
+	receiptCollection := msgCollection.Database().Collection("receipts")
+	pinCollection := msgCollection.Database().Collection("pins")
+	pinCountCollection := msgCollection.Database().Collection("roompins")
+	seqCollection := msgCollection.Database().Collection("roomseqs")
+	return &MessageRepository{userService, msgCollection, reactionCollection, receiptCollection, pinCollection, pinCountCollection, seqCollection, ctx}
 }
 
 func (me *MessageRepository) GetMsgCollection() *mongo.Collection {
 	return me.msgCollection
 }
 
+// AddMessages saves the messages in order, each one with the next sequence of its room, and returns
+// one DelvMessage per message. A message with a client id saved before is not saved again,
+// its DelvMessage is the saved one flagged Duplicate.
 func (me *MessageRepository) AddMessages(messages []*CreateMessage) ([]*DelvMessage, error) {
-	docs := make([]interface{}, 0)
+	res := make([]*DelvMessage, len(messages))
+	if err := me.findDuplicates(messages, res); err != nil {
+		return nil, err
+	}
+
+	var pending []int
 	for i := range messages {
+		if res[i] == nil {
+			pending = append(pending, i)
+		}
+	}
+
+	if len(pending) == 0 {
+		return res, nil
+	}
+
+	if err := me.assignSeqs(messages, pending); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(pending))
+	docs := make([]interface{}, 0, len(pending))
+	for n, i := range pending {
 		messages[i].UpdatedAt = time.Now()
-		doc, err := utils.ToDoc(messages[i])
+		sealed, err := sealMessage(messages[i])
+		if err != nil {
+			return nil, err
+		}
+		doc, err := utils.ToDoc(sealed)
 		if err != nil {
 			return nil, err
 		}
+		ids[n] = primitive.NewObjectID()
+		*doc = append(bson.D{{Key: "_id", Value: ids[n]}}, *doc...)
 		docs = append(docs, doc)
 	}
 
-	res, err := me.msgCollection.InsertMany(me.ctx, docs)
+	// a client id saved by a concurrent batch fails alone, it is then resolved as a duplicate
+	failed := make(map[int]bool)
+	_, err := me.msgCollection.InsertMany(me.ctx, docs, options.InsertMany().SetOrdered(false))
 	if err != nil {
-		return nil, err
+		er, ok := err.(mongo.BulkWriteException)
+		if !ok || er.WriteConcernError != nil {
+			return nil, err
+		}
+		for _, we := range er.WriteErrors {
+			if we.Code != 11000 {
+				return nil, err
+			}
+			failed[we.Index] = true
+		}
 	}
 
-	var insertedDocs []*DelvMessage // replace MyStruct with your struct type
-	for _, id := range res.InsertedIDs {
-		var doc *DBMessage
-		err := me.msgCollection.FindOne(me.ctx, bson.M{"_id": id}).Decode(&doc)
-		if err != nil {
+	for n, i := range pending {
+		if !failed[n] {
+			res[i] = newDelvMessage(ids[n], messages[i])
+		}
+	}
+
+	if len(failed) > 0 {
+		if err := me.findDuplicates(messages, res); err != nil {
 			return nil, err
 		}
-		insertedDocs = append(insertedDocs, &DelvMessage{
-			Id: doc.Id, Time: doc.Time.Format("2006-01-02T15:04:05.000Z"),
-			UpdatedAt: doc.UpdatedAt.Format("2006-01-02T15:04:05.000Z")})
+		for _, delv := range res {
+			if delv == nil {
+				return nil, fmt.Errorf("duplicate message unavailable")
+			}
+		}
 	}
 
-	return insertedDocs, nil
+	return res, nil
 }
 
 func (me *MessageRepository) AddMessage(message *CreateMessage) (*DBMessage, error) {
+	if err := me.assignSeqs([]*CreateMessage{message}, []int{0}); err != nil {
+		return nil, err
+	}
+
 	message.UpdatedAt = time.Now()
-	doc, err := utils.ToDoc(message)
+	sealed, err := sealMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := utils.ToDoc(sealed)
 	if err != nil {
 		return nil, err
 	}
@@ -88,10 +183,253 @@ func (me *MessageRepository) AddMessage(message *CreateMessage) (*DBMessage, err
 		return nil, err
 	}
 
+	if err := openMessage(msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func (me *MessageRepository) FindMessageById(msgId string) (*DBMessage, error) {
+	objectID, err := primitive.ObjectIDFromHex(msgId)
+	if err != nil {
+		return nil, fmt.Errorf("error creating ObjectID: %s", err.Error())
+	}
+
+	var msg *DBMessage
+	if err := me.msgCollection.FindOne(me.ctx, bson.M{"_id": objectID}).Decode(&msg); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("message unavailable")
+		}
+		return nil, err
+	}
+
+	if err := openMessage(msg); err != nil {
+		return nil, err
+	}
+
 	return msg, nil
 }
 
-func (me *MessageRepository) FindMessagesByRoom(roomId string, page, limit int) ([]*DBMessage, error) {
+// CreateIndexes creates the indexes of the messages, reactions, receipts and pins, once when the server starts,
+// a write never fails on them. An index failing does not keep the others from being created.
+func (me *MessageRepository) CreateIndexes() error {
+	failed := me.createMessageIndexes()
+
+	unique := options.Index().SetUnique(true)
+	collections := []struct {
+		collection *mongo.Collection
+		index      mongo.IndexModel
+	}{
+		{me.reactionCollection, mongo.IndexModel{Keys: bson.D{{Key: "msg_id", Value: 1}, {Key: "usr_id", Value: 1}, {Key: "emoji", Value: 1}}, Options: unique}},
+		{me.receiptCollection, mongo.IndexModel{Keys: bson.D{{Key: "msg_id", Value: 1}, {Key: "usr_id", Value: 1}}, Options: unique}},
+		{me.pinCollection, mongo.IndexModel{Keys: bson.D{{Key: "room", Value: 1}, {Key: "msg_id", Value: 1}}, Options: unique}},
+	}
+
+	for _, c := range collections {
+		if _, err := c.collection.Indexes().CreateOne(me.ctx, c.index); err != nil && failed == nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
+
+// createMessageIndexes backs the history pages of a room and of a thread, both sorted by time then id,
+// the text search, without stemming so words of any language match as typed, the search tokens
+// of encrypted messages, the key rotation, the sweep of disappearing messages and the client ids
+// a message is saved once for
+func (me *MessageRepository) createMessageIndexes() error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "room", Value: 1}, {Key: "time", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "reply_to", Value: 1}, {Key: "time", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "message", Value: "text"}}, Options: options.Index().SetDefaultLanguage("none")},
+		{Keys: bson.D{{Key: "tokens", Value: 1}}},
+		{Keys: bson.D{{Key: "key_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "room", Value: 1}, {Key: "sender", Value: 1}, {Key: "client_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"client_id": bson.M{"$type": "string"}}),
+		},
+	}
+
+	_, err := me.msgCollection.Indexes().CreateMany(me.ctx, indexes)
+	return err
+}
+
+// resolveCursor turns a message id of the room or an RFC3339 time into a time and id anchor
+func (me *MessageRepository) resolveCursor(roomId, cursor string) (time.Time, primitive.ObjectID, error) {
+	if objectID, err := primitive.ObjectIDFromHex(cursor); err == nil {
+		var msg *DBMessage
+		if err := me.msgCollection.FindOne(me.ctx, bson.M{"_id": objectID, "room": roomId}).Decode(&msg); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return time.Time{}, primitive.NilObjectID, fmt.Errorf("cursor message unavailable")
+			}
+			return time.Time{}, primitive.NilObjectID, err
+		}
+		return msg.Time, msg.Id, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	return t, primitive.NilObjectID, nil
+}
+
+// cursorMatch limits match to the messages older (order -1) or newer (order 1) than the anchor
+func cursorMatch(match bson.M, t time.Time, id primitive.ObjectID, order int, inclusive bool) bson.M {
+	op := "$gt"
+	if order < 0 {
+		op = "$lt"
+	}
+
+	idOp := op
+	if inclusive {
+		idOp += "e"
+	}
+
+	match["$or"] = []bson.M{
+		{"time": bson.M{op: t}},
+		{"time": t, "_id": bson.M{idOp: id}},
+	}
+
+	return match
+}
+
+func reverseMessages(msgs []*DBMessage) {
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+}
+
+// findPage returns up to limit messages in order from the anchor and whether there are more
+func (me *MessageRepository) findPage(match bson.M, order, limit int) ([]*DBMessage, bool, error) {
+	msgs, err := me.findMessages(match, bson.D{{Key: "time", Value: order}, {Key: "_id", Value: order}}, 0, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(msgs) > limit
+	if more {
+		msgs = msgs[:limit]
+	}
+
+	return msgs, more, nil
+}
+
+// FindMessagesByCursor returns a page of messages of the room, except the ones userId has deleted for themselves.
+// Paging by cursor instead of skip keeps pages stable while new messages arrive.
+func (me *MessageRepository) FindMessagesByCursor(roomId, userId string, query *MessageQuery) (*MessagePage, error) {
+	cursors := 0
+	for _, c := range []string{query.Before, query.After, query.Around} {
+		if len(c) > 0 {
+			cursors++
+		}
+	}
+
+	if cursors > 1 {
+		return nil, fmt.Errorf("only one of before, after or around is allowed")
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	if limit > MaxMessagePage {
+		limit = MaxMessagePage
+	}
+
+	match := func() bson.M {
+		m := bson.M{
+			"room":        roomId,
+			"deleted_for": bson.M{"$ne": userId},
+		}
+		if query.ExcludeReplies {
+			m["reply_to"] = bson.M{"$exists": false}
+		}
+		if query.ClearedUpTo != nil {
+			m["_id"] = bson.M{"$gt": *query.ClearedUpTo}
+		}
+		return m
+	}
+
+	page := &MessagePage{}
+	var err error
+
+	switch {
+	case len(query.Before) > 0:
+		t, id, e := me.resolveCursor(roomId, query.Before)
+		if e != nil {
+			return nil, e
+		}
+		page.Messages, page.HasMoreBefore, err = me.findPage(cursorMatch(match(), t, id, -1, false), -1, limit)
+
+	case len(query.After) > 0:
+		t, id, e := me.resolveCursor(roomId, query.After)
+		if e != nil {
+			return nil, e
+		}
+		page.Messages, page.HasMoreAfter, err = me.findPage(cursorMatch(match(), t, id, 1, false), 1, limit)
+		reverseMessages(page.Messages)
+
+	case len(query.Around) > 0:
+		t, id, e := me.resolveCursor(roomId, query.Around)
+		if e != nil {
+			return nil, e
+		}
+
+		// the anchor itself is in the older half
+		var newer []*DBMessage
+		newer, page.HasMoreAfter, err = me.findPage(cursorMatch(match(), t, id, 1, false), 1, limit/2)
+		if err != nil {
+			return nil, err
+		}
+		reverseMessages(newer)
+
+		var older []*DBMessage
+		older, page.HasMoreBefore, err = me.findPage(cursorMatch(match(), t, id, -1, true), -1, limit-len(newer))
+		page.Messages = append(newer, older...)
+
+	default:
+		page.Messages, page.HasMoreBefore, err = me.findPage(match(), -1, limit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// FindMessagesByIds returns the messages of the ids as shown in a history page, except the ones
+// userId has deleted for themselves, the missing and expired ones are left out
+func (me *MessageRepository) FindMessagesByIds(ids []primitive.ObjectID, userId string) ([]*DBMessage, error) {
+	if len(ids) == 0 {
+		return []*DBMessage{}, nil
+	}
+
+	match := bson.M{
+		"_id":         bson.M{"$in": ids},
+		"deleted_for": bson.M{"$ne": userId},
+	}
+
+	return me.findMessages(match, bson.D{{Key: "_id", Value: 1}}, 0, len(ids))
+}
+
+// FindThreadMessages returns the replies of a message, without the ones up to the history cutoff of userId
+func (me *MessageRepository) FindThreadMessages(parentId primitive.ObjectID, userId string, clearedUpTo *primitive.ObjectID, page, limit int) ([]*DBMessage, error) {
+	match := bson.M{
+		"reply_to":    parentId,
+		"deleted_for": bson.M{"$ne": userId},
+	}
+	if clearedUpTo != nil {
+		match["_id"] = bson.M{"$gt": *clearedUpTo}
+	}
+
 	if page == 0 {
 		page = 1
 	}
@@ -102,10 +440,91 @@ func (me *MessageRepository) FindMessagesByRoom(roomId string, page, limit int)
 
 	skip := (page - 1) * limit
 
+	return me.findMessages(match, bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}, skip, limit)
+}
+
+// searchRooms matches the messages of filter.RoomIds, the rooms the user cleared after their cutoff only
+func searchRooms(filter *SearchFilter) bson.M {
+	var rooms []string
+	var cleared []bson.M
+	for _, id := range filter.RoomIds {
+		if mark, ok := filter.ClearedUpTo[id]; ok {
+			cleared = append(cleared, bson.M{"room": id, "_id": bson.M{"$gt": mark}})
+		} else {
+			rooms = append(rooms, id)
+		}
+	}
+
+	if len(cleared) == 0 {
+		return bson.M{"room": bson.M{"$in": rooms}}
+	}
+
+	return bson.M{"$or": append(cleared, bson.M{"room": bson.M{"$in": rooms}})}
+}
+
+// SearchMessages finds messages by text in filter.RoomIds, newest first, and tells whether there are more
+func (me *MessageRepository) SearchMessages(filter *SearchFilter) ([]*DBMessage, bool, error) {
+	match := searchRooms(filter)
+	match["deleted"] = bson.M{"$ne": true}
+	match["deleted_for"] = bson.M{"$ne": filter.UserId}
+
+	// encrypted messages are matched by the tokens of their words, run RotateKeys once
+	// after enabling encryption so the messages saved before have tokens too
+	if ring := keyring.Get(); ring != nil {
+		var words []string
+		for _, t := range filter.Terms {
+			words = append(words, keyring.Words(t)...)
+		}
+		if len(words) == 0 {
+			return []*DBMessage{}, false, nil
+		}
+		match["tokens"] = bson.M{"$all": ring.Tokens(words)}
+	} else {
+		match["$text"] = bson.M{"$search": filter.Text}
+	}
+
+	if len(filter.SenderId) > 0 {
+		match["sender"] = filter.SenderId
+	}
+
+	if filter.From != nil || filter.To != nil {
+		t := bson.M{}
+		if filter.From != nil {
+			t["$gte"] = *filter.From
+		}
+		if filter.To != nil {
+			t["$lte"] = *filter.To
+		}
+		match["time"] = t
+	}
+
+	// ids grow with insertion, a text match can not be paged by an $or of time and id
+	if filter.Before != nil {
+		match["_id"] = bson.M{"$lt": *filter.Before}
+	}
+
+	msgs, err := me.findMessages(match, bson.D{{Key: "_id", Value: -1}}, 0, filter.Limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(msgs) > filter.Limit
+	if more {
+		msgs = msgs[:filter.Limit]
+	}
+
+	return msgs, more, nil
+}
+
+func (me *MessageRepository) findMessages(match bson.M, sort bson.D, skip, limit int) ([]*DBMessage, error) {
+	// disappearing messages are hidden as soon as they expire, before they are swept
+	match["expire_at"] = bson.M{"$not": bson.M{"$lte": time.Now()}}
+
 	pipeline := []bson.M{
-		{"$match": bson.M{
-			"room": roomId,
-		}},
+		{"$match": match},
+		{"$sort": sort},
+		{"$skip": skip},
+		{"$limit": limit},
 		{"$lookup": bson.M{
 			"from":         "users",
 			"localField":   "sender",
@@ -117,14 +536,112 @@ func (me *MessageRepository) FindMessagesByRoom(roomId string, page, limit int)
 				"$arrayElemAt": []interface{}{"$sender_user.username", 0},
 			},
 		}},
-		{"$project": bson.M{
-			"sender_user": 0,
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "forwarded_from",
+			"foreignField": "uid",
+			"as":           "forwarded_user",
 		}},
-		{"$sort": bson.M{
-			"time": -1,
+		{"$addFields": bson.M{
+			"forwarded_from": bson.M{
+				"$arrayElemAt": []interface{}{"$forwarded_user.username", 0},
+			},
+		}},
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "mentions",
+			"foreignField": "uid",
+			"as":           "mentioned_users",
+		}},
+		{"$addFields": bson.M{
+			"mentions": "$mentioned_users.username",
+		}},
+		// quoted parent of a reply
+		{"$lookup": bson.M{
+			"from": "messages",
+			"let":  bson.M{"reply_to": "$reply_to"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$_id", "$$reply_to"}}}},
+				{"$lookup": bson.M{
+					"from":         "users",
+					"localField":   "sender",
+					"foreignField": "uid",
+					"as":           "sender_user",
+				}},
+				{"$project": bson.M{
+					"_id":      1,
+					"action":   1,
+					"message":  1,
+					"deleted":  1,
+					"key_id":   1,
+					"data_key": 1,
+					"sender": bson.M{
+						"$arrayElemAt": []interface{}{"$sender_user.username", 0},
+					},
+				}},
+			},
+			"as": "reply_to_msg",
+		}},
+		{"$unwind": bson.M{
+			"path":                       "$reply_to_msg",
+			"preserveNullAndEmptyArrays": true,
+		}},
+		// reactions grouped by emoji, in the order they were first used
+		{"$lookup": bson.M{
+			"from": "reactions",
+			"let":  bson.M{"msg_id": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$msg_id", "$$msg_id"}}}},
+				{"$sort": bson.M{"time": 1}},
+				{"$lookup": bson.M{
+					"from":         "users",
+					"localField":   "usr_id",
+					"foreignField": "uid",
+					"as":           "user",
+				}},
+				{"$group": bson.M{
+					"_id":   "$emoji",
+					"count": bson.M{"$sum": 1},
+					"users": bson.M{"$push": bson.M{"$arrayElemAt": []interface{}{"$user.username", 0}}},
+					"first": bson.M{"$min": "$time"},
+				}},
+				{"$sort": bson.M{"first": 1}},
+				{"$project": bson.M{
+					"_id":   0,
+					"emoji": "$_id",
+					"count": 1,
+					"users": 1,
+				}},
+			},
+			"as": "reactions",
+		}},
+		// who has received and read the message
+		{"$lookup": bson.M{
+			"from": "receipts",
+			"let":  bson.M{"msg_id": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": []interface{}{"$msg_id", "$$msg_id"}}}},
+				{"$sort": bson.M{"delivered_at": 1}},
+				{"$lookup": bson.M{
+					"from":         "users",
+					"localField":   "usr_id",
+					"foreignField": "uid",
+					"as":           "user",
+				}},
+				{"$project": bson.M{
+					"_id":          0,
+					"username":     bson.M{"$arrayElemAt": []interface{}{"$user.username", 0}},
+					"delivered_at": 1,
+					"read_at":      1,
+				}},
+			},
+			"as": "receipts",
+		}},
+		{"$project": bson.M{
+			"sender_user":     0,
+			"forwarded_user":  0,
+			"mentioned_users": 0,
 		}},
-		{"$skip": skip},
-		{"$limit": limit},
 	}
 
 	cursor, err := me.msgCollection.Aggregate(me.ctx, pipeline)
@@ -134,21 +651,16 @@ func (me *MessageRepository) FindMessagesByRoom(roomId string, page, limit int)
 	defer cursor.Close(me.ctx)
 
 	var results []*DBMessage
-	// for cursor.Next(me.ctx) {
-	// 	rr := &DBMessage{}
-	// 	err := cursor.Decode(rr)
-
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	results = append(results, rr)
-	// }
 	cursor.All(me.ctx, &results)
 
 	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
 
+	if err := openMessages(results); err != nil {
+		return nil, err
+	}
+
 	if len(results) == 0 {
 		return []*DBMessage{}, nil
 	}
@@ -205,6 +717,10 @@ func (me *MessageRepository) FindMessagesByRoomName(roomName string, page, limit
 		return nil, err
 	}
 
+	if err := openMessages(results); err != nil {
+		return nil, err
+	}
+
 	if len(results) == 0 {
 		return []*DBMessage{}, nil
 	}
@@ -257,3 +773,409 @@ func (me *MessageRepository) UpdateStatus(msgId []*primitive.ObjectID, status st
 
 	return nil
 }
+
+// EditMessage replaces the content of msg and keeps the previous one in its edit history,
+// it fails when the message was changed since msg was read
+func (me *MessageRepository) EditMessage(msg *DBMessage, newMessage string) (*DBMessage, error) {
+	now := time.Now()
+	filter := bson.M{"_id": msg.Id, "deleted": bson.M{"$ne": true}, "edited_at": bson.M{"$exists": false}}
+	if msg.EditedAt != nil {
+		filter["edited_at"] = *msg.EditedAt
+	}
+
+	// the history is written whole, an encrypted message gets a new data key on every edit
+	edits := append(append([]*MessageEdit{}, msg.Edits...), &MessageEdit{Message: msg.Message, EditedAt: now})
+	set, err := sealContent(newMessage, edits)
+	if err != nil {
+		return nil, err
+	}
+	set["edited_at"] = now
+	set["updated_at"] = now
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var edited *DBMessage
+	if err := me.msgCollection.FindOneAndUpdate(me.ctx, filter, bson.M{"$set": set}, opts).Decode(&edited); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("message has been changed")
+		}
+		return nil, err
+	}
+
+	if err := openMessage(edited); err != nil {
+		return nil, err
+	}
+
+	return edited, nil
+}
+
+// DeleteMessageForAll leaves a tombstone in place of the message, content, attachments and edit history are removed
+func (me *MessageRepository) DeleteMessageForAll(msgId primitive.ObjectID) (*DBMessage, error) {
+	update := bson.M{
+		"$set":   bson.M{"message": "", "deleted": true, "updated_at": time.Now()},
+		"$unset": bson.M{"edits": "", "edited_at": "", "attachments": "", "envelopes": "", "key_id": "", "data_key": "", "tokens": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var deleted *DBMessage
+	if err := me.msgCollection.FindOneAndUpdate(me.ctx, bson.M{"_id": msgId}, update, opts).Decode(&deleted); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("message unavailable")
+		}
+		return nil, err
+	}
+
+	if _, err := me.reactionCollection.DeleteMany(me.ctx, bson.M{"msg_id": msgId}); err != nil {
+		return nil, err
+	}
+
+	if err := me.unpinMessages(deleted.RoomId, []primitive.ObjectID{msgId}); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteMessageForUser hides the message from userId only
+func (me *MessageRepository) DeleteMessageForUser(msgId primitive.ObjectID, userId string) error {
+	update := bson.M{"$addToSet": bson.M{"deleted_for": userId}}
+
+	res, err := me.msgCollection.UpdateOne(me.ctx, bson.M{"_id": msgId}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("message unavailable")
+	}
+
+	return nil
+}
+
+func (me *MessageRepository) IncReplyCount(parentId primitive.ObjectID, n int) error {
+	update := bson.M{"$inc": bson.M{"reply_count": n}}
+
+	_, err := me.msgCollection.UpdateOne(me.ctx, bson.M{"_id": parentId}, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddReaction adds an emoji of userId to msg, once per user and emoji
+func (me *MessageRepository) AddReaction(msg *DBMessage, userId, emoji string) error {
+	filter := bson.M{"msg_id": msg.Id, "usr_id": userId, "emoji": emoji}
+	reaction := &DBReaction{MsgId: msg.Id, RoomId: msg.RoomId, UserId: userId, Emoji: emoji, Time: time.Now()}
+	update := bson.M{"$setOnInsert": reaction}
+	opts := options.Update().SetUpsert(true)
+
+	_, err := me.reactionCollection.UpdateOne(me.ctx, filter, update, opts)
+	return err
+}
+
+func (me *MessageRepository) RemoveReaction(msgId primitive.ObjectID, userId, emoji string) error {
+	filter := bson.M{"msg_id": msgId, "usr_id": userId, "emoji": emoji}
+
+	res, err := me.reactionCollection.DeleteOne(me.ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("reaction unavailable")
+	}
+
+	return nil
+}
+
+// AddReceipts marks the messages of the room after the after mark and up to upTo as delivered to userId,
+// and as read too when read, except its own messages. It returns the ids of the marked messages.
+func (me *MessageRepository) AddReceipts(roomId, userId string, after *primitive.ObjectID, upTo primitive.ObjectID, read bool) ([]*primitive.ObjectID, error) {
+	ids := bson.M{"$lte": upTo}
+	if after != nil {
+		ids["$gt"] = *after
+	}
+	filter := bson.M{"room": roomId, "_id": ids, "sender": bson.M{"$ne": userId}}
+
+	opt := options.Find()
+	opt.SetSort(bson.M{"_id": -1})
+	opt.SetLimit(maxReceiptBatch)
+	opt.SetProjection(bson.M{"_id": 1})
+
+	cursor, err := me.msgCollection.Find(me.ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var msgs []*DBMessage
+	if err := cursor.All(me.ctx, &msgs); err != nil {
+		return nil, err
+	}
+
+	if len(msgs) == 0 {
+		return []*primitive.ObjectID{}, nil
+	}
+
+	// $min keeps the first time, a message read without a delivery is delivered at the same time
+	now := time.Now()
+	marks := bson.M{"delivered_at": now}
+	if read {
+		marks["read_at"] = now
+	}
+
+	var models []mongo.WriteModel
+	var marked []*primitive.ObjectID
+	for _, msg := range msgs {
+		id := msg.Id
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"msg_id": id, "usr_id": userId}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{"msg_id": id, "room": roomId, "usr_id": userId},
+				"$min":         marks,
+			}).
+			SetUpsert(true))
+		marked = append(marked, &id)
+	}
+
+	if _, err := me.receiptCollection.BulkWrite(me.ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return nil, err
+	}
+
+	return marked, nil
+}
+
+// TakeExpiredMessages deletes up to limit messages expired at now, with their reactions, receipts and pins,
+// and returns them. Each message is taken by one caller only, so every node may sweep.
+func (me *MessageRepository) TakeExpiredMessages(now time.Time, limit int) ([]*DBMessage, error) {
+	filter := bson.M{"expire_at": bson.M{"$lte": now}}
+	opts := options.FindOneAndDelete().SetSort(bson.M{"expire_at": 1})
+
+	var msgs []*DBMessage
+	var ids []primitive.ObjectID
+	byRoom := make(map[string][]primitive.ObjectID)
+	for len(msgs) < limit {
+		var msg *DBMessage
+		if err := me.msgCollection.FindOneAndDelete(me.ctx, filter, opts).Decode(&msg); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+		ids = append(ids, msg.Id)
+		byRoom[msg.RoomId] = append(byRoom[msg.RoomId], msg.Id)
+	}
+
+	if len(ids) == 0 {
+		return msgs, nil
+	}
+
+	if _, err := me.reactionCollection.DeleteMany(me.ctx, bson.M{"msg_id": bson.M{"$in": ids}}); err != nil {
+		return msgs, err
+	}
+
+	if _, err := me.receiptCollection.DeleteMany(me.ctx, bson.M{"msg_id": bson.M{"$in": ids}}); err != nil {
+		return msgs, err
+	}
+
+	for roomId, roomIds := range byRoom {
+		if err := me.unpinMessages(roomId, roomIds); err != nil {
+			return msgs, err
+		}
+	}
+
+	return msgs, nil
+}
+
+// FindUsedAttachments returns which of the attachment ids are still sent with a message
+func (me *MessageRepository) FindUsedAttachments(ids []string) ([]string, error) {
+	values, err := me.msgCollection.Distinct(me.ctx, "attachments.id", bson.M{"attachments.id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var used []string
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			used = append(used, id)
+		}
+	}
+
+	return used, nil
+}
+
+// PinMessage pins msg in its room unless the room already has max pins, the pin is counted
+// before it is saved so concurrent pins can not go over max
+func (me *MessageRepository) PinMessage(msg *DBMessage, userId string, max int) error {
+	ok, err := me.reservePin(msg.RoomId, max)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("room can not have more than %d pinned messages", max)
+	}
+
+	pin := &DBPin{MsgId: msg.Id, RoomId: msg.RoomId, UserId: userId, Time: time.Now()}
+	if _, err := me.pinCollection.InsertOne(me.ctx, pin); err != nil {
+		if er := me.releasePins(msg.RoomId, 1); er != nil {
+			utils.Log().Error(er, "error while releasing pin count of room "+msg.RoomId)
+		}
+
+		if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
+			return fmt.Errorf("message already pinned")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// reservePin counts one more pin in the room unless it has max already. The count of a room
+// pinned before it was counted starts from its pins.
+func (me *MessageRepository) reservePin(roomId string, max int) (bool, error) {
+	filter := bson.M{"_id": roomId, "pins": bson.M{"$lt": max}}
+	update := bson.M{"$inc": bson.M{"pins": 1}}
+
+	res, err := me.pinCountCollection.UpdateOne(me.ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	if res.MatchedCount > 0 {
+		return true, nil
+	}
+
+	count, err := me.pinCountCollection.CountDocuments(me.ctx, bson.M{"_id": roomId})
+	if err != nil {
+		return false, err
+	}
+
+	// counted and full
+	if count > 0 {
+		return false, nil
+	}
+
+	pins, err := me.pinCollection.CountDocuments(me.ctx, bson.M{"room": roomId})
+	if err != nil {
+		return false, err
+	}
+
+	// a concurrent pin may count the room first
+	if _, err := me.pinCountCollection.InsertOne(me.ctx, bson.M{"_id": roomId, "pins": pins}); err != nil {
+		if er, ok := err.(mongo.WriteException); !ok || er.WriteErrors[0].Code != 11000 {
+			return false, err
+		}
+	}
+
+	res, err = me.pinCountCollection.UpdateOne(me.ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+func (me *MessageRepository) releasePins(roomId string, n int64) error {
+	_, err := me.pinCountCollection.UpdateOne(me.ctx, bson.M{"_id": roomId}, bson.M{"$inc": bson.M{"pins": -n}})
+	return err
+}
+
+// unpinMessages deletes the pins of the messages of a room, and releases them from its count
+func (me *MessageRepository) unpinMessages(roomId string, ids []primitive.ObjectID) error {
+	res, err := me.pinCollection.DeleteMany(me.ctx, bson.M{"room": roomId, "msg_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return nil
+	}
+
+	return me.releasePins(roomId, res.DeletedCount)
+}
+
+func (me *MessageRepository) UnpinMessage(msg *DBMessage) error {
+	res, err := me.pinCollection.DeleteOne(me.ctx, bson.M{"room": msg.RoomId, "msg_id": msg.Id})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return fmt.Errorf("message is not pinned")
+	}
+
+	return me.releasePins(msg.RoomId, 1)
+}
+
+// FindPinnedMessages returns the pinned messages of a room userId has not deleted for themself
+// and sent after their history cutoff, the latest pin first
+func (me *MessageRepository) FindPinnedMessages(roomId, userId string, clearedUpTo *primitive.ObjectID) ([]*PinnedMessage, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"room": roomId}},
+		{"$sort": bson.M{"time": -1}},
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "usr_id",
+			"foreignField": "uid",
+			"as":           "user",
+		}},
+		{"$project": bson.M{
+			"msg_id":   1,
+			"time":     1,
+			"username": bson.M{"$arrayElemAt": []interface{}{"$user.username", 0}},
+		}},
+	}
+
+	cursor, err := me.pinCollection.Aggregate(me.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var pins []struct {
+		MsgId    primitive.ObjectID `bson:"msg_id"`
+		Time     time.Time          `bson:"time"`
+		Username string             `bson:"username"`
+	}
+	if err := cursor.All(me.ctx, &pins); err != nil {
+		return nil, err
+	}
+
+	if len(pins) == 0 {
+		return []*PinnedMessage{}, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(pins))
+	for i, pin := range pins {
+		ids[i] = pin.MsgId
+	}
+
+	match := bson.M{
+		"_id":         bson.M{"$in": ids},
+		"deleted_for": bson.M{"$ne": userId},
+	}
+	if clearedUpTo != nil {
+		match["_id"] = bson.M{"$in": ids, "$gt": *clearedUpTo}
+	}
+	msgs, err := me.findMessages(match, bson.D{{Key: "_id", Value: -1}}, 0, len(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[primitive.ObjectID]*DBMessage)
+	for _, msg := range msgs {
+		byId[msg.Id] = msg
+	}
+
+	pinned := []*PinnedMessage{}
+	for _, pin := range pins {
+		if msg, ok := byId[pin.MsgId]; ok {
+			pinned = append(pinned, &PinnedMessage{Message: msg, PinnedBy: pin.Username, PinnedAt: pin.Time})
+		}
+	}
+
+	return pinned, nil
+}
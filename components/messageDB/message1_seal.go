@@ -0,0 +1,200 @@
+package messageDB
+
+import (
+	"fmt"
+	"pesatu/keyring"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// name of the encrypted field, the edits share it since they are previous contents
+const sealedField = "message"
+
+// sealMessage returns a copy of msg with its content encrypted when encryption at rest is enabled
+func sealMessage(msg *CreateMessage) (*CreateMessage, error) {
+	ring := keyring.Get()
+	if ring == nil {
+		return msg, nil
+	}
+
+	dk, err := ring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := *msg
+	if sealed.Message, err = dk.Seal(sealedField, msg.Message); err != nil {
+		return nil, err
+	}
+	sealed.KeyID, sealed.DataKey = dk.KeyID, dk.Wrapped
+	sealed.Tokens = ring.Tokens(keyring.Words(msg.Message))
+
+	return &sealed, nil
+}
+
+// sealContent returns the fields to $set for a content and its edit history, under a new data key
+// when encryption at rest is enabled
+func sealContent(message string, edits []*MessageEdit) (bson.M, error) {
+	set := bson.M{"message": message}
+	if len(edits) > 0 {
+		set["edits"] = edits
+	}
+
+	ring := keyring.Get()
+	if ring == nil {
+		return set, nil
+	}
+
+	dk, err := ring.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sealedEdits := make([]*MessageEdit, 0, len(edits))
+	for _, e := range edits {
+		m, err := dk.Seal(sealedField, e.Message)
+		if err != nil {
+			return nil, err
+		}
+		sealedEdits = append(sealedEdits, &MessageEdit{Message: m, EditedAt: e.EditedAt})
+	}
+
+	if set["message"], err = dk.Seal(sealedField, message); err != nil {
+		return nil, err
+	}
+	if len(edits) > 0 {
+		set["edits"] = sealedEdits
+	}
+	set["key_id"] = dk.KeyID
+	set["data_key"] = dk.Wrapped
+	set["tokens"] = ring.Tokens(keyring.Words(message))
+
+	return set, nil
+}
+
+// openMessage decrypts a message read from the database, with its edits and quote, in place
+func openMessage(msg *DBMessage) error {
+	if len(msg.KeyID) > 0 {
+		ring := keyring.Get()
+		if ring == nil {
+			return fmt.Errorf("message is encrypted but no encryption key is configured")
+		}
+
+		dk, err := ring.OpenDataKey(msg.KeyID, msg.DataKey)
+		if err != nil {
+			return err
+		}
+
+		if msg.Message, err = dk.Open(sealedField, msg.Message); err != nil {
+			return err
+		}
+
+		for _, e := range msg.Edits {
+			if e.Message, err = dk.Open(sealedField, e.Message); err != nil {
+				return err
+			}
+		}
+	}
+
+	if msg.Quote != nil {
+		m, err := keyring.OpenField(msg.Quote.KeyID, msg.Quote.DataKey, sealedField, msg.Quote.Message)
+		if err != nil {
+			return err
+		}
+		msg.Quote.Message = m
+	}
+
+	return nil
+}
+
+func openMessages(msgs []*DBMessage) error {
+	for _, msg := range msgs {
+		if err := openMessage(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unchangedSince matches msg as it was read with the key keyID, edits and deletes move updated_at,
+// a plain message has no key id
+func unchangedSince(msg *DBMessage, keyID string) bson.M {
+	current := bson.M{
+		"_id":        msg.Id,
+		"key_id":     bson.M{"$exists": false},
+		"edited_at":  bson.M{"$exists": false},
+		"updated_at": bson.M{"$exists": false},
+	}
+	if len(keyID) > 0 {
+		current["key_id"] = keyID
+	}
+	if msg.EditedAt != nil {
+		current["edited_at"] = *msg.EditedAt
+	}
+	if !msg.UpdatedAt.IsZero() {
+		current["updated_at"] = msg.UpdatedAt
+	}
+
+	return current
+}
+
+// RotateKeys re-encrypts messages with the active master key, batch by batch, including the ones
+// saved in plain text before encryption was enabled. It returns how many messages were updated.
+// A message edited meanwhile is skipped here since the edit already encrypted it with the active key,
+// one deleted or otherwise updated meanwhile is left for the next run.
+func (me *MessageRepository) RotateKeys(batch int) (int, error) {
+	ring := keyring.Get()
+	if ring == nil {
+		return 0, fmt.Errorf("encryption at rest is disabled")
+	}
+
+	if err := me.createMessageIndexes(); err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{"key_id": bson.M{"$ne": ring.Active()}}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(batch))
+
+	total := 0
+	var last interface{}
+	for {
+		if last != nil {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+
+		cursor, err := me.msgCollection.Find(me.ctx, filter, opts)
+		if err != nil {
+			return total, err
+		}
+
+		var msgs []*DBMessage
+		if err := cursor.All(me.ctx, &msgs); err != nil {
+			return total, err
+		}
+
+		for _, msg := range msgs {
+			keyID := msg.KeyID
+			if err := openMessage(msg); err != nil {
+				return total, fmt.Errorf("message %s: %s", msg.Id.Hex(), err.Error())
+			}
+
+			set, err := sealContent(msg.Message, msg.Edits)
+			if err != nil {
+				return total, err
+			}
+
+			res, err := me.msgCollection.UpdateOne(me.ctx, unchangedSince(msg, keyID), bson.M{"$set": set})
+			if err != nil {
+				return total, err
+			}
+			total += int(res.ModifiedCount)
+		}
+
+		if len(msgs) < batch {
+			return total, nil
+		}
+		last = msgs[len(msgs)-1].Id
+	}
+}
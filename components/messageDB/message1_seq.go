@@ -0,0 +1,124 @@
+package messageDB
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const delvTimeFormat = "2006-01-02T15:04:05.000Z"
+
+type DBRoomSeq struct {
+	RoomId string `bson:"_id"`
+	Seq    int64  `bson:"seq"`
+}
+
+// assignSeqs gives the messages at indexes the next sequences of their rooms, in order.
+// The sequences are reserved on every node alike, a message which fails to save leaves a gap.
+func (me *MessageRepository) assignSeqs(messages []*CreateMessage, indexes []int) error {
+	counts := make(map[string]int64)
+	var rooms []string
+	for _, i := range indexes {
+		if counts[messages[i].RoomId] == 0 {
+			rooms = append(rooms, messages[i].RoomId)
+		}
+		counts[messages[i].RoomId]++
+	}
+
+	next := make(map[string]int64)
+	for _, roomId := range rooms {
+		update := bson.M{"$inc": bson.M{"seq": counts[roomId]}}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+		var seq *DBRoomSeq
+		if err := me.seqCollection.FindOneAndUpdate(me.ctx, bson.M{"_id": roomId}, update, opts).Decode(&seq); err != nil {
+			return err
+		}
+		next[roomId] = seq.Seq - counts[roomId] + 1
+	}
+
+	for _, i := range indexes {
+		messages[i].Seq = next[messages[i].RoomId]
+		next[messages[i].RoomId]++
+	}
+
+	return nil
+}
+
+// findDuplicates sets res of the messages without one whose client id is saved already
+func (me *MessageRepository) findDuplicates(messages []*CreateMessage, res []*DelvMessage) error {
+	key := func(roomId, sender, clientId string) string {
+		return roomId + "/" + sender + "/" + clientId
+	}
+
+	var or []bson.M
+	for i, msg := range messages {
+		if res[i] == nil && len(msg.ClientId) > 0 {
+			or = append(or, bson.M{"room": msg.RoomId, "sender": msg.Sender, "client_id": msg.ClientId})
+		}
+	}
+
+	if len(or) == 0 {
+		return nil
+	}
+
+	projection := bson.M{"room": 1, "sender": 1, "client_id": 1, "seq": 1, "time": 1, "updated_at": 1, "expire_at": 1}
+	cursor, err := me.msgCollection.Find(me.ctx, bson.M{"$or": or}, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+
+	var saved []*DBMessage
+	if err := cursor.All(me.ctx, &saved); err != nil {
+		return err
+	}
+
+	found := make(map[string]*DBMessage)
+	for _, msg := range saved {
+		found[key(msg.RoomId, msg.Sender, msg.ClientId)] = msg
+	}
+
+	for i, msg := range messages {
+		if res[i] != nil || len(msg.ClientId) == 0 {
+			continue
+		}
+		if dup, ok := found[key(msg.RoomId, msg.Sender, msg.ClientId)]; ok {
+			res[i] = &DelvMessage{
+				Id:        dup.Id,
+				ClientId:  dup.ClientId,
+				Seq:       dup.Seq,
+				Time:      dup.Time.UTC().Format(delvTimeFormat),
+				UpdatedAt: dup.UpdatedAt.UTC().Format(delvTimeFormat),
+				Duplicate: true,
+			}
+			if dup.ExpireAt != nil {
+				res[i].ExpireAt = dup.ExpireAt.UTC().Format(delvTimeFormat)
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindSentMessage returns the delv of the message sender sent to the room with clientId,
+// nil when it is not saved
+func (me *MessageRepository) FindSentMessage(roomId, sender, clientId string) (*DelvMessage, error) {
+	res := make([]*DelvMessage, 1)
+	err := me.findDuplicates([]*CreateMessage{{RoomId: roomId, Sender: sender, ClientId: clientId}}, res)
+	return res[0], err
+}
+
+func newDelvMessage(id primitive.ObjectID, msg *CreateMessage) *DelvMessage {
+	delv := &DelvMessage{
+		Id:        id,
+		ClientId:  msg.ClientId,
+		Seq:       msg.Seq,
+		Time:      msg.Time.UTC().Format(delvTimeFormat),
+		UpdatedAt: msg.UpdatedAt.UTC().Format(delvTimeFormat),
+	}
+	if msg.ExpireAt != nil {
+		delv.ExpireAt = msg.ExpireAt.UTC().Format(delvTimeFormat)
+	}
+
+	return delv
+}
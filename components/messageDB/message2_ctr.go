@@ -0,0 +1,296 @@
+package messageDB
+
+import (
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/roommember"
+	"pesatu/jsonrpc2"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// max rooms of a user looked in by a search
+	maxSearchRooms = 1000
+
+	// max results of a search page
+	MaxSearchPage = 50
+
+	// runes of a message shown around the first match
+	snippetRadius = 60
+)
+
+// max pinned messages of a room
+var maxPins = 50
+
+// SetMaxPins sets the max pinned messages of a room
+func SetMaxPins(n int) {
+	if n > 0 {
+		maxPins = n
+	}
+}
+
+type MessageController struct {
+	msgService    I_MessageRepo
+	memberService roommember.I_RoomMember
+}
+
+func NewMessageController(msgService I_MessageRepo, memberService roommember.I_RoomMember) MessageController {
+	return MessageController{msgService, memberService}
+}
+
+func (me *MessageController) SearchMessages(validuser *auth.Claims, o *SearchRequest) (*SearchPage, *jsonrpc2.RPCError, int) {
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	return me.Search(validuser.GetUID(), o)
+}
+
+// Search finds messages by text in the rooms uid is a member of, the websocket search-msg uses it too
+func (me *MessageController) Search(uid string, o *SearchRequest) (*SearchPage, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("search messages %q by %s", o.Query, uid))
+
+	terms := searchTerms(o.Query)
+	if len(terms) == 0 {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "query can not empty"}, http.StatusOK
+	}
+
+	filter := &SearchFilter{UserId: uid, Text: textSearch(terms), Terms: terms, Limit: o.Limit}
+	if filter.Limit <= 0 || filter.Limit > MaxSearchPage {
+		filter.Limit = MaxSearchPage
+	}
+
+	rooms, err := me.findRoomIds(uid)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if len(o.RoomID) > 0 {
+		if !contains(rooms, o.RoomID) {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this room"}, http.StatusOK
+		}
+		rooms = []string{o.RoomID}
+	}
+
+	if len(rooms) == 0 {
+		return &SearchPage{Results: []*SearchResult{}}, nil, http.StatusOK
+	}
+	filter.RoomIds = rooms
+
+	if filter.ClearedUpTo, err = me.memberService.FindClearedMarks(uid); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if len(o.Sender) > 0 {
+		sender, err := me.msgService.FindUserByUsername(o.Sender)
+		if err != nil {
+			return &SearchPage{Results: []*SearchResult{}}, nil, http.StatusOK
+		}
+		filter.SenderId = sender.UID
+	}
+
+	if filter.From, err = parseTime(o.From); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid from, " + err.Error()}, http.StatusOK
+	}
+
+	if filter.To, err = parseTime(o.To); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid to, " + err.Error()}, http.StatusOK
+	}
+
+	if len(o.Before) > 0 {
+		before, err := primitive.ObjectIDFromHex(o.Before)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid cursor"}, http.StatusOK
+		}
+		filter.Before = &before
+	}
+
+	msgs, more, err := me.msgService.SearchMessages(filter)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	page := &SearchPage{Results: make([]*SearchResult, 0, len(msgs)), HasMore: more}
+	for _, msg := range msgs {
+		snippet, highlights := highlight(msg.Message, terms)
+		page.Results = append(page.Results, &SearchResult{Message: msg, Snippet: snippet, Highlights: highlights})
+	}
+
+	if more {
+		page.NextCursor = msgs[len(msgs)-1].Id.Hex()
+	}
+
+	return page, nil, http.StatusOK
+}
+
+// Pin pins or unpins msg by uid, every member may in private rooms, only the owner and admins in groups.
+// The websocket pin and unpin use it.
+func (me *MessageController) Pin(uid string, msg *DBMessage, pin bool) *jsonrpc2.RPCError {
+	r, err := me.memberService.FindRoomByUID(msg.RoomId)
+	if err != nil {
+		return &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	member, err := me.memberService.FindMember(msg.RoomId, uid)
+	if err != nil {
+		return &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this room"}
+	}
+
+	if r.GetGroup() && member.Role != roommember.Owner && member.Role != roommember.Admin {
+		return &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "only owner and admins can pin messages"}
+	}
+
+	if !pin {
+		err = me.msgService.UnpinMessage(msg)
+	} else if msg.Deleted {
+		err = fmt.Errorf("message has been deleted")
+	} else {
+		err = me.msgService.PinMessage(msg, uid, maxPins)
+	}
+
+	if err != nil {
+		return &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (me *MessageController) GetPinned(validuser *auth.Claims, o *PinnedRequest) ([]*PinnedMessage, *jsonrpc2.RPCError, int) {
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	member, err := me.memberService.FindMember(o.RoomID, o.UID)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this room"}, http.StatusOK
+	}
+
+	pinned, err := me.msgService.FindPinnedMessages(o.RoomID, o.UID, member.ClearedUpTo)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return pinned, nil, http.StatusOK
+}
+
+func (me *MessageController) findRoomIds(uid string) ([]string, error) {
+	var ids []string
+	for page := 1; len(ids) < maxSearchRooms; page++ {
+		rooms, err := me.memberService.FindRoomByMemberID(uid, page, 100)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range rooms {
+			ids = append(ids, r.GetId())
+		}
+
+		if len(rooms) < 100 {
+			break
+		}
+	}
+
+	return ids, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseTime(s string) (*time.Time, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// searchTerms splits a query into lower case words, quotes are ignored
+func searchTerms(query string) []string {
+	var terms []string
+	for _, f := range strings.Fields(strings.ToLower(query)) {
+		f = strings.Trim(f, "\"")
+		if len(f) > 0 && !contains(terms, f) {
+			terms = append(terms, f)
+		}
+	}
+
+	return terms
+}
+
+// textSearch builds a $text search, words with punctuation like links and addresses are searched as phrases
+func textSearch(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		if strings.IndexFunc(t, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+			parts = append(parts, "\""+t+"\"")
+			continue
+		}
+		parts = append(parts, t)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// highlight cuts the message around the first match and returns where the terms are in the snippet
+func highlight(message string, terms []string) (string, []*Highlight) {
+	runes := []rune(message)
+	lower := []rune(strings.ToLower(message))
+
+	// lower casing may change the length of some runes, then matches are not highlighted
+	if len(lower) != len(runes) {
+		lower = nil
+	}
+
+	first := -1
+	var matches []*Highlight
+	for i := 0; lower != nil && i < len(lower); i++ {
+		for _, t := range terms {
+			term := []rune(t)
+			if i+len(term) > len(lower) || string(lower[i:i+len(term)]) != t {
+				continue
+			}
+			if first < 0 {
+				first = i
+			}
+			matches = append(matches, &Highlight{Start: i, End: i + len(term)})
+			i += len(term) - 1
+			break
+		}
+	}
+
+	start := 0
+	if first > snippetRadius {
+		start = first - snippetRadius
+	}
+
+	end := len(runes)
+	if end > start+2*snippetRadius {
+		end = start + 2*snippetRadius
+	}
+
+	highlights := []*Highlight{}
+	for _, m := range matches {
+		if m.Start >= start && m.End <= end {
+			highlights = append(highlights, &Highlight{Start: m.Start - start, End: m.End - start})
+		}
+	}
+
+	return string(runes[start:end]), highlights
+}
@@ -0,0 +1,136 @@
+package messageDB
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/roommember"
+	"pesatu/components/user"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/juju/ratelimit"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var Logger logr.Logger = logr.Discard()
+
+type MessageRoute struct {
+	controller MessageController
+	limiter    *ratelimit.Bucket
+}
+
+func NewMessageRoute(mongoclient *mongo.Client, ctx context.Context, l logr.Logger, limiter *ratelimit.Bucket, userService user.I_UserRepo) MessageRoute {
+	Logger = l
+	Logger.V(2).Info("NewMessageRoute created")
+	msgCollection := mongoclient.Database("pesatu").Collection("messages")
+	roomCollection := mongoclient.Database("pesatu").Collection("rooms")
+	memberCollection := mongoclient.Database("pesatu").Collection("roommembers")
+	msgService := NewMsgRepository(userService.GetCollection(), msgCollection, ctx)
+	memberService := roommember.NewRoomMemberService(roomCollection, memberCollection, ctx)
+	controller := NewMessageController(msgService, memberService)
+	return MessageRoute{controller, limiter}
+}
+
+func (me *MessageRoute) InitRouteTo(rg *gin.RouterGroup) {
+	router := rg.Group("/msg")
+	router.POST("/rpc", me.RateLimit, me.RPCHandle)
+}
+
+func (me *MessageRoute) RateLimit(ctx *gin.Context) {
+	// Check if the request is allowed by the rate limiter
+	if me.limiter.TakeAvailable(1) == 0 {
+		// The request is not allowed, so return an error
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	ctx.Next()
+}
+
+func (me *MessageRoute) RPCHandle(ctx *gin.Context) {
+	var jreq jsonrpc2.RPCRequest
+	if err := ctx.ShouldBindJSON(&jreq); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "jsonrpc fail", "message": err.Error()})
+		return
+	}
+
+	Logger.V(2).Info(fmt.Sprintf("RPCHandle %s", jreq.Method))
+
+	jres := &jsonrpc2.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      jreq.ID,
+	}
+
+	statuscode := http.StatusBadRequest
+	switch jreq.Method {
+	case "SearchMessages":
+		statuscode = me.method_SearchMessages(ctx, &jreq, jres)
+	case "GetPinned":
+		statuscode = me.method_GetPinned(ctx, &jreq, jres)
+	default:
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+
+	if jres.Error != nil {
+		Logger.Error(fmt.Errorf(jres.Error.Message), "response with error")
+	}
+	ctx.JSON(statuscode, jres)
+}
+
+func (me *MessageRoute) method_SearchMessages(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *SearchRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.SearchMessages(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *MessageRoute) method_GetPinned(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *PinnedRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.GetPinned(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
@@ -0,0 +1,298 @@
+package messageDB
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'messageDB'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'messageDB'")
+}
+
+// testDB returns an empty database dropped after the test, run the repository tests with
+// MONGO_TEST_URL=mongodb://localhost:27017 go test -v ./components/messageDB
+func testDB(t *testing.T) *mongo.Database {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database(fmt.Sprintf("pesatu_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return db
+}
+
+func Test_SearchTerms(t *testing.T) {
+	asserts := assert.New(t)
+	terms := searchTerms(`Meet at "Jl. Sudirman" meet`)
+	asserts.Equal([]string{"meet", "at", "jl.", "sudirman"}, terms)
+	asserts.Equal(`meet at "jl." sudirman`, textSearch(terms))
+	asserts.Equal(`"https://example.com/a"`, textSearch(searchTerms("https://example.com/a")))
+}
+
+func Test_Highlight(t *testing.T) {
+	asserts := assert.New(t)
+
+	snippet, highlights := highlight("Link: https://Example.com ok", []string{"example.com"})
+	asserts.Equal("Link: https://Example.com ok", snippet)
+	asserts.Equal([]*Highlight{{Start: 14, End: 25}}, highlights)
+
+	long := strings.Repeat("a ", 100) + "kopi " + strings.Repeat("b ", 100)
+	snippet, highlights = highlight(long, []string{"kopi"})
+	asserts.Equal(2*snippetRadius, len([]rune(snippet)))
+	asserts.Equal([]*Highlight{{Start: snippetRadius, End: snippetRadius + 4}}, highlights)
+
+	snippet, highlights = highlight("nothing here", []string{"kopi"})
+	asserts.Equal("nothing here", snippet)
+	asserts.Empty(highlights)
+}
+
+func Test_SearchRooms(t *testing.T) {
+	asserts := assert.New(t)
+	mark := primitive.NewObjectID()
+
+	asserts.Equal(bson.M{"room": bson.M{"$in": []string{"a", "b"}}}, searchRooms(&SearchFilter{RoomIds: []string{"a", "b"}}))
+	asserts.Equal(bson.M{"$or": []bson.M{
+		{"room": "b", "_id": bson.M{"$gt": mark}},
+		{"room": bson.M{"$in": []string{"a"}}},
+	}}, searchRooms(&SearchFilter{RoomIds: []string{"a", "b"}, ClearedUpTo: map[string]primitive.ObjectID{"b": mark}}))
+}
+
+func Test_UnchangedSince(t *testing.T) {
+	asserts := assert.New(t)
+	msg := &DBMessage{Id: primitive.NewObjectID()}
+
+	// a plain message never edited nor updated must still be so
+	asserts.Equal(bson.M{
+		"_id":        msg.Id,
+		"key_id":     bson.M{"$exists": false},
+		"edited_at":  bson.M{"$exists": false},
+		"updated_at": bson.M{"$exists": false},
+	}, unchangedSince(msg, ""))
+
+	edited := time.Now()
+	msg.EditedAt = &edited
+	msg.UpdatedAt = edited
+	asserts.Equal(bson.M{"_id": msg.Id, "key_id": "k1", "edited_at": edited, "updated_at": edited}, unchangedSince(msg, "k1"))
+}
+
+func Test_ClearedHistory(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewMsgRepository(db.Collection("users"), db.Collection("messages"), context.Background())
+
+	save := func(text string, replyTo *primitive.ObjectID) *DBMessage {
+		msg := &DBMessage{Id: primitive.NewObjectID(), Message: text, RoomId: "room", Sender: "a", ReplyTo: replyTo, Time: time.Now()}
+		_, err := repo.GetMsgCollection().InsertOne(context.Background(), msg)
+		asserts.Nil(err)
+		return msg
+	}
+
+	parent := save("parent", nil)
+	old := save("old reply", &parent.Id)
+	asserts.Nil(repo.PinMessage(old, "a", 10))
+	cutoff := old.Id
+	recent := save("new reply", &parent.Id)
+	asserts.Nil(repo.PinMessage(recent, "a", 10))
+
+	// the messages up to the cutoff are hidden, those after it are not
+	replies, err := repo.FindThreadMessages(parent.Id, "b", &cutoff, 1, 10)
+	asserts.Nil(err)
+	asserts.Len(replies, 1)
+	asserts.Equal(recent.Id, replies[0].Id)
+
+	replies, err = repo.FindThreadMessages(parent.Id, "b", nil, 1, 10)
+	asserts.Nil(err)
+	asserts.Len(replies, 2)
+
+	pinned, err := repo.FindPinnedMessages("room", "b", &cutoff)
+	asserts.Nil(err)
+	asserts.Len(pinned, 1)
+	asserts.Equal(recent.Id, pinned[0].Message.Id)
+}
+
+func Test_CreateIndexes(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewMsgRepository(db.Collection("users"), db.Collection("messages"), context.Background())
+	asserts.Nil(repo.CreateIndexes())
+
+	// the unique indexes are there before the first write
+	msg := &DBMessage{Id: primitive.NewObjectID(), RoomId: "room"}
+	asserts.Nil(repo.PinMessage(msg, "a", 10))
+	asserts.EqualError(repo.PinMessage(msg, "a", 10), "message already pinned")
+}
+
+func Test_PinLimit(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewMsgRepository(db.Collection("users"), db.Collection("messages"), context.Background())
+
+	first := &DBMessage{Id: primitive.NewObjectID(), RoomId: "room"}
+	second := &DBMessage{Id: primitive.NewObjectID(), RoomId: "room"}
+	asserts.Nil(repo.PinMessage(first, "a", 1))
+	asserts.EqualError(repo.PinMessage(second, "a", 1), "room can not have more than 1 pinned messages")
+
+	// the limit is per room, an unpin makes room for another one
+	asserts.Nil(repo.PinMessage(&DBMessage{Id: primitive.NewObjectID(), RoomId: "other"}, "a", 1))
+	asserts.Nil(repo.UnpinMessage(first))
+	asserts.Nil(repo.PinMessage(second, "a", 1))
+	asserts.EqualError(repo.UnpinMessage(first), "message is not pinned")
+
+	// concurrent pins never go over the limit
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.PinMessage(&DBMessage{Id: primitive.NewObjectID(), RoomId: "busy"}, "a", 3)
+		}()
+	}
+	wg.Wait()
+
+	count, err := db.Collection("pins").CountDocuments(context.Background(), bson.M{"room": "busy"})
+	asserts.Nil(err)
+	asserts.Equal(int64(3), count)
+
+	// a room pinned before its pins were counted starts from them
+	_, err = db.Collection("pins").InsertOne(context.Background(), &DBPin{MsgId: primitive.NewObjectID(), RoomId: "old"})
+	asserts.Nil(err)
+	asserts.NotNil(repo.PinMessage(&DBMessage{Id: primitive.NewObjectID(), RoomId: "old"}, "a", 1))
+}
+
+func Test_TakeExpiredMessages(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewMsgRepository(db.Collection("users"), db.Collection("messages"), context.Background())
+
+	now := time.Now()
+	save := func(expireAt *time.Time) *DBMessage {
+		msg := &DBMessage{Id: primitive.NewObjectID(), RoomId: "room", Sender: "a", Time: now, ExpireAt: expireAt}
+		_, err := repo.GetMsgCollection().InsertOne(context.Background(), msg)
+		asserts.Nil(err)
+		return msg
+	}
+
+	past, later := now.Add(-time.Minute), now.Add(time.Hour)
+	expired := []*DBMessage{save(&past), save(&past), save(&past)}
+	pending := save(&later)
+	kept := save(nil)
+	asserts.Nil(repo.PinMessage(expired[0], "a", 10))
+	asserts.Nil(repo.AddReaction(expired[0], "a", "👍"))
+
+	// taken in batches, a message once only
+	msgs, err := repo.TakeExpiredMessages(now, 2)
+	asserts.Nil(err)
+	asserts.Len(msgs, 2)
+	msgs, err = repo.TakeExpiredMessages(now, 2)
+	asserts.Nil(err)
+	asserts.Len(msgs, 1)
+	msgs, err = repo.TakeExpiredMessages(now, 2)
+	asserts.Nil(err)
+	asserts.Empty(msgs)
+
+	// the others are kept, the pins and reactions of the expired ones are gone
+	for _, msg := range []*DBMessage{pending, kept} {
+		_, err := repo.FindMessageById(msg.Id.Hex())
+		asserts.Nil(err)
+	}
+	_, err = repo.FindMessageById(expired[0].Id.Hex())
+	asserts.NotNil(err)
+
+	for _, name := range []string{"pins", "reactions"} {
+		count, err := db.Collection(name).CountDocuments(context.Background(), bson.M{"msg_id": expired[0].Id})
+		asserts.Nil(err)
+		asserts.Zero(count)
+	}
+}
+
+func Test_CursorMatch(t *testing.T) {
+	asserts := assert.New(t)
+	at := time.Now()
+	id := primitive.NewObjectID()
+
+	// the messages at the same time are ordered by id
+	asserts.Equal(bson.M{"room": "room", "$or": []bson.M{
+		{"time": bson.M{"$lt": at}},
+		{"time": at, "_id": bson.M{"$lt": id}},
+	}}, cursorMatch(bson.M{"room": "room"}, at, id, -1, false))
+	asserts.Equal(bson.M{"$or": []bson.M{
+		{"time": bson.M{"$gt": at}},
+		{"time": at, "_id": bson.M{"$gte": id}},
+	}}, cursorMatch(bson.M{}, at, id, 1, true))
+}
+
+func Test_FindMessagesByCursor(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewMsgRepository(db.Collection("users"), db.Collection("messages"), context.Background())
+
+	start := time.Now().Add(-time.Hour)
+	var msgs []*DBMessage
+	for i := 0; i < 5; i++ {
+		msg := &DBMessage{Id: primitive.NewObjectID(), Message: fmt.Sprint(i), RoomId: "room", Sender: "a", Time: start.Add(time.Duration(i) * time.Minute)}
+		_, err := repo.GetMsgCollection().InsertOne(context.Background(), msg)
+		asserts.Nil(err)
+		msgs = append(msgs, msg)
+	}
+
+	find := func(query *MessageQuery) ([]string, bool, bool) {
+		query.Limit = 2
+		page, err := repo.FindMessagesByCursor("room", "b", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, msg := range page.Messages {
+			texts = append(texts, msg.Message)
+		}
+		return texts, page.HasMoreBefore, page.HasMoreAfter
+	}
+
+	texts, before, _ := find(&MessageQuery{})
+	asserts.Equal([]string{"4", "3"}, texts)
+	asserts.True(before)
+
+	texts, before, _ = find(&MessageQuery{Before: msgs[1].Id.Hex()})
+	asserts.Equal([]string{"0"}, texts)
+	asserts.False(before)
+
+	// newest first whatever the direction
+	texts, _, after := find(&MessageQuery{After: msgs[1].Id.Hex()})
+	asserts.Equal([]string{"3", "2"}, texts)
+	asserts.True(after)
+
+	texts, before, after = find(&MessageQuery{Around: msgs[2].Id.Hex()})
+	asserts.Equal([]string{"3", "2"}, texts)
+	asserts.True(before)
+	asserts.True(after)
+
+	_, err := repo.FindMessagesByCursor("room", "b", &MessageQuery{Before: msgs[1].Id.Hex(), After: msgs[0].Id.Hex()})
+	asserts.EqualError(err, "only one of before, after or around is allowed")
+}
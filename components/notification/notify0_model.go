@@ -6,6 +6,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// MentionType is the type of the notification of a user mentioned in a message
+const MentionType = "mention"
+
+// CreateNotification is Muted when the recipient muted the room, clients show it without alert
 type CreateNotification struct {
 	Title      string    `bson:"title" json:"title"`
 	Content    string    `bson:"content" json:"content"`
@@ -15,6 +19,9 @@ type CreateNotification struct {
 	ReadStatus bool      `bson:"read_status" json:"read_status"`
 	Priority   int       `bson:"priority" json:"priority"`
 	SourceLink string    `bson:"source_link,omitempty" json:"source_link,omitempty"`
+	RoomId     string    `bson:"room_id,omitempty" json:"room_id,omitempty"`
+	MsgId      string    `bson:"msg_id,omitempty" json:"msg_id,omitempty"`
+	Muted      bool      `bson:"muted,omitempty" json:"muted,omitempty"`
 	Image      string    `bson:"image" json:"image"`
 	CreatedAt  time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt  time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
@@ -30,6 +37,9 @@ type DBNotification struct {
 	ReadStatus bool               `bson:"read_status" json:"read_status"`
 	Priority   int                `bson:"priority" json:"priority"`
 	SourceLink string             `bson:"source_link,omitempty" json:"source_link,omitempty"`
+	RoomId     string             `bson:"room_id,omitempty" json:"room_id,omitempty"`
+	MsgId      string             `bson:"msg_id,omitempty" json:"msg_id,omitempty"`
+	Muted      bool               `bson:"muted,omitempty" json:"muted,omitempty"`
 	Image      string             `bson:"image" json:"image"`
 	CreatedAt  time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt  time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
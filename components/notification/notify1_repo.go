@@ -17,6 +17,7 @@ type NotifRepository struct {
 type I_NotifRepo interface {
 	GetNotifCollection() *mongo.Collection
 	AddNotif(room *CreateNotification) (*DBNotification, error)
+	AddNotifs(messages []*CreateNotification) ([]*DBNotification, error)
 }
 
 func NewNotifRepository(notifCollection *mongo.Collection, ctx context.Context) I_NotifRepo {
@@ -0,0 +1,58 @@
+package presence
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mode is selected by the user and shown to the contacts while online
+type Mode = string
+
+const (
+	Available Mode = "available"
+	Away      Mode = "away"
+	Busy      Mode = "busy"
+	// Invisible is shown as offline and keeps last seen at the time it was selected
+	Invisible Mode = "invisible"
+)
+
+var ValidModes = [4]Mode{Available, Away, Busy, Invisible}
+
+type Status = string
+
+const (
+	Online  Status = "online"
+	Offline Status = "offline"
+)
+
+type GetPresenceRequest struct {
+	UID       string   `json:"uid"`
+	Usernames []string `json:"usernames"`
+}
+
+type SetPresenceRequest struct {
+	UID  string `json:"uid"`
+	Mode string `json:"mode"`
+}
+
+type DBPresence struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UID       string             `json:"uid" bson:"uid"`
+	Mode      string             `json:"mode" bson:"mode"`
+	LastSeen  time.Time          `json:"last_seen,omitempty" bson:"last_seen,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+type ResponsePresence struct {
+	Username string     `json:"username"`
+	Status   string     `json:"status"`
+	Mode     string     `json:"mode,omitempty"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// I_PresenceTracker knows who is connected, usually the websocket server
+type I_PresenceTracker interface {
+	IsOnline(uid string) bool
+	NotifyModeChanged(uid, username, mode string)
+}
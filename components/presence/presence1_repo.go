@@ -0,0 +1,94 @@
+package presence
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type I_PresenceRepo interface {
+	FindPresence(uid string) (*DBPresence, error)
+	FindPresences(uids []string) ([]*DBPresence, error)
+	SetMode(uid, mode string) (*DBPresence, error)
+	SetLastSeen(uid string, lastSeen time.Time) error
+}
+
+type PresenceService struct {
+	presenceCollection *mongo.Collection
+	ctx                context.Context
+}
+
+func NewPresenceService(presenceCollection *mongo.Collection, ctx context.Context) I_PresenceRepo {
+	return &PresenceService{presenceCollection, ctx}
+}
+
+// FindPresence returns the presence of uid, a user without record is available and never seen
+func (me *PresenceService) FindPresence(uid string) (*DBPresence, error) {
+	var presence *DBPresence
+	if err := me.presenceCollection.FindOne(me.ctx, bson.M{"uid": uid}).Decode(&presence); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &DBPresence{UID: uid, Mode: Available}, nil
+		}
+		return nil, err
+	}
+
+	return presence, nil
+}
+
+func (me *PresenceService) FindPresences(uids []string) ([]*DBPresence, error) {
+	cursor, err := me.presenceCollection.Find(me.ctx, bson.M{"uid": bson.M{"$in": uids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var presences []*DBPresence
+	if err := cursor.All(me.ctx, &presences); err != nil {
+		return nil, err
+	}
+
+	if len(presences) == 0 {
+		return []*DBPresence{}, nil
+	}
+
+	return presences, nil
+}
+
+func (me *PresenceService) SetMode(uid, mode string) (*DBPresence, error) {
+	filter := bson.M{"uid": uid}
+	update := bson.M{"$set": bson.M{"mode": mode, "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var presence *DBPresence
+	err := me.presenceCollection.FindOneAndUpdate(me.ctx, filter, update, opts).Decode(&presence)
+	if err != nil {
+		return nil, err
+	}
+
+	return presence, nil
+}
+
+// SetLastSeen is skipped while the user is invisible
+func (me *PresenceService) SetLastSeen(uid string, lastSeen time.Time) error {
+	presence, err := me.FindPresence(uid)
+	if err != nil {
+		return err
+	}
+
+	if presence.Mode == Invisible {
+		return nil
+	}
+
+	filter := bson.M{"uid": uid}
+	update := bson.M{
+		"$set":         bson.M{"last_seen": lastSeen, "updated_at": time.Now()},
+		"$setOnInsert": bson.M{"mode": Available},
+	}
+	opts := options.Update().SetUpsert(true)
+
+	_, err = me.presenceCollection.UpdateOne(me.ctx, filter, update, opts)
+	return err
+}
@@ -0,0 +1,146 @@
+package presence
+
+import (
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/contacts"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+	"time"
+)
+
+// max usernames of a GetPresence request
+const MaxPresenceRequest = 100
+
+type PresenceController struct {
+	presenceService I_PresenceRepo
+	contactService  contacts.I_ContactRepo
+	tracker         I_PresenceTracker
+}
+
+func NewPresenceController(presenceService I_PresenceRepo, contactService contacts.I_ContactRepo) PresenceController {
+	return PresenceController{presenceService: presenceService, contactService: contactService}
+}
+
+func checkMode(m Mode) bool {
+	for _, valid := range ValidModes {
+		if m == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetPresence returns the presence of users who have the requester as accepted contact
+func (me *PresenceController) GetPresence(validuser *auth.Claims, o *GetPresenceRequest) ([]*ResponsePresence, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("get presence of %d users by %s", len(o.Usernames), validuser.GetUsername()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	if len(o.Usernames) == 0 || len(o.Usernames) > MaxPresenceRequest {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("request 1 to %d usernames", MaxPresenceRequest)}, http.StatusOK
+	}
+
+	uids := make(map[string]string)
+	var visible []string
+	for _, username := range o.Usernames {
+		_, err := utils.IsValidUsername(username)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("%s: %s", username, err.Error())}, http.StatusOK
+		}
+
+		targetuser, err := me.contactService.FindUserConnection(validuser.GetUID(), username)
+		if err != nil {
+			continue
+		}
+
+		// the contact of the target to the requester, so the target decides who sees it
+		accepted := targetuser.Contact != nil && targetuser.Contact.Status == contacts.Accepted
+		if !accepted && targetuser.UID != validuser.GetUID() {
+			continue
+		}
+
+		uids[targetuser.UID] = targetuser.Username
+		visible = append(visible, targetuser.UID)
+	}
+
+	results := []*ResponsePresence{}
+	if len(visible) == 0 {
+		return results, nil, http.StatusOK
+	}
+
+	presences, err := me.presenceService.FindPresences(visible)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	found := make(map[string]*DBPresence)
+	for _, p := range presences {
+		found[p.UID] = p
+	}
+
+	for _, uid := range visible {
+		p, ok := found[uid]
+		if !ok {
+			p = &DBPresence{UID: uid, Mode: Available}
+		}
+
+		results = append(results, me.toResponse(uids[uid], p))
+	}
+
+	return results, nil, http.StatusOK
+}
+
+func (me *PresenceController) SetPresence(validuser *auth.Claims, o *SetPresenceRequest) (*ResponsePresence, *jsonrpc2.RPCError, int) {
+	Logger.V(2).Info(fmt.Sprintf("set presence %s by %s", o.Mode, validuser.GetUsername()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "user uid did not match"}, http.StatusOK
+	}
+
+	if !checkMode(o.Mode) {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid mode"}, http.StatusOK
+	}
+
+	// last seen is frozen at the moment the user goes invisible
+	if o.Mode == Invisible {
+		if err := me.presenceService.SetLastSeen(validuser.GetUID(), time.Now()); err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+	}
+
+	p, err := me.presenceService.SetMode(validuser.GetUID(), o.Mode)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if me.tracker != nil {
+		me.tracker.NotifyModeChanged(validuser.GetUID(), validuser.GetUsername(), o.Mode)
+	}
+
+	// the user sees the mode even when invisible
+	res := me.toResponse(validuser.GetUsername(), p)
+	res.Mode = p.Mode
+
+	return res, nil, http.StatusOK
+}
+
+func (me *PresenceController) toResponse(username string, p *DBPresence) *ResponsePresence {
+	res := &ResponsePresence{Username: username, Status: Offline}
+	if me.tracker != nil && p.Mode != Invisible && me.tracker.IsOnline(p.UID) {
+		res.Status = Online
+		res.Mode = p.Mode
+		return res
+	}
+
+	if !p.LastSeen.IsZero() {
+		lastSeen := p.LastSeen
+		res.LastSeen = &lastSeen
+	}
+
+	return res
+}
@@ -0,0 +1,141 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pesatu/auth"
+	"pesatu/components/contacts"
+	"pesatu/jsonrpc2"
+	"pesatu/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/juju/ratelimit"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var Logger logr.Logger = logr.Discard()
+
+type PresenceRoute struct {
+	controller PresenceController
+	limiter    *ratelimit.Bucket
+}
+
+func NewPresenceRoute(mongoclient *mongo.Client, ctx context.Context, l logr.Logger, limiter *ratelimit.Bucket, contactService contacts.I_ContactRepo) PresenceRoute {
+	Logger = l
+	Logger.V(2).Info("NewPresenceRoute created")
+	collection := mongoclient.Database("pesatu").Collection("presence")
+	service := NewPresenceService(collection, ctx)
+	controller := NewPresenceController(service, contactService)
+	return PresenceRoute{controller, limiter}
+}
+
+func (me *PresenceRoute) InitRouteTo(rg *gin.RouterGroup) {
+	router := rg.Group("/presence")
+	router.POST("/rpc", me.RateLimit, me.RPCHandle)
+}
+
+func (me *PresenceRoute) RateLimit(ctx *gin.Context) {
+	// Check if the request is allowed by the rate limiter
+	if me.limiter.TakeAvailable(1) == 0 {
+		// The request is not allowed, so return an error
+		ctx.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	ctx.Next()
+}
+
+// SetTracker sets who knows the connected users, usually the websocket server
+func (me *PresenceRoute) SetTracker(tracker I_PresenceTracker) {
+	me.controller.tracker = tracker
+}
+
+func (me *PresenceRoute) GetPresenceService() I_PresenceRepo {
+	return me.controller.presenceService
+}
+
+func (me *PresenceRoute) RPCHandle(ctx *gin.Context) {
+	var jreq jsonrpc2.RPCRequest
+	if err := ctx.ShouldBindJSON(&jreq); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": "jsonrpc fail", "message": err.Error()})
+		return
+	}
+
+	Logger.V(2).Info(fmt.Sprintf("RPCHandle %s", jreq.Method))
+
+	jres := &jsonrpc2.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      jreq.ID,
+	}
+
+	statuscode := http.StatusBadRequest
+	switch jreq.Method {
+	case "GetPresence":
+		statuscode = me.method_GetPresence(ctx, &jreq, jres)
+	case "SetPresence":
+		statuscode = me.method_SetPresence(ctx, &jreq, jres)
+	default:
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+
+	if jres.Error != nil {
+		Logger.Error(fmt.Errorf(jres.Error.Message), "response with error")
+	}
+	ctx.JSON(statuscode, jres)
+}
+
+func (me *PresenceRoute) method_GetPresence(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *GetPresenceRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.GetPresence(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
+
+func (me *PresenceRoute) method_SetPresence(ctx *gin.Context, jreq *jsonrpc2.RPCRequest, jres *jsonrpc2.RPCResponse) int {
+	vuser, ok := ctx.Get("validuser")
+	if !ok {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "unauthorized"}
+		return http.StatusUnauthorized
+	}
+
+	validuser := vuser.(*auth.Claims)
+	if validuser.IsExpired() {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusUnauthorized, Message: "session expired"}
+		return http.StatusUnauthorized
+	}
+
+	var reg *SetPresenceRequest
+	err := json.Unmarshal(jreq.Params, &reg)
+	if err != nil {
+		jres.Error = &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+		return http.StatusBadRequest
+	}
+
+	res, e, code := me.controller.SetPresence(validuser, reg)
+	jres.Result, _ = utils.ToRawMessage(res)
+	jres.Error = e
+
+	return code
+}
@@ -10,17 +10,23 @@ type CreateRoom struct {
 	UID       string    `json:"uid" bson:"uid"`
 	Name      string    `json:"name" bson:"name"`
 	Private   bool      `json:"private" bson:"private"`
+	Group     bool      `json:"group" bson:"group,omitempty"`
+	Title     string    `json:"title,omitempty" bson:"title,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 type Room struct {
-	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	UID       string             `json:"uid" bson:"uid"`
-	Name      string             `json:"name" bson:"name"`
-	Private   bool               `json:"private" bson:"private"`
-	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
-	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Id      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UID     string             `json:"uid" bson:"uid"`
+	Name    string             `json:"name" bson:"name"`
+	Private bool               `json:"private" bson:"private"`
+	Group   bool               `json:"group" bson:"group,omitempty"`
+	Title   string             `json:"title,omitempty" bson:"title,omitempty"`
+	// seconds after which new messages of the room are deleted, none when zero
+	ExpireAfter int64     `json:"expire_after,omitempty" bson:"expire_after,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 func (room *Room) GetId() string {
@@ -34,3 +40,11 @@ func (room *Room) GetName() string {
 func (room *Room) GetPrivate() bool {
 	return room.Private
 }
+
+func (room *Room) GetGroup() bool {
+	return room.Group
+}
+
+func (room *Room) GetTitle() string {
+	return room.Title
+}
@@ -20,6 +20,8 @@ type I_RoomRepo interface {
 	GetRoomCollection() *mongo.Collection
 	AddRoom(room *CreateRoom) (*Room, error)
 	FindRoomByName(name string) (*Room, error)
+	FindRoomByUID(uid string) (*Room, error)
+	SetExpireAfter(uid string, seconds int64) (*Room, error)
 	DeleteRoom(obId primitive.ObjectID) error
 }
 
@@ -75,6 +77,39 @@ func (me *RoomRepository) FindRoomByName(name string) (*Room, error) {
 	return room, nil
 }
 
+func (me *RoomRepository) FindRoomByUID(uid string) (*Room, error) {
+	query := bson.M{"uid": uid}
+
+	var room *Room
+	if err := me.roomCollection.FindOne(me.ctx, query).Decode(&room); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("room unavailable")
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// SetExpireAfter sets the disappearing messages timer of a room, zero turns it off
+func (me *RoomRepository) SetExpireAfter(uid string, seconds int64) (*Room, error) {
+	update := bson.M{"$set": bson.M{"expire_after": seconds, "updated_at": time.Now()}}
+	if seconds == 0 {
+		update = bson.M{"$set": bson.M{"updated_at": time.Now()}, "$unset": bson.M{"expire_after": ""}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var room *Room
+	if err := me.roomCollection.FindOneAndUpdate(me.ctx, bson.M{"uid": uid}, update, opts).Decode(&room); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("room unavailable")
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
 func (me *RoomRepository) DeleteRoom(obId primitive.ObjectID) error {
 	query := bson.M{"_id": obId}
 
@@ -1,26 +1,154 @@
 package roommember
 
 import (
+	"pesatu/components/room"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type Role = string
+
+const (
+	Owner  Role = "owner"
+	Admin  Role = "admin"
+	Common Role = "member"
+)
+
+type EventAction = string
+
+// group events, pushed as websocket notifications to the room
+const (
+	GroupCreated  EventAction = "group-created"
+	MemberAdded   EventAction = "member-added"
+	MemberRemoved EventAction = "member-removed"
+	MemberLeft    EventAction = "member-left"
+	OwnerChanged  EventAction = "owner-changed"
+	RoleChanged   EventAction = "role-changed"
+)
+
+// TimerChanged is the disappearing messages timer of a private or group room being set
+const TimerChanged EventAction = "timer-changed"
+
+// SearchLastMessage lists the rooms of the user by latest message, the archived rooms only when Archived
 type SearchLastMessage struct {
-	UID   string `json:"uid"`
-	Page  string `json:"page"`
-	Limit string `json:"limit"`
+	UID      string `json:"uid"`
+	Page     string `json:"page"`
+	Limit    string `json:"limit"`
+	Archived bool   `json:"archived,omitempty"`
+}
+
+type CreateGroup struct {
+	UID     string   `json:"uid"`
+	Title   string   `json:"title"`
+	Members []string `json:"members"`
+}
+
+type GroupMembersRequest struct {
+	UID     string   `json:"uid"`
+	RoomID  string   `json:"room_id"`
+	Members []string `json:"members"`
+}
+
+// RoomTimerRequest sets the disappearing messages timer of a room in seconds, zero turns it off
+type RoomTimerRequest struct {
+	UID         string `json:"uid"`
+	RoomID      string `json:"room_id"`
+	ExpireAfter int64  `json:"expire_after"`
+}
+
+// MuteRoomRequest mutes a room for the user until an RFC3339 time, an empty Until unmutes it
+type MuteRoomRequest struct {
+	UID    string `json:"uid"`
+	RoomID string `json:"room_id"`
+	Until  string `json:"until"`
+}
+
+type ArchiveRoomRequest struct {
+	UID      string `json:"uid"`
+	RoomID   string `json:"room_id"`
+	Archived bool   `json:"archived"`
+}
+
+// ClearHistoryRequest hides the messages of a room sent so far from the user, for them only
+type ClearHistoryRequest struct {
+	UID    string `json:"uid"`
+	RoomID string `json:"room_id"`
+}
+
+type GroupMemberRequest struct {
+	UID      string `json:"uid"`
+	RoomID   string `json:"room_id"`
+	Username string `json:"username"`
+	Admin    bool   `json:"admin"`
+}
+
+type SearchGroupMembers struct {
+	UID    string `json:"uid"`
+	RoomID string `json:"room_id"`
+	Page   string `json:"page"`
+	Limit  string `json:"limit"`
 }
 
 type Member struct {
 	RoomID string `json:"room_id" bson:"room_id"`
 	UserID string `json:"usr_id" bson:"usr_id"`
+	Role   string `json:"role,omitempty" bson:"role,omitempty"`
 }
 
 type DBMember struct {
 	Id     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	RoomID string             `json:"room_id" bson:"room_id"`
 	UserID string             `json:"usr_id" bson:"usr_id"`
+	Role   string             `json:"role,omitempty" bson:"role,omitempty"`
+	// last message of the room the member has received and read, they only move forward
+	DeliveredUpTo *primitive.ObjectID `json:"delivered_up_to,omitempty" bson:"delivered_up_to,omitempty"`
+	ReadUpTo      *primitive.ObjectID `json:"read_up_to,omitempty" bson:"read_up_to,omitempty"`
+	// preferences of the member, the messages up to ClearedUpTo are hidden from them
+	MutedUntil  *time.Time          `json:"muted_until,omitempty" bson:"muted_until,omitempty"`
+	Archived    bool                `json:"archived,omitempty" bson:"archived,omitempty"`
+	ClearedUpTo *primitive.ObjectID `json:"cleared_up_to,omitempty" bson:"cleared_up_to,omitempty"`
+}
+
+// IsMuted tells whether the member muted the room at t
+func (me *DBMember) IsMuted(t time.Time) bool {
+	return me.MutedUntil != nil && me.MutedUntil.After(t)
+}
+
+type GroupMember struct {
+	Name     string `json:"name" bson:"name"`
+	Username string `json:"username" bson:"username"`
+	Avatar   string `json:"avatar" bson:"avatar"`
+	Role     string `json:"role" bson:"role"`
+}
+
+type ResponseGroup struct {
+	Room    *room.Room     `json:"room"`
+	Members []*GroupMember `json:"members"`
+}
+
+// MemberEvent is a membership change of a group, the notifier pushes it
+// to the room and joins or removes the member's connected clients
+type MemberEvent struct {
+	Action    string     `json:"action"`
+	Room      *room.Room `json:"room"`
+	Actor     string     `json:"actor"`
+	ActorUID  string     `json:"-"`
+	Member    string     `json:"member"`
+	MemberUID string     `json:"-"`
+	Role      string     `json:"role,omitempty"`
+	// seconds of the timer of a timer-changed event
+	ExpireAfter int64     `json:"expire_after,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+type I_RoomNotifier interface {
+	NotifyMemberEvent(event *MemberEvent)
+}
+
+// I_BlockChecker tells whether one of two users has blocked the other
+type I_BlockChecker interface {
+	IsBlocked(uid1, uid2 string) (bool, error)
 }
 
 type Message struct {
@@ -31,6 +159,10 @@ type Message struct {
 	Status    string             `json:"status" bson:"status"`
 	Time      time.Time          `json:"time,omitempty" bson:"time,omitempty"`
 	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	EditedAt  *time.Time         `json:"edited_at,omitempty" bson:"edited_at,omitempty"`
+	Deleted   bool               `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	KeyID     string             `json:"-" bson:"key_id,omitempty"`
+	DataKey   []byte             `json:"-" bson:"data_key,omitempty"`
 }
 
 type Icon struct {
@@ -44,8 +176,14 @@ type DBLastMessage struct {
 	RoomId      string   `json:"room_id" bson:"_id"`
 	LastMsg     *Message `json:"last_msg" bson:"latestMessage"`
 	UnreadCount int      `json:"unread_c" bson:"unreadCount"`
-	Private     bool     `json:"private" bson:"private"`
-	Sender      string   `json:"sender" bson:"sender"`
+	// unread messages mentioning the user, counted in muted rooms too
+	UnreadMentions int        `json:"unread_mentions" bson:"unreadMentions"`
+	MutedUntil     *time.Time `json:"muted_until,omitempty" bson:"mutedUntil,omitempty"`
+	Archived       bool       `json:"archived,omitempty" bson:"archived,omitempty"`
+	Private        bool       `json:"private" bson:"private"`
+	Group          bool       `json:"group" bson:"group"`
+	Title          string     `json:"title,omitempty" bson:"title"`
+	Sender         string     `json:"sender" bson:"sender"`
 }
 
 type LastMessages struct {
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"pesatu/components/room"
+	"pesatu/keyring"
 	"pesatu/utils"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -17,8 +20,18 @@ type I_RoomMember interface {
 	RemoveMember(member *Member) error
 	FindMembers(roomId string, page int, limit int) ([]*DBMember, error)
 	CheckMemberExist(member *Member) (bool, error)
+	SaveMember(member *Member) (*DBMember, error)
+	TransferOwner(roomId, fromId, toId string) error
+	FindMember(roomId, userId string) (*DBMember, error)
+	CountMembers(roomId string) (int64, error)
+	MoveReceiptMark(roomId, userId string, msgId primitive.ObjectID, read bool) (*DBMember, error)
+	FindGroupMembers(roomId string, page, limit int) ([]*GroupMember, error)
 	FindRoomByMemberID(id string, page, limit int) ([]*room.Room, error)
-	FindLastMessagesGroupingByRoom(userID string, page, limit int) (*LastMessages, error)
+	FindLastMessagesGroupingByRoom(userID string, archived bool, page, limit int) (*LastMessages, error)
+	SetMutedUntil(roomId, userId string, until *time.Time) (*DBMember, error)
+	SetArchived(roomId, userId string, archived bool) (*DBMember, error)
+	ClearHistory(roomId, userId string) (*DBMember, error)
+	FindClearedMarks(userId string) (map[string]primitive.ObjectID, error)
 }
 
 type RoomMemberService struct {
@@ -136,6 +149,223 @@ func (me *RoomMemberService) CheckMemberExist(member *Member) (bool, error) {
 	return false, nil
 }
 
+// SaveMember adds the member to the room or updates its role when it is already a member
+func (me *RoomMemberService) SaveMember(member *Member) (*DBMember, error) {
+	filter := bson.M{"room_id": member.RoomID, "usr_id": member.UserID}
+	update := bson.M{"$set": bson.M{"role": member.Role}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var dbMember *DBMember
+	err := me.memberCollection.FindOneAndUpdate(me.ctx, filter, update, opts).Decode(&dbMember)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbMember, nil
+}
+
+// TransferOwner makes toId the owner of the room and fromId, the owner so far, an admin, in one ordered
+// bulk write. The role of toId is restored when fromId can not be demoted, a room never keeps two owners.
+func (me *RoomMemberService) TransferOwner(roomId, fromId, toId string) error {
+	target, err := me.FindMember(roomId, toId)
+	if err != nil {
+		return err
+	}
+
+	models := []mongo.WriteModel{
+		mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"room_id": roomId, "usr_id": toId}).
+			SetUpdate(bson.M{"$set": bson.M{"role": Owner}}),
+		mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"room_id": roomId, "usr_id": fromId, "role": Owner}).
+			SetUpdate(bson.M{"$set": bson.M{"role": Admin}}),
+	}
+
+	res, err := me.memberCollection.BulkWrite(me.ctx, models, options.BulkWrite().SetOrdered(true))
+	if err == nil && res.MatchedCount == int64(len(models)) {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("owner has changed meanwhile")
+	}
+
+	restore := bson.M{"$unset": bson.M{"role": ""}}
+	if len(target.Role) > 0 {
+		restore = bson.M{"$set": bson.M{"role": target.Role}}
+	}
+	if _, rerr := me.memberCollection.UpdateOne(me.ctx, bson.M{"room_id": roomId, "usr_id": toId}, restore); rerr != nil {
+		utils.Log().Error(rerr, fmt.Sprintf("error while restoring the role of %s in room %s", toId, roomId))
+	}
+
+	return err
+}
+
+func (me *RoomMemberService) FindMember(roomId, userId string) (*DBMember, error) {
+	query := bson.M{"room_id": roomId, "usr_id": userId}
+
+	var member *DBMember
+	if err := me.memberCollection.FindOne(me.ctx, query).Decode(&member); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("member unavailable")
+		}
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (me *RoomMemberService) CountMembers(roomId string) (int64, error) {
+	return me.memberCollection.CountDocuments(me.ctx, bson.M{"room_id": roomId})
+}
+
+// MoveReceiptMark moves the delivered mark of a member forward to msgId, and the read mark too when read,
+// it returns the member as it was before, or an error when userId is not a member of the room
+func (me *RoomMemberService) MoveReceiptMark(roomId, userId string, msgId primitive.ObjectID, read bool) (*DBMember, error) {
+	filter := bson.M{"room_id": roomId, "usr_id": userId}
+	marks := bson.M{"delivered_up_to": msgId}
+	if read {
+		marks["read_up_to"] = msgId
+	}
+	update := bson.M{"$max": marks}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var member *DBMember
+	if err := me.memberCollection.FindOneAndUpdate(me.ctx, filter, update, opts).Decode(&member); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("member unavailable")
+		}
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// SetMutedUntil mutes the room for the member until the time, nil unmutes it
+func (me *RoomMemberService) SetMutedUntil(roomId, userId string, until *time.Time) (*DBMember, error) {
+	update := bson.M{"$unset": bson.M{"muted_until": ""}}
+	if until != nil {
+		update = bson.M{"$set": bson.M{"muted_until": *until}}
+	}
+
+	return me.updateMember(roomId, userId, update)
+}
+
+func (me *RoomMemberService) SetArchived(roomId, userId string, archived bool) (*DBMember, error) {
+	update := bson.M{"$unset": bson.M{"archived": ""}}
+	if archived {
+		update = bson.M{"$set": bson.M{"archived": true}}
+	}
+
+	return me.updateMember(roomId, userId, update)
+}
+
+// ClearHistory hides the messages of the room sent so far from the member, up to the latest one
+func (me *RoomMemberService) ClearHistory(roomId, userId string) (*DBMember, error) {
+	var latest struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+
+	opts := options.FindOne().SetSort(bson.M{"_id": -1}).SetProjection(bson.M{"_id": 1})
+	err := me.memberCollection.Database().Collection("messages").FindOne(me.ctx, bson.M{"room": roomId}, opts).Decode(&latest)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return me.FindMember(roomId, userId)
+		}
+		return nil, err
+	}
+
+	return me.updateMember(roomId, userId, bson.M{"$max": bson.M{"cleared_up_to": latest.Id}})
+}
+
+// FindClearedMarks returns the history cutoffs of userId by room id, for the rooms they cleared
+func (me *RoomMemberService) FindClearedMarks(userId string) (map[string]primitive.ObjectID, error) {
+	query := bson.M{"usr_id": userId, "cleared_up_to": bson.M{"$exists": true}}
+	opts := options.Find().SetProjection(bson.M{"room_id": 1, "cleared_up_to": 1})
+
+	cursor, err := me.memberCollection.Find(me.ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var members []*DBMember
+	if err := cursor.All(me.ctx, &members); err != nil {
+		return nil, err
+	}
+
+	marks := make(map[string]primitive.ObjectID, len(members))
+	for _, member := range members {
+		marks[member.RoomID] = *member.ClearedUpTo
+	}
+
+	return marks, nil
+}
+
+func (me *RoomMemberService) updateMember(roomId, userId string, update bson.M) (*DBMember, error) {
+	filter := bson.M{"room_id": roomId, "usr_id": userId}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var member *DBMember
+	if err := me.memberCollection.FindOneAndUpdate(me.ctx, filter, update, opts).Decode(&member); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("member unavailable")
+		}
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (me *RoomMemberService) FindGroupMembers(roomId string, page, limit int) ([]*GroupMember, error) {
+	if page == 0 {
+		page = 1
+	}
+
+	if limit == 0 {
+		limit = 10
+	}
+
+	skip := (page - 1) * limit
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"room_id": roomId}},
+		{"$lookup": bson.M{
+			"from":         "users",
+			"localField":   "usr_id",
+			"foreignField": "uid",
+			"as":           "user",
+		}},
+		{"$unwind": "$user"},
+		{"$project": bson.M{
+			"_id":      0,
+			"name":     "$user.name",
+			"username": "$user.username",
+			"avatar":   "$user.avatar",
+			"role":     bson.M{"$ifNull": []interface{}{"$role", Common}},
+		}},
+		{"$sort": bson.M{"username": 1}},
+		{"$skip": skip},
+		{"$limit": limit},
+	}
+
+	cursor, err := me.memberCollection.Aggregate(me.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(me.ctx)
+
+	var members []*GroupMember
+	if err := cursor.All(me.ctx, &members); err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return []*GroupMember{}, nil
+	}
+
+	return members, nil
+}
+
 func (me *RoomMemberService) FindRoomByMemberID(id string, page, limit int) ([]*room.Room, error) {
 	if page == 0 {
 		page = 1
@@ -160,6 +390,8 @@ func (me *RoomMemberService) FindRoomByMemberID(id string, page, limit int) ([]*
 			"uid":        1,
 			"name":       1,
 			"private":    1,
+			"group":      1,
+			"title":      1,
 			"created_at": 1,
 			"updated_at": 1,
 		}},
@@ -195,7 +427,9 @@ func (me *RoomMemberService) FindRoomByMemberID(id string, page, limit int) ([]*
 	return roomresults, nil
 }
 
-func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page, limit int) (*LastMessages, error) {
+// FindLastMessagesGroupingByRoom returns the rooms of the user, archived or not, by latest message,
+// without the messages the user cleared and without unread count while the room is muted
+func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, archived bool, page, limit int) (*LastMessages, error) {
 	if page == 0 {
 		page = 1
 	}
@@ -206,9 +440,14 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 
 	skip := (page - 1) * limit
 
+	match := bson.M{"usr_id": userID, "archived": bson.M{"$ne": true}}
+	if archived {
+		match["archived"] = true
+	}
+
 	utils.Log().V(2).Info(fmt.Sprintf("s %d, l %d", skip, limit))
 	pipeline := []bson.M{
-		{"$match": bson.M{"usr_id": userID}},
+		{"$match": match},
 		{"$lookup": bson.M{
 			"from":         "messages",
 			"localField":   "room_id",
@@ -216,6 +455,9 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 			"as":           "messages",
 		}},
 		{"$unwind": "$messages"},
+		{"$match": bson.M{"$expr": bson.M{
+			"$gt": []interface{}{"$messages._id", bson.M{"$ifNull": []interface{}{"$cleared_up_to", primitive.NilObjectID}}},
+		}}},
 		{"$sort": bson.M{
 			"messages.time": -1,
 		}},
@@ -235,6 +477,20 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 		{"$unwind": "$dbroom"},
 		{"$group": bson.M{
 			"_id": "$room_id",
+			"unreadMentions": bson.M{
+				"$sum": bson.M{
+					"$cond": bson.M{
+						"if": bson.M{
+							"$and": []bson.M{
+								{"$in": []interface{}{userID, bson.M{"$ifNull": []interface{}{"$messages.mentions", []string{}}}}},
+								{"$gt": []interface{}{"$messages._id", bson.M{"$ifNull": []interface{}{"$read_up_to", primitive.NilObjectID}}}},
+							},
+						},
+						"then": 1,
+						"else": 0,
+					},
+				},
+			},
 			"latestMessage": bson.M{
 				"$first": "$messages",
 			},
@@ -247,13 +503,26 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 			"private": bson.M{
 				"$first": "$dbroom.private",
 			},
+			"group": bson.M{
+				"$first": "$dbroom.group",
+			},
+			"title": bson.M{
+				"$first": "$dbroom.title",
+			},
+			"mutedUntil": bson.M{
+				"$first": "$muted_until",
+			},
+			"archived": bson.M{
+				"$first": "$archived",
+			},
 			"unreadCount": bson.M{
 				"$sum": bson.M{
 					"$cond": bson.M{
 						"if": bson.M{
 							"$and": []bson.M{
-								{"$ne": []interface{}{"$messages.status", "read"}},
+								{"$gt": []interface{}{"$messages._id", bson.M{"$ifNull": []interface{}{"$read_up_to", primitive.NilObjectID}}}},
 								{"$ne": []interface{}{"$messages.sender", userID}},
+								{"$not": []interface{}{bson.M{"$gt": []interface{}{"$muted_until", time.Now()}}}},
 							},
 						},
 						"then": 1,
@@ -285,6 +554,16 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 		return &LastMessages{Rooms: []*DBLastMessage{}, Icons: []*Icon{}}, nil
 	}
 
+	for _, r := range results {
+		if r.LastMsg == nil {
+			continue
+		}
+
+		if r.LastMsg.Message, err = keyring.OpenField(r.LastMsg.KeyID, r.LastMsg.DataKey, "message", r.LastMsg.Message); err != nil {
+			return nil, err
+		}
+	}
+
 	//for icon
 	roomIds := []string{}
 	for i := 0; i < len(results); i++ {
@@ -293,8 +572,9 @@ func (me *RoomMemberService) FindLastMessagesGroupingByRoom(userID string, page,
 		}
 	}
 
+	// only group rooms, icons are for private rooms
 	if len(roomIds) == 0 {
-		return nil, fmt.Errorf("private room not found")
+		return &LastMessages{Rooms: results, Icons: []*Icon{}}, nil
 	}
 
 	pipeline2 := []bson.M{
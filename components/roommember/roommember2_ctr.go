@@ -4,17 +4,34 @@ import (
 	"fmt"
 	"net/http"
 	"pesatu/auth"
+	"pesatu/components/room"
+	"pesatu/components/user"
 	"pesatu/jsonrpc2"
 	"pesatu/utils"
 	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// max members of a group, including the owner
+const MaxGroupMembers = 256
+
+// bounds of the disappearing messages timer, in seconds
+const (
+	MinExpireAfter = 60
+	MaxExpireAfter = 90 * 24 * 60 * 60
 )
 
 type RoomMemberController struct {
 	roomService I_RoomMember
+	userService user.I_UserRepo
+	notifier    I_RoomNotifier
+	blocks      I_BlockChecker
 }
 
-func NewRoomMemberController(roomService I_RoomMember) RoomMemberController {
-	return RoomMemberController{roomService}
+func NewRoomMemberController(roomService I_RoomMember, userService user.I_UserRepo) RoomMemberController {
+	return RoomMemberController{roomService: roomService, userService: userService}
 }
 
 func (me *RoomMemberController) FindLastMessages(validuser *auth.Claims, o *SearchLastMessage) (*LastMessages, *jsonrpc2.RPCError, int) {
@@ -38,7 +55,7 @@ func (me *RoomMemberController) FindLastMessages(validuser *auth.Claims, o *Sear
 		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid limit input"}, http.StatusOK
 	}
 
-	results, err := me.roomService.FindLastMessagesGroupingByRoom(validuser.GetUID(), intPage, intLimit)
+	results, err := me.roomService.FindLastMessagesGroupingByRoom(validuser.GetUID(), o.Archived, intPage, intLimit)
 
 	if err != nil {
 		return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}, http.StatusOK
@@ -46,3 +63,497 @@ func (me *RoomMemberController) FindLastMessages(validuser *auth.Claims, o *Sear
 
 	return results, nil, http.StatusOK
 }
+
+func (me *RoomMemberController) CreateGroup(validuser *auth.Claims, o *CreateGroup) (*ResponseGroup, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("create group %s by user id: %s", o.Title, validuser.GetUID()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "uid invalid"}, http.StatusOK
+	}
+
+	_, err := utils.IsValidName(o.Title)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}, http.StatusOK
+	}
+
+	if len(o.Members)+1 > MaxGroupMembers {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("too many members, max %d", MaxGroupMembers)}, http.StatusOK
+	}
+
+	members, rpcErr := me.findUsers(validuser, o.Members)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	groupUID := uuid.New().String()
+	group, err := me.roomService.AddRoom(&room.CreateRoom{
+		UID:     groupUID,
+		Name:    groupUID,
+		Private: false,
+		Group:   true,
+		Title:   o.Title,
+	})
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	_, err = me.roomService.SaveMember(&Member{RoomID: group.UID, UserID: validuser.GetUID(), Role: Owner})
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+	me.notify(GroupCreated, group, validuser, validuser.GetUsername(), validuser.GetUID(), Owner)
+
+	for _, m := range members {
+		_, err = me.roomService.SaveMember(&Member{RoomID: group.UID, UserID: m.UID, Role: Common})
+		if err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while adding %s to group %s", m.Username, group.UID))
+			continue
+		}
+		me.notify(MemberAdded, group, validuser, m.Username, m.UID, Common)
+	}
+
+	groupMembers, err := me.roomService.FindGroupMembers(group.UID, 1, MaxGroupMembers)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return &ResponseGroup{Room: group, Members: groupMembers}, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) AddGroupMembers(validuser *auth.Claims, o *GroupMembersRequest) ([]*GroupMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("add group members to %s by user id: %s", o.RoomID, validuser.GetUID()))
+
+	group, actor, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if actor.Role != Owner && actor.Role != Admin {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "only owner or admin can add members"}, http.StatusOK
+	}
+
+	count, err := me.roomService.CountMembers(group.UID)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if int(count)+len(o.Members) > MaxGroupMembers {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("too many members, max %d", MaxGroupMembers)}, http.StatusOK
+	}
+
+	members, rpcErr := me.findUsers(validuser, o.Members)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	added := []*GroupMember{}
+	for _, m := range members {
+		if _, err := me.roomService.FindMember(group.UID, m.UID); err == nil {
+			// already a member, keep the current role
+			continue
+		}
+
+		_, err = me.roomService.SaveMember(&Member{RoomID: group.UID, UserID: m.UID, Role: Common})
+		if err != nil {
+			utils.Log().Error(err, fmt.Sprintf("error while adding %s to group %s", m.Username, group.UID))
+			continue
+		}
+
+		added = append(added, &GroupMember{Name: m.Name, Username: m.Username, Avatar: m.Avatar, Role: Common})
+		me.notify(MemberAdded, group, validuser, m.Username, m.UID, Common)
+	}
+
+	return added, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) RemoveGroupMember(validuser *auth.Claims, o *GroupMemberRequest) (*GroupMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("remove %s from group %s by user id: %s", o.Username, o.RoomID, validuser.GetUID()))
+
+	group, actor, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	target, member, rpcErr := me.findTargetMember(group, o.Username)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if target.UID == validuser.GetUID() {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "use LeaveGroup to leave the group"}, http.StatusOK
+	}
+
+	// owner can remove anyone, admin can only remove common members
+	allowed := actor.Role == Owner || (actor.Role == Admin && member.Role != Owner && member.Role != Admin)
+	if !allowed {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "not allowed to remove this member"}, http.StatusOK
+	}
+
+	err := me.roomService.RemoveMember(&Member{RoomID: group.UID, UserID: target.UID})
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	me.notify(MemberRemoved, group, validuser, target.Username, target.UID, "")
+
+	return &GroupMember{Name: target.Name, Username: target.Username, Avatar: target.Avatar, Role: member.Role}, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) LeaveGroup(validuser *auth.Claims, o *GroupMemberRequest) (*room.Room, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("leave group %s by user id: %s", o.RoomID, validuser.GetUID()))
+
+	group, actor, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if actor.Role == Owner {
+		count, err := me.roomService.CountMembers(group.UID)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		if count > 1 {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "owner must transfer ownership before leaving"}, http.StatusOK
+		}
+	}
+
+	err := me.roomService.RemoveMember(&Member{RoomID: group.UID, UserID: validuser.GetUID()})
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	me.notify(MemberLeft, group, validuser, validuser.GetUsername(), validuser.GetUID(), "")
+
+	return group, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) TransferGroupOwner(validuser *auth.Claims, o *GroupMemberRequest) (*GroupMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("transfer owner of group %s to %s by user id: %s", o.RoomID, o.Username, validuser.GetUID()))
+
+	group, actor, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if actor.Role != Owner {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "only owner can transfer ownership"}, http.StatusOK
+	}
+
+	target, _, rpcErr := me.findTargetMember(group, o.Username)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if target.UID == validuser.GetUID() {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "you are already the owner"}, http.StatusOK
+	}
+
+	// previous owner stays in the group as admin
+	if err := me.roomService.TransferOwner(group.UID, validuser.GetUID(), target.UID); err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	me.notify(OwnerChanged, group, validuser, target.Username, target.UID, Owner)
+
+	return &GroupMember{Name: target.Name, Username: target.Username, Avatar: target.Avatar, Role: Owner}, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) SetGroupAdmin(validuser *auth.Claims, o *GroupMemberRequest) (*GroupMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("set admin %t of group %s to %s by user id: %s", o.Admin, o.RoomID, o.Username, validuser.GetUID()))
+
+	group, actor, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if actor.Role != Owner {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "only owner can change admins"}, http.StatusOK
+	}
+
+	target, member, rpcErr := me.findTargetMember(group, o.Username)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	if member.Role == Owner {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "can not change role of the owner"}, http.StatusOK
+	}
+
+	role := Common
+	if o.Admin {
+		role = Admin
+	}
+
+	if member.Role != role {
+		_, err := me.roomService.SaveMember(&Member{RoomID: group.UID, UserID: target.UID, Role: role})
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+		}
+
+		me.notify(RoleChanged, group, validuser, target.Username, target.UID, role)
+	}
+
+	return &GroupMember{Name: target.Name, Username: target.Username, Avatar: target.Avatar, Role: role}, nil, http.StatusOK
+}
+
+// SetRoomTimer makes the new messages of a private or group room disappear after o.ExpireAfter seconds,
+// any member of a private room may set it, only the owner and admins of a group
+func (me *RoomMemberController) SetRoomTimer(validuser *auth.Claims, o *RoomTimerRequest) (*room.Room, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("set timer %ds of room %s by user id: %s", o.ExpireAfter, o.RoomID, validuser.GetUID()))
+
+	if validuser.GetUID() != o.UID {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "uid invalid"}, http.StatusOK
+	}
+
+	if o.ExpireAfter != 0 && (o.ExpireAfter < MinExpireAfter || o.ExpireAfter > MaxExpireAfter) {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("timer must be 0 to turn off, or %d to %d seconds", MinExpireAfter, MaxExpireAfter)}, http.StatusOK
+	}
+
+	if !utils.IsValidUid(o.RoomID) {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid room id"}, http.StatusOK
+	}
+
+	r, err := me.roomService.FindRoomByUID(o.RoomID)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}, http.StatusOK
+	}
+
+	if !r.GetPrivate() && !r.GetGroup() {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "timer is only available in private and group rooms"}, http.StatusOK
+	}
+
+	actor, err := me.roomService.FindMember(r.UID, validuser.GetUID())
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this room"}, http.StatusOK
+	}
+
+	if r.GetGroup() && actor.Role != Owner && actor.Role != Admin {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "only owner and admins can set the timer"}, http.StatusOK
+	}
+
+	if r.ExpireAfter == o.ExpireAfter {
+		return r, nil, http.StatusOK
+	}
+
+	r, err = me.roomService.SetExpireAfter(r.UID, o.ExpireAfter)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	if me.notifier != nil {
+		me.notifier.NotifyMemberEvent(&MemberEvent{
+			Action:      TimerChanged,
+			Room:        r,
+			Actor:       validuser.GetUsername(),
+			ActorUID:    validuser.GetUID(),
+			ExpireAfter: o.ExpireAfter,
+			Time:        time.Now(),
+		})
+	}
+
+	return r, nil, http.StatusOK
+}
+
+// MuteRoom mutes a room for the user until o.Until, an empty Until unmutes it
+func (me *RoomMemberController) MuteRoom(validuser *auth.Claims, o *MuteRoomRequest) (*DBMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("mute room %s until %q by user id: %s", o.RoomID, o.Until, validuser.GetUID()))
+
+	if rpcErr := me.checkRoomMember(validuser, o.UID, o.RoomID); rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	var until *time.Time
+	if len(o.Until) > 0 {
+		t, err := time.Parse(time.RFC3339, o.Until)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid until time"}, http.StatusOK
+		}
+
+		if !t.After(time.Now()) {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "until must be in the future"}, http.StatusOK
+		}
+		until = &t
+	}
+
+	member, err := me.roomService.SetMutedUntil(o.RoomID, validuser.GetUID(), until)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return member, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) ArchiveRoom(validuser *auth.Claims, o *ArchiveRoomRequest) (*DBMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("archive %t room %s by user id: %s", o.Archived, o.RoomID, validuser.GetUID()))
+
+	if rpcErr := me.checkRoomMember(validuser, o.UID, o.RoomID); rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	member, err := me.roomService.SetArchived(o.RoomID, validuser.GetUID(), o.Archived)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return member, nil, http.StatusOK
+}
+
+// ClearRoomHistory is the delete chat for me, the other members still see the messages
+func (me *RoomMemberController) ClearRoomHistory(validuser *auth.Claims, o *ClearHistoryRequest) (*DBMember, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("clear history of room %s by user id: %s", o.RoomID, validuser.GetUID()))
+
+	if rpcErr := me.checkRoomMember(validuser, o.UID, o.RoomID); rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	member, err := me.roomService.ClearHistory(o.RoomID, validuser.GetUID())
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return member, nil, http.StatusOK
+}
+
+func (me *RoomMemberController) GetGroupMembers(validuser *auth.Claims, o *SearchGroupMembers) (*ResponseGroup, *jsonrpc2.RPCError, int) {
+	utils.Log().V(2).Info(fmt.Sprintf("get members of group %s by user id: %s", o.RoomID, validuser.GetUID()))
+
+	group, _, rpcErr := me.findGroupAndActor(validuser, o.UID, o.RoomID)
+	if rpcErr != nil {
+		return nil, rpcErr, http.StatusOK
+	}
+
+	intPage, err := strconv.Atoi(o.Page)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid page input"}, http.StatusOK
+	}
+
+	intLimit, err := strconv.Atoi(o.Limit)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid limit input"}, http.StatusOK
+	}
+
+	members, err := me.roomService.FindGroupMembers(group.UID, intPage, intLimit)
+	if err != nil {
+		return nil, &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}, http.StatusOK
+	}
+
+	return &ResponseGroup{Room: group, Members: members}, nil, http.StatusOK
+}
+
+// findGroupAndActor checks the request uid and returns the group with the membership of the requester
+func (me *RoomMemberController) findGroupAndActor(validuser *auth.Claims, uid, roomID string) (*room.Room, *DBMember, *jsonrpc2.RPCError) {
+	if validuser.GetUID() != uid {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "uid invalid"}
+	}
+
+	if !utils.IsValidUid(roomID) {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid room id"}
+	}
+
+	group, err := me.roomService.FindRoomByUID(roomID)
+	if err != nil {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	if !group.GetGroup() {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "room is not a group"}
+	}
+
+	actor, err := me.roomService.FindMember(group.UID, validuser.GetUID())
+	if err != nil {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this group"}
+	}
+
+	return group, actor, nil
+}
+
+// checkRoomMember checks the request uid and that the requester is a member of the room, of any kind
+func (me *RoomMemberController) checkRoomMember(validuser *auth.Claims, uid, roomID string) *jsonrpc2.RPCError {
+	if validuser.GetUID() != uid {
+		return &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "uid invalid"}
+	}
+
+	if !utils.IsValidUid(roomID) {
+		return &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: "invalid room id"}
+	}
+
+	ok, err := me.roomService.CheckMemberExist(&Member{RoomID: roomID, UserID: validuser.GetUID()})
+	if err != nil {
+		return &jsonrpc2.RPCError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	if !ok {
+		return &jsonrpc2.RPCError{Code: http.StatusForbidden, Message: "you are not a member of this room"}
+	}
+
+	return nil
+}
+
+func (me *RoomMemberController) findTargetMember(group *room.Room, username string) (*user.DBUser, *DBMember, *jsonrpc2.RPCError) {
+	_, err := utils.IsValidUsername(username)
+	if err != nil {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	target, err := me.userService.FindUserByUsername(username)
+	if err != nil {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	member, err := me.roomService.FindMember(group.UID, target.UID)
+	if err != nil {
+		return nil, nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	return target, member, nil
+}
+
+func (me *RoomMemberController) findUsers(validuser *auth.Claims, usernames []string) ([]*user.DBUser, *jsonrpc2.RPCError) {
+	var users []*user.DBUser
+	found := make(map[string]bool)
+	for _, username := range usernames {
+		if username == validuser.GetUsername() || found[username] {
+			continue
+		}
+
+		_, err := utils.IsValidUsername(username)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusBadRequest, Message: fmt.Sprintf("%s: %s", username, err.Error())}
+		}
+
+		u, err := me.userService.FindUserByUsername(username)
+		if err != nil {
+			return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: fmt.Sprintf("%s: %s", username, err.Error())}
+		}
+
+		// a user who blocked the requester, or was blocked by them, can not be added to their groups
+		if me.blocks != nil {
+			if blocked, err := me.blocks.IsBlocked(validuser.GetUID(), u.UID); err != nil || blocked {
+				return nil, &jsonrpc2.RPCError{Code: http.StatusNotFound, Message: fmt.Sprintf("%s: user unavailable", username)}
+			}
+		}
+
+		found[username] = true
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+func (me *RoomMemberController) notify(action EventAction, group *room.Room, validuser *auth.Claims, member, memberUID, role string) {
+	if me.notifier == nil {
+		return
+	}
+
+	me.notifier.NotifyMemberEvent(&MemberEvent{
+		Action:    action,
+		Room:      group,
+		Actor:     validuser.GetUsername(),
+		ActorUID:  validuser.GetUID(),
+		Member:    member,
+		MemberUID: memberUID,
+		Role:      role,
+		Time:      time.Now(),
+	})
+}
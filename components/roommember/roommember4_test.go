@@ -0,0 +1,179 @@
+package roommember
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pesatu/auth"
+	"pesatu/components/room"
+	"pesatu/components/user"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// run the repository tests against a local mongod with
+// MONGO_TEST_URL=mongodb://localhost:27017 go test -v ./components/roommember
+func TestMain(m *testing.M) {
+	//before
+	fmt.Println("\nSTART UNIT TEST 'roommember'")
+
+	m.Run()
+
+	//after
+	fmt.Println("END UNIT TEST 'roommember'")
+}
+
+// testDB returns an empty database dropped after the test
+func testDB(t *testing.T) *mongo.Database {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := client.Database(fmt.Sprintf("pesatu_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return db
+}
+
+func Test_TransferOwner(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewRoomMemberService(db.Collection("rooms"), db.Collection("roommembers"), context.Background())
+
+	for uid, role := range map[string]string{"a": Owner, "b": Common, "c": Common} {
+		_, err := repo.SaveMember(&Member{RoomID: "g", UserID: uid, Role: role})
+		asserts.Nil(err)
+	}
+
+	asserts.Nil(repo.TransferOwner("g", "a", "b"))
+	b, _ := repo.FindMember("g", "b")
+	asserts.Equal(Owner, b.Role)
+	a, _ := repo.FindMember("g", "a")
+	asserts.Equal(Admin, a.Role)
+
+	// a is not the owner anymore, c is not left as a second owner
+	asserts.NotNil(repo.TransferOwner("g", "a", "c"))
+	c, _ := repo.FindMember("g", "c")
+	asserts.Equal(Common, c.Role)
+	b, _ = repo.FindMember("g", "b")
+	asserts.Equal(Owner, b.Role)
+}
+
+// groupRepo is a group owned by "owner" which keeps the members saved
+type groupRepo struct {
+	I_RoomMember
+	saved []string
+}
+
+func (me *groupRepo) FindRoomByUID(uid string) (*room.Room, error) {
+	return &room.Room{UID: uid, Group: true}, nil
+}
+
+func (me *groupRepo) FindMember(roomId, userId string) (*DBMember, error) {
+	if userId != "owner" {
+		return nil, fmt.Errorf("member unavailable")
+	}
+	return &DBMember{RoomID: roomId, UserID: userId, Role: Owner}, nil
+}
+
+func (me *groupRepo) CountMembers(roomId string) (int64, error) {
+	return 1, nil
+}
+
+func (me *groupRepo) SaveMember(member *Member) (*DBMember, error) {
+	me.saved = append(me.saved, member.UserID)
+	return &DBMember{RoomID: member.RoomID, UserID: member.UserID, Role: member.Role}, nil
+}
+
+// usernameRepo finds any user, its uid is its username
+type usernameRepo struct {
+	user.I_UserRepo
+}
+
+func (me *usernameRepo) FindUserByUsername(username string) (*user.DBUser, error) {
+	return &user.DBUser{UID: username, Username: username}, nil
+}
+
+// blockedBy tells the users who blocked the owner
+type blockedBy map[string]bool
+
+func (me blockedBy) IsBlocked(uid1, uid2 string) (bool, error) {
+	return me[uid1] || me[uid2], nil
+}
+
+func Test_AddGroupMembersBlocked(t *testing.T) {
+	asserts := assert.New(t)
+	repo := &groupRepo{}
+	controller := NewRoomMemberController(repo, &usernameRepo{})
+	controller.blocks = blockedBy{"blocker": true}
+
+	owner := &auth.Claims{ID: "owner", Usr: "owner"}
+	req := &GroupMembersRequest{UID: "owner", RoomID: uuid.New().String(), Members: []string{"friend", "blocker"}}
+
+	_, rpcErr, _ := controller.AddGroupMembers(owner, req)
+	asserts.NotNil(rpcErr)
+	asserts.Equal("blocker: user unavailable", rpcErr.Message)
+	asserts.Empty(repo.saved)
+
+	req.Members = []string{"friend"}
+	added, rpcErr, _ := controller.AddGroupMembers(owner, req)
+	asserts.Nil(rpcErr)
+	asserts.Len(added, 1)
+	asserts.Equal([]string{"friend"}, repo.saved)
+}
+
+func Test_ClearHistory(t *testing.T) {
+	asserts := assert.New(t)
+	db := testDB(t)
+	repo := NewRoomMemberService(db.Collection("rooms"), db.Collection("roommembers"), context.Background())
+
+	_, err := repo.SaveMember(&Member{RoomID: "g", UserID: "a", Role: Common})
+	asserts.Nil(err)
+
+	// nothing to clear in an empty room
+	member, err := repo.ClearHistory("g", "a")
+	asserts.Nil(err)
+	asserts.Nil(member.ClearedUpTo)
+
+	save := func(roomId string) primitive.ObjectID {
+		id := primitive.NewObjectID()
+		_, err := db.Collection("messages").InsertOne(context.Background(), bson.M{"_id": id, "room": roomId})
+		asserts.Nil(err)
+		return id
+	}
+
+	save("g")
+	latest := save("g")
+	save("other")
+
+	// the cutoff is the latest message of the room
+	member, err = repo.ClearHistory("g", "a")
+	asserts.Nil(err)
+	asserts.Equal(latest, *member.ClearedUpTo)
+
+	marks, err := repo.FindClearedMarks("a")
+	asserts.Nil(err)
+	asserts.Equal(map[string]primitive.ObjectID{"g": latest}, marks)
+
+	// a later clear moves the cutoff to the messages sent since
+	next := save("g")
+	member, err = repo.ClearHistory("g", "a")
+	asserts.Nil(err)
+	asserts.Equal(next, *member.ClearedUpTo)
+}
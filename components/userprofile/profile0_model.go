@@ -48,6 +48,8 @@ type CreateProfile struct {
 	PPic      string    `json:"ppic" bson:"ppic"`
 	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	KeyID     string    `json:"-" bson:"key_id,omitempty"`
+	DataKey   []byte    `json:"-" bson:"data_key,omitempty"`
 }
 
 type ResponseSeeOther struct {
@@ -60,6 +62,8 @@ type ResponseSeeOther struct {
 	CreatedAt time.Time                `json:"created_at"`
 	UpdatedAt time.Time                `json:"updated_at"`
 	Contact   *contacts.ResponseStatus `json:"contact"`
+	KeyID     string                   `json:"-" bson:"key_id,omitempty"`
+	DataKey   []byte                   `json:"-" bson:"data_key,omitempty"`
 }
 
 type DBProfile struct {
@@ -70,6 +74,9 @@ type DBProfile struct {
 	PPic      string             `json:"ppic" bson:"ppic"`
 	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	// encryption at rest of status and bio, see the keyring package
+	KeyID   string `json:"-" bson:"key_id,omitempty"`
+	DataKey []byte `json:"-" bson:"data_key,omitempty"`
 }
 
 type Contact struct {
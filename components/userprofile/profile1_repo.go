@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"pesatu/components/contacts"
 	"pesatu/utils"
 	"time"
 
@@ -20,6 +21,7 @@ type I_ProfileRepo interface {
 	FindProfiles(page int, limit int) ([]*DBProfile, error)
 	DeleteProfile(obId primitive.ObjectID) error
 	SeeOtherProfile(uidOwner, toUsername string) (*ResponseSeeOther, error)
+	RotateKeys(batch int) (int, error)
 }
 
 type ProfileService struct {
@@ -36,7 +38,12 @@ func (p *ProfileService) CreateProfile(newProfile *CreateProfile) (*DBProfile, e
 	newProfile.CreatedAt = time.Now()
 	newProfile.UpdatedAt = newProfile.CreatedAt
 
-	res, err := p.collection.InsertOne(p.ctx, newProfile)
+	sealed := *newProfile
+	if err := sealFields(&sealed.Status, &sealed.Bio, &sealed.KeyID, &sealed.DataKey); err != nil {
+		return nil, err
+	}
+
+	res, err := p.collection.InsertOne(p.ctx, &sealed)
 	if err != nil {
 		if er, ok := err.(mongo.WriteException); ok && er.WriteErrors[0].Code == 11000 {
 			return nil, errors.New("owner already exists")
@@ -59,12 +66,21 @@ func (p *ProfileService) CreateProfile(newProfile *CreateProfile) (*DBProfile, e
 		return nil, err
 	}
 
+	if err := openProfile(profile); err != nil {
+		return nil, err
+	}
+
 	return profile, nil
 }
 
 func (p *ProfileService) UpdateProfile(obId primitive.ObjectID, profile *DBProfile) (*DBProfile, error) {
 	profile.UpdatedAt = time.Now()
-	doc, err := utils.ToDoc(profile)
+	sealed := *profile
+	if err := sealFields(&sealed.Status, &sealed.Bio, &sealed.KeyID, &sealed.DataKey); err != nil {
+		return nil, err
+	}
+
+	doc, err := utils.ToDoc(&sealed)
 	if err != nil {
 		return nil, err
 	}
@@ -80,6 +96,10 @@ func (p *ProfileService) UpdateProfile(obId primitive.ObjectID, profile *DBProfi
 		return nil, errors.New("profile doesn't exist")
 	}
 
+	if err := openProfile(updatedProfile); err != nil {
+		return nil, err
+	}
+
 	return updatedProfile, nil
 }
 
@@ -95,6 +115,10 @@ func (p *ProfileService) FindProfileByOwner(owner string) (*DBProfile, error) {
 		return nil, err
 	}
 
+	if err := openProfile(profile); err != nil {
+		return nil, err
+	}
+
 	return profile, nil
 }
 
@@ -132,6 +156,10 @@ func (p *ProfileService) FindProfiles(page int, limit int) ([]*DBProfile, error)
 			return nil, err
 		}
 
+		if err := openProfile(profile); err != nil {
+			return nil, err
+		}
+
 		profiles = append(profiles, profile)
 	}
 
@@ -258,6 +286,8 @@ func (me *ProfileService) SeeOtherProfile(uidOwner, toUsername string) (*Respons
 			"created_at": "$created_at",
 			"updated_at": "$updated_at",
 			"contact":    "$contact",
+			"key_id":     "$profile.key_id",
+			"data_key":   "$profile.data_key",
 		}},
 		{"$limit": 1},
 	}
@@ -276,6 +306,15 @@ func (me *ProfileService) SeeOtherProfile(uidOwner, toUsername string) (*Respons
 		if err != nil {
 			return nil, err
 		}
+
+		// a user who blocked the caller is not shown to them
+		if ctt.Contact != nil && ctt.Contact.Status == contacts.Blocked {
+			continue
+		}
+
+		if err := openFields(&ctt.Status, &ctt.Bio, ctt.KeyID, ctt.DataKey); err != nil {
+			return nil, err
+		}
 		result = append(result, ctt)
 	}
 
@@ -0,0 +1,110 @@
+package userprofile
+
+import (
+	"fmt"
+	"pesatu/keyring"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sealFields encrypts status and bio in place under a new data key when encryption at rest is enabled
+func sealFields(status, bio *string, keyID *string, dataKey *[]byte) error {
+	ring := keyring.Get()
+	if ring == nil {
+		return nil
+	}
+
+	dk, err := ring.NewDataKey()
+	if err != nil {
+		return err
+	}
+
+	if *status, err = dk.Seal("status", *status); err != nil {
+		return err
+	}
+	if *bio, err = dk.Seal("bio", *bio); err != nil {
+		return err
+	}
+	*keyID, *dataKey = dk.KeyID, dk.Wrapped
+
+	return nil
+}
+
+// openFields decrypts status and bio in place, a profile without key id is plain
+func openFields(status, bio *string, keyID string, dataKey []byte) error {
+	var err error
+	if *status, err = keyring.OpenField(keyID, dataKey, "status", *status); err != nil {
+		return err
+	}
+
+	*bio, err = keyring.OpenField(keyID, dataKey, "bio", *bio)
+	return err
+}
+
+func openProfile(p *DBProfile) error {
+	return openFields(&p.Status, &p.Bio, p.KeyID, p.DataKey)
+}
+
+// RotateKeys re-encrypts profiles with the active master key, batch by batch, including the ones
+// saved in plain text before encryption was enabled. It returns how many profiles were updated.
+func (p *ProfileService) RotateKeys(batch int) (int, error) {
+	ring := keyring.Get()
+	if ring == nil {
+		return 0, fmt.Errorf("encryption at rest is disabled")
+	}
+
+	filter := bson.M{"key_id": bson.M{"$ne": ring.Active()}}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(batch))
+
+	total := 0
+	var last interface{}
+	for {
+		if last != nil {
+			filter["_id"] = bson.M{"$gt": last}
+		}
+
+		cursor, err := p.collection.Find(p.ctx, filter, opts)
+		if err != nil {
+			return total, err
+		}
+
+		var profiles []*DBProfile
+		if err := cursor.All(p.ctx, &profiles); err != nil {
+			return total, err
+		}
+
+		for _, profile := range profiles {
+			// unchanged since read, the key id of a plain profile is missing
+			current := bson.M{"_id": profile.Id, "key_id": bson.M{"$exists": false}}
+			if len(profile.KeyID) > 0 {
+				current["key_id"] = profile.KeyID
+			}
+
+			if err := openProfile(profile); err != nil {
+				return total, fmt.Errorf("profile %s: %s", profile.Id.Hex(), err.Error())
+			}
+
+			if err := sealFields(&profile.Status, &profile.Bio, &profile.KeyID, &profile.DataKey); err != nil {
+				return total, err
+			}
+
+			update := bson.M{"$set": bson.M{
+				"status":   profile.Status,
+				"bio":      profile.Bio,
+				"key_id":   profile.KeyID,
+				"data_key": profile.DataKey,
+			}}
+			res, err := p.collection.UpdateOne(p.ctx, current, update)
+			if err != nil {
+				return total, err
+			}
+			total += int(res.ModifiedCount)
+		}
+
+		if len(profiles) < batch {
+			return total, nil
+		}
+		last = profiles[len(profiles)-1].Id
+	}
+}
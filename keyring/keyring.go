@@ -0,0 +1,269 @@
+// Package keyring encrypts document fields at rest with envelope encryption. Every document gets
+// its own data key, stored next to the fields wrapped by a master key, with the id of that master key.
+// Rotating the master key re-encrypts the documents in batches, see RotateKeys of the repositories.
+//
+// Encrypted fields can not be searched by MongoDB, words of a message are kept as blind index tokens
+// instead: a keyed hash of each lower case word. A search hashes its words the same way and matches
+// documents having all of them, so it finds whole words only, in any order, and equal words of
+// different documents have equal tokens. Snippets and highlights are computed after decryption.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// bytes of a master, data or index key, AES-256
+const KeySize = 32
+
+// IndexKeyID names the entry of the key list that keys the search tokens instead of encrypting,
+// it stays the same across rotations since changing it would hide every document from search
+const IndexKeyID = "index"
+
+// bytes of a search token
+const tokenSize = 16
+
+var ring *Keyring
+
+// Set enables encryption at rest for every repository, nil disables it
+func Set(k *Keyring) {
+	ring = k
+}
+
+// Get returns nil when encryption at rest is disabled
+func Get() *Keyring {
+	return ring
+}
+
+type Keyring struct {
+	keys     map[string]cipher.AEAD
+	active   string
+	indexKey []byte
+}
+
+// Parse reads a key list of id:base64key entries separated by commas, spaces or lines. New documents
+// are encrypted with the active key, the last master key listed when active is empty.
+func Parse(list, active string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	last := ""
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid key entry, expected id:base64key")
+		}
+
+		id := parts[0]
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes in base64", id, KeySize)
+		}
+
+		if id == IndexKeyID {
+			k.indexKey = key
+			continue
+		}
+
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key %s", id)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		last = id
+	}
+
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("no master key")
+	}
+
+	if k.indexKey == nil {
+		return nil, fmt.Errorf("no %s key for the search tokens", IndexKeyID)
+	}
+
+	k.active = last
+	if len(active) > 0 {
+		if _, ok := k.keys[active]; !ok {
+			return nil, fmt.Errorf("active key %s is not listed", active)
+		}
+		k.active = active
+	}
+
+	return k, nil
+}
+
+// Load parses the key list of a file, which should only be readable by the api user
+func Load(file, active string) (*Keyring, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(string(b), active)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additional []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+// Active is the id of the master key of new documents, a document with another one needs rotation
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// DataKey encrypts the fields of one document
+type DataKey struct {
+	KeyID   string
+	Wrapped []byte
+	aead    cipher.AEAD
+}
+
+// NewDataKey creates a data key wrapped by the active master key
+func (k *Keyring) NewDataKey() (*DataKey, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(k.keys[k.active], raw, []byte(k.active))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{KeyID: k.active, Wrapped: wrapped, aead: aead}, nil
+}
+
+// OpenDataKey unwraps the data key of a document with the master key it names
+func (k *Keyring) OpenDataKey(keyID string, wrapped []byte) (*DataKey, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", keyID)
+	}
+
+	raw, err := open(master, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("can not open data key: %s", err.Error())
+	}
+
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{KeyID: keyID, Wrapped: wrapped, aead: aead}, nil
+}
+
+// Seal encrypts the value of a field, bound to the field name so values can not be swapped.
+// An empty value stays empty, like the content of a deleted message.
+func (d *DataKey) Seal(field, value string) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+
+	b, err := seal(d.aead, []byte(value), []byte(field))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (d *DataKey) Open(field, value string) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted %s", field)
+	}
+
+	plain, err := open(d.aead, b, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("can not decrypt %s: %s", field, err.Error())
+	}
+
+	return string(plain), nil
+}
+
+// Words splits a text into its distinct lower case words, the unit of the search tokens
+func Words(text string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+
+	return words
+}
+
+// Tokens hashes words into blind index tokens
+func (k *Keyring) Tokens(words []string) []string {
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		mac := hmac.New(sha256.New, k.indexKey)
+		mac.Write([]byte(w))
+		tokens = append(tokens, base64.RawStdEncoding.EncodeToString(mac.Sum(nil)[:tokenSize]))
+	}
+
+	return tokens
+}
+
+// OpenField decrypts one field of a document with the global keyring, a document without key id is plain
+func OpenField(keyID string, wrapped []byte, field, value string) (string, error) {
+	if len(keyID) == 0 {
+		return value, nil
+	}
+
+	if ring == nil {
+		return "", fmt.Errorf("%s is encrypted but no encryption key is configured", field)
+	}
+
+	d, err := ring.OpenDataKey(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	return d.Open(field, value)
+}
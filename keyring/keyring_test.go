@@ -0,0 +1,81 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), KeySize)))
+}
+
+func Test_Parse(t *testing.T) {
+	asserts := assert.New(t)
+
+	k, err := Parse("k1:"+testKey('a')+",\nk2:"+testKey('b')+" index:"+testKey('c'), "")
+	asserts.Nil(err)
+	asserts.Equal("k2", k.Active())
+
+	k, err = Parse("k1:"+testKey('a')+",k2:"+testKey('b')+",index:"+testKey('c'), "k1")
+	asserts.Nil(err)
+	asserts.Equal("k1", k.Active())
+
+	_, err = Parse("k1:"+testKey('a'), "")
+	asserts.NotNil(err)
+
+	_, err = Parse("k1:"+testKey('a')+",index:"+testKey('c'), "k3")
+	asserts.NotNil(err)
+
+	_, err = Parse("k1:c2hvcnQ=,index:"+testKey('c'), "")
+	asserts.NotNil(err)
+}
+
+func Test_SealOpen(t *testing.T) {
+	asserts := assert.New(t)
+
+	old, _ := Parse("k1:"+testKey('a')+",index:"+testKey('c'), "")
+	dk, err := old.NewDataKey()
+	asserts.Nil(err)
+	asserts.Equal("k1", dk.KeyID)
+
+	sealed, err := dk.Seal("bio", "hello world")
+	asserts.Nil(err)
+	asserts.NotEqual("hello world", sealed)
+
+	empty, _ := dk.Seal("bio", "")
+	asserts.Equal("", empty)
+
+	// a rotated keyring still opens documents of the previous key
+	rotated, _ := Parse("k1:"+testKey('a')+",k2:"+testKey('b')+",index:"+testKey('c'), "")
+	opened, err := rotated.OpenDataKey(dk.KeyID, dk.Wrapped)
+	asserts.Nil(err)
+
+	plain, err := opened.Open("bio", sealed)
+	asserts.Nil(err)
+	asserts.Equal("hello world", plain)
+
+	// a value can not be moved to another field
+	_, err = opened.Open("status", sealed)
+	asserts.NotNil(err)
+
+	removed, _ := Parse("k2:"+testKey('b')+",index:"+testKey('c'), "")
+	_, err = removed.OpenDataKey(dk.KeyID, dk.Wrapped)
+	asserts.NotNil(err)
+}
+
+func Test_Tokens(t *testing.T) {
+	asserts := assert.New(t)
+
+	asserts.Equal([]string{"hello", "world", "example", "com"}, Words("Hello, world! hello example.com"))
+
+	k, _ := Parse("k1:"+testKey('a')+",index:"+testKey('c'), "")
+	rotated, _ := Parse("k2:"+testKey('b')+",index:"+testKey('c'), "")
+
+	tokens := k.Tokens([]string{"hello", "world"})
+	asserts.Len(tokens, 2)
+	asserts.NotEqual(tokens[0], tokens[1])
+	asserts.Equal(tokens, rotated.Tokens([]string{"hello", "world"}))
+}
@@ -7,15 +7,23 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"pesatu/app/chat"
 	"pesatu/auth"
+	"pesatu/components/attachment"
 	"pesatu/components/contacts"
+	"pesatu/components/e2ekey"
 	"pesatu/components/images"
+	"pesatu/components/messageDB"
+	"pesatu/components/presence"
 	"pesatu/components/roommember"
 	"pesatu/components/user"
 	"pesatu/components/userprofile"
+	"pesatu/keyring"
 	"pesatu/utils"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -55,6 +63,8 @@ var (
 	gcli           string
 	gsec           string
 	gdir           string
+	redisURL       string
+	rotateKeys     bool
 )
 
 func showHelp() {
@@ -65,6 +75,7 @@ func showHelp() {
 	fmt.Println("      -v {0-2} (verbosity level, default 0)")
 	fmt.Println("      -dev {0-2} (developer mode, default disabled (0), enable cors (1), also enable delay (2))")
 	fmt.Println("      -env .env file location path, default current")
+	fmt.Println("      -rotate-keys (re-encrypt messages and profiles with the active encryption key, then exit)")
 }
 
 func loadViCallConfig() bool {
@@ -120,6 +131,7 @@ func parse() bool {
 	flag.IntVar(&verbosityLevel, "v", -1, "verbosity level, higher value - more logs")
 	flag.IntVar(&DevMode, "dev", 0, "dev mode to enable/disable developer mode")
 	flag.StringVar(&Env, "env", "", ".env file location path")
+	flag.BoolVar(&rotateKeys, "rotate-keys", false, "re-encrypt messages and profiles with the active encryption key, then exit")
 	help := flag.Bool("h", false, "help info")
 	flag.Parse()
 
@@ -180,6 +192,49 @@ func readEnv() {
 	certFile = os.Getenv("CertFile")
 	privkey = os.Getenv("KeyFile")
 	mongosh = os.Getenv("Mongosh")
+	redisURL = os.Getenv("Redis")
+
+	attachmentTypes := os.Getenv("AttachmentTypes")
+	attachmentSize := os.Getenv("AttachmentMaxSize")
+	if len(attachmentTypes) > 0 || len(attachmentSize) > 0 {
+		size, _ := strconv.ParseInt(attachmentSize, 10, 64)
+		var types []string
+		for _, t := range strings.Split(attachmentTypes, ",") {
+			if t = strings.TrimSpace(t); len(t) > 0 {
+				types = append(types, t)
+			}
+		}
+		attachment.SetConfig(size, types)
+	}
+
+	if maxPins, err := strconv.Atoi(os.Getenv("MaxPinsPerRoom")); err == nil {
+		messageDB.SetMaxPins(maxPins)
+	}
+
+	if hours, err := strconv.Atoi(os.Getenv("OfflineEventsWindow")); err == nil {
+		chat.SetOfflineEventWindow(time.Duration(hours) * time.Hour)
+	}
+
+	chat.SetDeadLetterFile(os.Getenv("DeadLetterFile"))
+
+	// encryption at rest, a misconfigured key list stops the api rather than saving plain text
+	encryptionKeys := os.Getenv("EncryptionKeys")
+	encryptionKeyFile := os.Getenv("EncryptionKeyFile")
+	if len(encryptionKeys) > 0 || len(encryptionKeyFile) > 0 {
+		var ring *keyring.Keyring
+		var err error
+		if len(encryptionKeyFile) > 0 {
+			ring, err = keyring.Load(encryptionKeyFile, os.Getenv("EncryptionActiveKey"))
+		} else {
+			ring, err = keyring.Parse(encryptionKeys, os.Getenv("EncryptionActiveKey"))
+		}
+		if err != nil {
+			utils.Log().Error(err, "error reading encryption keys")
+			os.Exit(-1)
+		}
+		utils.Log().V(2).Info("encryption at rest enabled, active key: " + ring.Active())
+		keyring.Set(ring)
+	}
 
 	google := os.Getenv("GOCLI")
 	if len(google) > 0 {
@@ -198,6 +253,32 @@ func readEnv() {
 	}
 }
 
+// rotateEncryptionKeys re-encrypts the messages and profiles which are not under the active key yet
+func rotateEncryptionKeys(mongoclient *mongo.Client) {
+	const batch = 500
+
+	if keyring.Get() == nil {
+		logger.Error(nil, "encryption at rest is disabled, set EncryptionKeys or EncryptionKeyFile")
+		return
+	}
+
+	db := mongoclient.Database("pesatu")
+	msgRepo := messageDB.NewMsgRepository(db.Collection("users"), db.Collection("messages"), ctx)
+	n, err := msgRepo.RotateKeys(batch)
+	logger.Info(fmt.Sprintf("%d messages re-encrypted", n))
+	if err != nil {
+		logger.Error(err, "error while re-encrypting messages")
+		return
+	}
+
+	profileRepo := userprofile.NewProfileService(db.Collection("users"), db.Collection("profiles"), ctx)
+	n, err = profileRepo.RotateKeys(batch)
+	logger.Info(fmt.Sprintf("%d profiles re-encrypted", n))
+	if err != nil {
+		logger.Error(err, "error while re-encrypting profiles")
+	}
+}
+
 func main() {
 	if !parse() {
 		showHelp()
@@ -240,6 +321,11 @@ func main() {
 
 	logger.Info("MongoDB successfully connected...")
 
+	if rotateKeys {
+		rotateEncryptionKeys(mongoclient)
+		return
+	}
+
 	if DevMode == 0 {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -322,13 +408,38 @@ func main() {
 	ContactRouteController := contacts.NewContactRoute(mongoclient, ctx, logger, limiter, UserRouteController.GetUserService())
 	ContactRouteController.InitRouteTo(server)
 
-	RMRouteController := roommember.NewRoomMemberRoute(mongoclient, ctx, limiter)
+	RMRouteController := roommember.NewRoomMemberRoute(mongoclient, ctx, limiter, UserRouteController.GetUserService())
 	RMRouteController.InitRouteTo(server)
 
+	AttachmentRouteController := attachment.NewAttachmentRoute(mongoclient, ctx, logger, limiter)
+	AttachmentRouteController.InitRouteTo(server)
+
+	MessageRouteController := messageDB.NewMessageRoute(mongoclient, ctx, logger, limiter, UserRouteController.GetUserService())
+	MessageRouteController.InitRouteTo(server)
+
+	PresenceRouteController := presence.NewPresenceRoute(mongoclient, ctx, logger, limiter, ContactRouteController.GetContactService())
+	PresenceRouteController.InitRouteTo(server)
+
+	KeyRouteController := e2ekey.NewKeyRoute(mongoclient, ctx, logger, limiter, ContactRouteController.GetContactService())
+	KeyRouteController.InitRouteTo(server)
+
 	//app:
 
+	// share chat rooms between api replicas when redis is configured
+	var broker chat.Broker = chat.NewMemoryBroker()
+	if len(redisURL) > 0 {
+		redisBroker, err := chat.NewRedisBroker(ctx, redisURL)
+		if err != nil {
+			panic(err)
+		}
+		defer redisBroker.Close()
+		broker = redisBroker
+		logger.Info("Redis broker successfully connected...")
+	}
+
+	var wsServer *chat.WsServer
 	if !loadViCallConfig() {
-		wsServer := chat.NewWebsocketServer(mongoclient, ctx, nil)
+		wsServer = chat.NewWebsocketServer(mongoclient, ctx, nil, broker)
 		wsServer.InitRouteTo(server, ContactRouteController.GetContactService(), allowOrigin)
 		go wsServer.Run()
 	} else {
@@ -338,19 +449,50 @@ func main() {
 		dc := s.NewDatachannel(sfu.APIChannelLabel) //ion-sfu
 		dc.Use(datachannel.SubscriberAPI)
 
-		wsServer := chat.NewWebsocketServer(mongoclient, ctx, s)
+		wsServer = chat.NewWebsocketServer(mongoclient, ctx, s, broker)
 		wsServer.InitRouteTo(server, ContactRouteController.GetContactService(), allowOrigin)
 		go wsServer.Run()
 	}
 
+	// group membership changes are pushed to the rooms
+	RMRouteController.SetNotifier(wsServer)
+
+	// blocked users can not be added to the groups of each other
+	RMRouteController.SetBlockChecker(ContactRouteController.GetContactService())
+
+	// contact changes are pushed to the other user, or kept until they reconnect
+	ContactRouteController.SetNotifier(wsServer)
+
+	// online state is known by the websocket server
+	PresenceRouteController.SetTracker(wsServer)
+
 	// Use the redirectToAppMiddleware middleware to wrap the handler
 	//server.Use(redirectToAppMiddleware())
 
-	// Serve over HTTPS with SSL certificate and private key files
-	err = s.RunTLS(Addr, certFile, privkey)
-	if err != nil {
-		s.Run(Addr)
+	// Serve over HTTPS with SSL certificate and private key files, over HTTP without them
+	httpServer := &http.Server{Addr: Addr, Handler: s}
+	go func() {
+		err := httpServer.ListenAndServeTLS(certFile, privkey)
+		if err != nil && err != http.ErrServerClosed {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error(err, "error while serving http")
+		}
+	}()
+
+	// on interrupt, stop taking requests then save the queued chat messages
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	logger.Info("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err, "error while shutting down http server")
 	}
+	wsServer.Shutdown()
 }
 
 // func redirectToAppMiddleware() gin.HandlerFunc {